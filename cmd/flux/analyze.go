@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ashavijit/fluxfile/internal/analyze"
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/graph"
+	"github.com/ashavijit/fluxfile/internal/logs"
+)
+
+// runAnalyzeCommand implements flux analyze <task>, also available as flux
+// why-slow <task>.
+func runAnalyzeCommand(fluxFile *ast.FluxFile, args []string, parallel, jsonOutput bool) error {
+	var target string
+	for _, arg := range args {
+		switch arg {
+		case "--json", "-json":
+			jsonOutput = true
+		default:
+			target = arg
+		}
+	}
+	if target == "" {
+		return fmt.Errorf("usage: flux analyze <task>")
+	}
+
+	analysis, err := analyzeTask(fluxFile, target, parallel)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(analysis)
+	}
+	analysis.Print(os.Stdout)
+	return nil
+}
+
+// analyzeTask computes the critical path of target from the runs in the
+// log store. parallel is the parallel setting, which target may turn on
+// for itself.
+func analyzeTask(fluxFile *ast.FluxFile, target string, parallel bool) (*analyze.Analysis, error) {
+	g, err := graph.BuildGraph(fluxFile.Tasks)
+	if err != nil {
+		return nil, err
+	}
+	task, err := g.GetTask(target)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := logs.ListRuns(logs.GetLogDir())
+	if err != nil {
+		return nil, err
+	}
+	return analyze.Analyze(g, target, analyze.CollectStats(runs), parallel || task.Parallel)
+}
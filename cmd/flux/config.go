@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ashavijit/fluxfile/internal/config"
+)
+
+// runConfigCommand implements flux config list, get KEY and set KEY VALUE.
+// set writes the project config unless global is true.
+func runConfigCommand(cfg *config.FluxConfig, args []string, global bool) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		for _, kv := range cfg.List() {
+			fmt.Printf("%s%s%s = %s\n", colorGreen, kv[0], colorReset, kv[1])
+		}
+		return nil
+	case "get":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: flux config get KEY")
+		}
+		value, err := cfg.Get(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: flux config set KEY VALUE [--global]")
+		}
+		path := config.ProjectConfigPath()
+		if global {
+			path = config.UserConfigPath()
+			if path == "" {
+				return fmt.Errorf("cannot determine the user config directory")
+			}
+		}
+		if err := config.SetFileValue(path, args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("Set %s = %s in %s\n", args[1], args[2], path)
+		return nil
+	}
+
+	return fmt.Errorf("unknown config command %s (expected list, get or set)", args[0])
+}
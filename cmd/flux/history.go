@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/history"
+	"github.com/ashavijit/fluxfile/internal/logger"
+	"github.com/ashavijit/fluxfile/internal/report"
+)
+
+// runHistoryCommand implements flux history [task] and flux history clear.
+func runHistoryCommand(cfg *config.FluxConfig, args []string, jsonOutput bool) error {
+	flags := flag.NewFlagSet("flux history", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	jsonFlag := flags.Bool("json", jsonOutput, "Output in JSON format")
+	count := flags.Int("n", 20, "Number of runs to show")
+	threshold := flags.Int("threshold", cfg.HistoryRegression, "Percent slower than average that counts as a regression")
+	rest, err := parseFlags(flags, args)
+	if err != nil {
+		return fmt.Errorf("flux history: %w", err)
+	}
+
+	if len(rest) > 0 && rest[0] == "clear" {
+		if err := history.Clear(cfg.HistoryDir); err != nil {
+			return err
+		}
+		fmt.Println("Cleared run history")
+		return nil
+	}
+
+	runs, err := history.Load(cfg.HistoryDir)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		trends := history.Trends(runs, *threshold)
+		if *jsonFlag {
+			return printJSON(trends)
+		}
+		if len(trends) == 0 {
+			fmt.Println("No history yet. Run some tasks first.")
+			return nil
+		}
+		printTrends(trends)
+		return nil
+	}
+
+	trend := history.TaskTrend(runs, rest[0], *threshold)
+	if trend == nil {
+		return fmt.Errorf("no history for task %s", rest[0])
+	}
+	if *jsonFlag {
+		return printJSON(trend)
+	}
+	printTrend(trend, *count)
+	return nil
+}
+
+func printTrends(trends []*history.Trend) {
+	fmt.Printf("%s%-24s  %5s  %5s  %9s  %9s  %9s  %-10s  %s%s\n", colorGray,
+		"TASK", "RUNS", "FAIL", "P50", "P90", "LATEST", "TREND", "FLAGS", colorReset)
+	for _, t := range trends {
+		fmt.Printf("%-24s  %5d  %4.0f%%  %9s  %9s  %9s  %-10s  %s\n",
+			t.Task, t.Runs, t.FailureRate*100,
+			formatLogDuration(t.P50), formatLogDuration(t.P90), formatLogDuration(t.Latest),
+			sparkline(passedDurations(t.Samples, 10)), trendFlags(t))
+	}
+}
+
+func printTrend(t *history.Trend, count int) {
+	fmt.Printf("%s%s%s\n", colorCyan, t.Task, colorReset)
+	fmt.Printf("  Runs:     %d, %d failed (%.0f%%)\n", t.Runs, t.Failures, t.FailureRate*100)
+	fmt.Printf("  Duration: p50 %s, p90 %s, p99 %s\n",
+		formatLogDuration(t.P50), formatLogDuration(t.P90), formatLogDuration(t.P99))
+	if t.Average > 0 {
+		fmt.Printf("  Latest:   %s, average of the %d runs before %s\n",
+			formatLogDuration(t.Latest), min(history.Window, len(passedDurations(t.Samples, 0))-1), formatLogDuration(t.Average))
+	}
+	if t.Regression {
+		fmt.Printf("  %sRegression: %.0f%% slower than its average%s\n", colorRed, t.Slowdown, colorReset)
+	}
+	if t.Flaky {
+		fmt.Printf("  %sFlaky: passed and failed on the same inputs (%s)%s\n", colorYellow, strings.Join(shortHashes(t.FlakyInputs), ", "), colorReset)
+	}
+
+	samples := t.Samples
+	if count > 0 && len(samples) > count {
+		samples = samples[len(samples)-count:]
+	}
+	var longest time.Duration
+	for _, s := range samples {
+		longest = max(longest, s.Duration)
+	}
+	fmt.Println()
+	for i := len(samples) - 1; i >= 0; i-- {
+		s := samples[i]
+		bar := ""
+		if longest > 0 {
+			bar = strings.Repeat("█", int(float64(s.Duration)/float64(longest)*30+0.5))
+		}
+		fmt.Printf("  %s  %s%-7s%s  %9s  %-8s  %s\n",
+			s.Time.Local().Format("2006-01-02 15:04:05"),
+			statusColor(s.Status), s.Status, colorReset,
+			formatLogDuration(s.Duration),
+			strings.Join(shortHashes([]string{s.InputHash}), ""), bar)
+	}
+}
+
+// passedDurations returns the durations of the last n passing samples, or
+// of all of them when n is 0.
+func passedDurations(samples []history.Sample, n int) []time.Duration {
+	var durations []time.Duration
+	for _, s := range samples {
+		if s.Status == "passed" {
+			durations = append(durations, s.Duration)
+		}
+	}
+	if n > 0 && len(durations) > n {
+		durations = durations[len(durations)-n:]
+	}
+	return durations
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws durations as bars scaled between the shortest and the
+// longest.
+func sparkline(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "-"
+	}
+	lo, hi := durations[0], durations[0]
+	for _, d := range durations {
+		lo, hi = min(lo, d), max(hi, d)
+	}
+	var b strings.Builder
+	for _, d := range durations {
+		i := 0
+		if hi > lo {
+			i = int(float64(d-lo) / float64(hi-lo) * float64(len(sparks)-1))
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
+
+func trendFlags(t *history.Trend) string {
+	var flags []string
+	if t.Regression {
+		flags = append(flags, fmt.Sprintf("%sregressed +%.0f%%%s", colorRed, t.Slowdown, colorReset))
+	}
+	if t.Flaky {
+		flags = append(flags, colorYellow+"flaky"+colorReset)
+	}
+	return strings.Join(flags, ", ")
+}
+
+func shortHashes(hashes []string) []string {
+	short := make([]string, len(hashes))
+	for i, h := range hashes {
+		if len(h) > 8 {
+			h = h[:8]
+		}
+		short[i] = h
+	}
+	return short
+}
+
+// recordHistory appends the run in rep to the history and warns about the
+// tasks of the run that turned out flaky or slower than usual.
+func recordHistory(cfg *config.FluxConfig, log *logger.Logger, runID, target string, rep *report.Report) {
+	run := history.FromReport(runID, target, rep)
+	if err := history.Append(cfg.HistoryDir, run, cfg.HistoryKeep); err != nil {
+		log.Warn(fmt.Sprintf("Failed to record run history: %s", err.Error()))
+		return
+	}
+	runs, err := history.Load(cfg.HistoryDir)
+	if err != nil {
+		return
+	}
+
+	for _, task := range run.Tasks {
+		trend := history.TaskTrend(runs, task.Name, cfg.HistoryRegression)
+		if trend == nil {
+			continue
+		}
+		if task.Status == "passed" && trend.Regression {
+			log.Warn(fmt.Sprintf("%s took %s, %.0f%% slower than its average of %s",
+				task.Name, formatLogDuration(trend.Latest), trend.Slowdown, formatLogDuration(trend.Average)))
+		}
+		for _, hash := range trend.FlakyInputs {
+			if hash == task.InputHash {
+				log.Warn(fmt.Sprintf("%s is flaky: it has passed and failed on the same inputs (see flux history %s)", task.Name, task.Name))
+			}
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/logs"
+)
+
+// tailInterval is how often flux logs tail -f looks for new entries.
+const tailInterval = 250 * time.Millisecond
+
+// runLogsCommand implements flux logs list, show, tail, grep and clear.
+// Without arguments it opens the HTML log viewer.
+func runLogsCommand(args []string, jsonOutput bool) error {
+	if len(args) == 0 {
+		handleLogs()
+		return nil
+	}
+
+	flags := flag.NewFlagSet("flux logs "+args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	jsonFlag := flags.Bool("json", jsonOutput, "Output in JSON format")
+	follow := flags.Bool("f", false, "Keep printing entries as they are written")
+	lines := flags.Int("n", 20, "Number of entries to print")
+	keep := flags.Int("keep", 0, "Keep this many of the newest runs")
+	maxAge := flags.String("max-age", "", "Remove runs older than this (e.g. 72h, 30d)")
+	rest, err := parseFlags(flags, args[1:])
+	if err != nil {
+		return fmt.Errorf("flux logs %s: %w", args[0], err)
+	}
+
+	dir := logs.GetLogDir()
+	switch args[0] {
+	case "list":
+		return listRuns(dir, *jsonFlag)
+	case "show":
+		if len(rest) > 1 {
+			return fmt.Errorf("usage: flux logs show [RUN | TASK] [--json]")
+		}
+		return showLogs(dir, strings.Join(rest, ""), *jsonFlag)
+	case "tail":
+		if len(rest) > 1 {
+			return fmt.Errorf("usage: flux logs tail [-f] [-n N] [RUN]")
+		}
+		return tailRun(dir, strings.Join(rest, ""), *lines, *follow)
+	case "grep":
+		if len(rest) != 1 {
+			return fmt.Errorf("usage: flux logs grep PATTERN [--json]")
+		}
+		return grepLogs(dir, rest[0], *jsonFlag)
+	case "clear":
+		return clearLogs(dir, *keep, *maxAge)
+	}
+
+	return fmt.Errorf("unknown logs command %s (expected list, show, tail, grep or clear)", args[0])
+}
+
+// parseFlags parses flags anywhere among args and returns the remaining
+// arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// runSummary is a run without the entries of its tasks, as printed by
+// flux logs list --json.
+type runSummary struct {
+	ID         string        `json:"id"`
+	StartTime  time.Time     `json:"start_time"`
+	DurationMS int64         `json:"duration_ms"`
+	Status     string        `json:"status"`
+	Tasks      []taskSummary `json:"tasks"`
+}
+
+type taskSummary struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	CacheHit   bool   `json:"cache_hit,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func listRuns(dir string, jsonOutput bool) error {
+	runs, err := logs.ListRuns(dir)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		summaries := make([]runSummary, 0, len(runs))
+		for i := len(runs) - 1; i >= 0; i-- {
+			run := runs[i]
+			summary := runSummary{
+				ID:         run.ID,
+				StartTime:  run.StartTime,
+				DurationMS: run.Duration().Milliseconds(),
+				Status:     run.Status,
+			}
+			for _, task := range run.Tasks {
+				summary.Tasks = append(summary.Tasks, taskSummary{
+					Name:       task.TaskName,
+					Status:     task.Status,
+					DurationMS: taskDuration(task).Milliseconds(),
+					CacheHit:   task.CacheHit,
+					Error:      task.Error,
+				})
+			}
+			summaries = append(summaries, summary)
+		}
+		return printJSON(summaries)
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No logs found. Run some tasks first.")
+		return nil
+	}
+
+	fmt.Printf("%s%-24s  %-19s  %9s  %-8s  %s%s\n", colorGray, "RUN", "STARTED", "DURATION", "STATUS", "TASKS", colorReset)
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		var names []string
+		for _, task := range run.Tasks {
+			names = append(names, task.TaskName)
+		}
+		fmt.Printf("%-24s  %-19s  %9s  %s%-8s%s  %s\n",
+			run.ID,
+			run.StartTime.Local().Format("2006-01-02 15:04:05"),
+			formatLogDuration(run.Duration()),
+			statusColor(run.Status), run.Status, colorReset,
+			strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// showLogs prints a run, or the latest log of a task when ref names no
+// run. An empty ref shows the latest run.
+func showLogs(dir, ref string, jsonOutput bool) error {
+	run, err := findRun(dir, ref)
+	if err != nil {
+		task, taskErr := findTask(dir, ref)
+		if taskErr != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(task)
+		}
+		printTaskLog(task)
+		return nil
+	}
+
+	if jsonOutput {
+		return printJSON(run)
+	}
+	fmt.Printf("Run %s%s%s  %s  %s%s%s in %s\n", colorCyan, run.ID, colorReset,
+		run.StartTime.Local().Format("2006-01-02 15:04:05"),
+		statusColor(run.Status), run.Status, colorReset, formatLogDuration(run.Duration()))
+	for _, task := range run.Tasks {
+		fmt.Println()
+		printTaskLog(task)
+	}
+	return nil
+}
+
+// findRun returns the run whose ID starts with ref, or the latest run when
+// ref is empty or "last".
+func findRun(dir, ref string) (*logs.Run, error) {
+	runs, err := logs.ListRuns(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no logs found in %s", dir)
+	}
+	if ref == "" || ref == "last" {
+		return runs[len(runs)-1], nil
+	}
+
+	var found *logs.Run
+	for _, run := range runs {
+		if run.ID == ref {
+			return run, nil
+		}
+		if strings.HasPrefix(run.ID, ref) {
+			if found != nil {
+				return nil, fmt.Errorf("%s matches several runs", ref)
+			}
+			found = run
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no run or task named %s", ref)
+	}
+	return found, nil
+}
+
+// findTask returns the latest log of the named task.
+func findTask(dir, name string) (*logs.TaskLog, error) {
+	taskLogs, err := logs.LoadLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(taskLogs) - 1; i >= 0; i-- {
+		if taskLogs[i].TaskName == name {
+			return taskLogs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no logs for task %s", name)
+}
+
+func printTaskLog(task *logs.TaskLog) {
+	status := task.Status
+	if task.CacheHit {
+		status += ", cached"
+	}
+	fmt.Printf("%s%s%s  %s%s%s", colorGreen, task.TaskName, colorReset, statusColor(task.Status), status, colorReset)
+	if task.Status != "running" {
+		fmt.Printf(" in %s", formatLogDuration(taskDuration(task)))
+	}
+	fmt.Println()
+	if task.Error != "" {
+		fmt.Printf("  %serror: %s%s\n", colorRed, task.Error, colorReset)
+	}
+	for _, entry := range task.Entries {
+		printEntry("  ", entry)
+	}
+}
+
+func printEntry(prefix string, entry logs.LogEntry) {
+	ts := entry.Timestamp.Local().Format("15:04:05")
+	if entry.Level != "cmd" {
+		color := colorGray
+		if entry.Level == "error" {
+			color = colorRed
+		}
+		fmt.Printf("%s%s%s %s%s%s\n", prefix, colorGray, ts, color, entry.Message, colorReset)
+		return
+	}
+
+	exitColor := colorGray
+	if entry.ExitCode != 0 {
+		exitColor = colorRed
+	}
+	fmt.Printf("%s%s%s %s$ %s%s %s(exit %d, %s)%s\n", prefix, colorGray, ts, colorCyan, entry.Command, colorReset,
+		exitColor, entry.ExitCode, formatLogDuration(time.Duration(entry.Duration)*time.Millisecond), colorReset)
+	if entry.Output != "" {
+		for _, line := range strings.Split(entry.Output, "\n") {
+			fmt.Printf("%s    %s\n", prefix, line)
+		}
+	}
+}
+
+// tailRun prints the last n entries of a run and, with follow, the entries
+// written after them until interrupted.
+func tailRun(dir, ref string, n int, follow bool) error {
+	run, err := findRun(dir, ref)
+	if err != nil {
+		return err
+	}
+
+	tail := logs.NewTail(run.Dir)
+	records, err := tail.Read()
+	if err != nil {
+		return err
+	}
+	var entries []logs.Record
+	for _, r := range records {
+		if r.Entry != nil {
+			entries = append(entries, r)
+		}
+	}
+	if n >= 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	for _, r := range entries {
+		printRecord(r)
+	}
+
+	for follow {
+		time.Sleep(tailInterval)
+		records, err := tail.Read()
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			printRecord(r)
+		}
+	}
+	return nil
+}
+
+func printRecord(r logs.Record) {
+	if r.Entry != nil {
+		printEntry(fmt.Sprintf("%s[%s]%s ", colorBlue, r.Entry.Task, colorReset), *r.Entry)
+		return
+	}
+
+	task := r.Task
+	prefix := fmt.Sprintf("%s[%s]%s ", colorBlue, task.TaskName, colorReset)
+	switch {
+	case task.Status == "running":
+		fmt.Printf("%s%sstarted%s\n", prefix, colorGray, colorReset)
+	case task.Error != "":
+		fmt.Printf("%s%s%s%s: %s\n", prefix, statusColor(task.Status), task.Status, colorReset, task.Error)
+	default:
+		fmt.Printf("%s%s%s%s in %s\n", prefix, statusColor(task.Status), task.Status, colorReset, formatLogDuration(taskDuration(task)))
+	}
+}
+
+// logMatch is a line of a task log that matched flux logs grep.
+type logMatch struct {
+	Run       string    `json:"run"`
+	Task      string    `json:"task"`
+	Timestamp time.Time `json:"timestamp"`
+	Line      string    `json:"line"`
+}
+
+// grepLogs prints the messages, commands and output lines of all runs that
+// match pattern.
+func grepLogs(dir, pattern string, jsonOutput bool) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	runs, err := logs.ListRuns(dir)
+	if err != nil {
+		return err
+	}
+
+	matches := make([]logMatch, 0)
+	for _, run := range runs {
+		for _, task := range run.Tasks {
+			for _, entry := range task.Entries {
+				lines := []string{entry.Message}
+				if entry.Level == "cmd" {
+					lines = append([]string{entry.Command}, strings.Split(entry.Output, "\n")...)
+				}
+				for _, line := range lines {
+					if line != "" && re.MatchString(line) {
+						matches = append(matches, logMatch{Run: run.ID, Task: task.TaskName, Timestamp: entry.Timestamp, Line: line})
+					}
+				}
+			}
+		}
+	}
+
+	if jsonOutput {
+		return printJSON(matches)
+	}
+	for _, m := range matches {
+		line := re.ReplaceAllStringFunc(m.Line, func(s string) string { return colorYellow + s + colorReset })
+		fmt.Printf("%s%s%s %s%s%s: %s\n", colorGray, m.Run, colorReset, colorBlue, m.Task, colorReset, line)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no matches for %s", pattern)
+	}
+	return nil
+}
+
+// clearLogs removes all logs, or only the runs past keep and maxAge when
+// either is set.
+func clearLogs(dir string, keep int, maxAge string) error {
+	if keep == 0 && maxAge == "" {
+		count, err := logs.ClearLogs()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cleared %d log file(s)\n", count)
+		return nil
+	}
+
+	age, err := config.ParseAge(maxAge)
+	if maxAge != "" && err != nil {
+		return err
+	}
+	count, err := logs.PruneRuns(dir, keep, age)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d run(s)\n", count)
+	return nil
+}
+
+func taskDuration(task *logs.TaskLog) time.Duration {
+	if task.EndTime.IsZero() {
+		return time.Since(task.StartTime)
+	}
+	return task.EndTime.Sub(task.StartTime)
+}
+
+func formatLogDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(10 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+func statusColor(status string) string {
+	switch status {
+	case "success", "passed":
+		return colorGreen
+	case "failed":
+		return colorRed
+	case "cached", "skipped":
+		return colorGray
+	}
+	return colorYellow
+}
+
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
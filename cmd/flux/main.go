@@ -3,17 +3,19 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 
+	"github.com/ashavijit/fluxfile/internal/ast"
 	"github.com/ashavijit/fluxfile/internal/config"
 	"github.com/ashavijit/fluxfile/internal/executor"
 	fluxinit "github.com/ashavijit/fluxfile/internal/init"
 	"github.com/ashavijit/fluxfile/internal/logger"
 	"github.com/ashavijit/fluxfile/internal/logs"
+	"github.com/ashavijit/fluxfile/internal/lsp"
 	"github.com/ashavijit/fluxfile/internal/report"
-	"github.com/ashavijit/fluxfile/internal/watcher"
 )
 
 var (
@@ -34,10 +36,14 @@ func main() {
 	log := logger.New()
 
 	taskName := flag.String("t", "", "Task to execute")
-	profile := flag.String("p", "", "Profile to apply")
+	profile := flag.String("p", "", "Profiles to apply, comma-separated (e.g. base,prod)")
 	listTasks := flag.Bool("l", false, "List all tasks")
 	showTasks := flag.Bool("show", false, "Show all tasks with enhanced UI")
 	watch := flag.Bool("w", false, "Watch mode")
+	watchDebounce := flag.String("watch-debounce", "", "Wait this long after the last change before re-running in watch mode (e.g. 300ms)")
+	watchPoll := flag.Bool("watch-poll", false, "Poll for file changes in watch mode instead of using file system events")
+	watchPollInterval := flag.String("watch-poll-interval", "", "How often to poll for file changes (e.g. 1s)")
+	outputMode := flag.String("output", "", "How task output is shown: interleaved, prefixed or grouped")
 	noCache := flag.Bool("no-cache", false, "Disable caching")
 	fluxFilePath := flag.String("f", "", "Path to FluxFile")
 	showVersion := flag.Bool("v", false, "Show version")
@@ -55,12 +61,26 @@ func main() {
 	initTemplate := flag.String("template", "", "Template for init (go, node, python, rust, generic)")
 	showReport := flag.Bool("report", false, "Show execution report after task completion")
 	reportJSON := flag.String("report-json", "", "Save execution report as JSON to specified path")
+	reportJUnit := flag.String("report-junit", "", "Save execution report as JUnit XML to specified path")
+	reportMarkdown := flag.String("report-md", "", "Save execution report as Markdown to specified path")
+	tracePath := flag.String("trace", "", "Save a Chrome trace of task execution to specified path (open in Perfetto)")
+	otelFile := flag.String("otel-file", "", "Save OpenTelemetry spans of the run as OTLP JSON to specified path")
 	showGraph := flag.Bool("graph", false, "Show dependency graph")
 	graphDot := flag.Bool("dot", false, "Output graph in Graphviz DOT format")
 	graphMermaid := flag.Bool("mermaid", false, "Output graph in Mermaid format")
+	showResolved := flag.Bool("resolved", false, "Show the effective task after inheritance (with show <task>)")
+	strictVars := flag.Bool("strict", false, "Fail on unresolved ${VAR} references in commands")
+	globalConfig := flag.Bool("global", false, "With config set, write the user config instead of the project config")
+	var cliVars, varFiles listFlag
+	flag.Var(&cliVars, "var", "Set a variable (NAME=value), can be repeated")
+	flag.Var(&varFiles, "var-file", "Load variables from a JSON or dotenv file, can be repeated")
 
 	flag.Parse()
 
+	rawArgs := parseInterspersed()
+	args, positionalVars := splitVarArgs(rawArgs)
+	cliVars = append(cliVars, positionalVars...)
+
 	if *showVersion {
 		fmt.Printf("Flux version %s\n", version)
 		return
@@ -71,7 +91,14 @@ func main() {
 		return
 	}
 
-	if *initCmd || (len(flag.Args()) > 0 && flag.Args()[0] == "init") {
+	if len(args) > 0 && args[0] == "lsp" {
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if *initCmd || (len(args) > 0 && args[0] == "init") {
 		cfg := fluxinit.Config{
 			Template:  *initTemplate,
 			Directory: ".",
@@ -82,16 +109,55 @@ func main() {
 		return
 	}
 
-	if len(flag.Args()) > 0 && flag.Args()[0] == "logs" {
-		if len(flag.Args()) > 1 && flag.Args()[1] == "clear" {
-			count, err := logs.ClearLogs()
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			fmt.Printf("Cleared %d log file(s)\n", count)
-			return
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if *watchDebounce != "" {
+		if err := cfg.Set("watch_debounce", *watchDebounce); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	if *watchPollInterval != "" {
+		if err := cfg.Set("watch_poll_interval", *watchPollInterval); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	if *watchPoll {
+		cfg.WatchPoll = true
+	}
+	if *outputMode != "" {
+		if err := cfg.Set("output", *outputMode); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+	log.SetVerbosity(cfg.Verbosity)
+	logs.SetLogDir(cfg.LogDir)
+	maxLogAge, err := cfg.MaxLogAge()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	logs.SetRetention(cfg.LogKeep, maxLogAge)
+
+	// Values such as env.OPTS=a=b must reach flux config as written.
+	if len(rawArgs) > 0 && rawArgs[0] == "config" {
+		if err := runConfigCommand(cfg, rawArgs[1:], *globalConfig); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if len(rawArgs) > 0 && rawArgs[0] == "logs" {
+		if err := runLogsCommand(rawArgs[1:], *jsonOutput); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if len(rawArgs) > 0 && rawArgs[0] == "history" {
+		if err := runHistoryCommand(cfg, rawArgs[1:], *jsonOutput); err != nil {
+			log.Fatal(err.Error())
 		}
-		handleLogs()
 		return
 	}
 
@@ -100,7 +166,6 @@ func main() {
 	}
 
 	var path string
-	var err error
 
 	if *fluxFilePath != "" {
 		path = *fluxFilePath
@@ -116,17 +181,77 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	cacheDir := filepath.Join(".flux", "cache")
-	exec, err := executor.New(fluxFile, cacheDir, *dryRun)
+	if *profile == "" {
+		*profile = cfg.DefaultProfile
+	}
+	useCache := !*noCache && !cfg.NoCache
+
+	exec, err := executor.New(fluxFile, cfg.CacheDir, *dryRun)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	exec.SetEnv(cfg.Env)
+	exec.SetParallel(cfg.Parallel)
+	exec.SetVerbosity(cfg.Verbosity)
+	exec.SetStrict(*strictVars)
+	exec.SetOutputMode(cfg.Output)
+	outputLimit, err := cfg.OutputLimit()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	exec.SetOutputLimit(outputLimit)
+	log.SetMasker(exec.Masker())
+
+	overrides, err := overrideLayers(varFiles, cliVars)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	exec.SetOverrides(mergeLayers(overrides))
+
+	if len(args) > 0 && args[0] == "vars" {
+		jsonVars := *jsonOutput
+		for _, arg := range args[1:] {
+			if arg == "--json" || arg == "-json" {
+				jsonVars = true
+			}
+		}
+		if err := showVars(fluxFile, *profile, cfg.Env, overrides, jsonVars); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if len(args) > 0 && (args[0] == "analyze" || args[0] == "why-slow") {
+		if err := runAnalyzeCommand(fluxFile, args[1:], cfg.Parallel, *jsonOutput); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
 
 	if handleLockCommands(*generateLock, *checkLock, *lockUpdate, *lockDiff, *lockClean, *updateTask, *fluxFilePath, *jsonOutput) {
 		return
 	}
 
-	if *showTasks || (len(flag.Args()) > 0 && flag.Args()[0] == "show") {
+	if *showTasks || (len(args) > 0 && args[0] == "show") {
+		var showName string
+		if len(args) > 1 {
+			for _, arg := range args[1:] {
+				switch arg {
+				case "--resolved", "-resolved":
+					*showResolved = true
+				default:
+					showName = arg
+				}
+			}
+		}
+
+		if showName != "" {
+			if err := showTaskDefinition(path, showName, *showResolved); err != nil {
+				log.Fatal(err.Error())
+			}
+			return
+		}
+
 		showTasksEnhanced(exec)
 		return
 	}
@@ -146,67 +271,105 @@ func main() {
 	}
 
 	if *taskName == "" {
-		if len(flag.Args()) > 0 {
-			*taskName = flag.Args()[0]
+		if len(args) > 0 {
+			*taskName = args[0]
 		} else {
 			log.Fatal("No task specified. Use -t <task> or provide task name as argument")
 		}
 	}
 
-	task, err := exec.GetTaskInfo(*taskName)
-	if err != nil {
+	if _, err := exec.GetTaskInfo(*taskName); err != nil {
 		log.Fatal(err.Error())
 	}
 
 	if *runTUI {
-		runInteractiveTUI(exec, *taskName, *profile, !*noCache)
+		runInteractiveTUI(exec, *taskName, *profile, useCache)
 		return
 	}
 
+	reportFiles := []reportFile{
+		{*reportJSON, "JSON", report.JSONFormatter{}},
+		{*reportJUnit, "JUnit", report.JUnitFormatter{}},
+		{*reportMarkdown, "Markdown", report.MarkdownFormatter{}},
+		{*tracePath, "trace", report.ChromeTraceFormatter{}},
+	}
+	reportRequested := *showReport || *reportJSON != "" || *reportJUnit != "" || *reportMarkdown != "" || *tracePath != ""
+	// Runs outside watch mode are recorded in the history, so their results
+	// are collected even without a report.
 	var collector *report.Collector
-	if *showReport || *reportJSON != "" {
+	if reportRequested || !*watch {
 		collector = report.NewCollector()
 		exec.SetCollector(collector)
 	}
 
-	if *watch && len(task.Watch) > 0 {
-		log.Info(fmt.Sprintf("Starting watch mode for task: %s", *taskName))
-
-		callback := func() {
-			if err := exec.Execute(*taskName, *profile, !*noCache); err != nil {
-				log.Error(err.Error())
-			}
+	if *watch {
+		targets := []string{*taskName}
+		if len(args) > 1 && args[0] == *taskName {
+			targets = args
 		}
-
-		if err := exec.Execute(*taskName, *profile, !*noCache); err != nil {
-			log.Error(err.Error())
-		}
-
-		w, err := watcher.New(task.Watch, callback)
+		index, err := exec.WatchIndex(targets, *profile)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
-
-		if err := w.Start(); err != nil {
-			log.Fatal(err.Error())
-		}
-	} else {
-		if err := exec.Execute(*taskName, *profile, !*noCache); err != nil {
-			log.Fatal(err.Error())
+		if len(index.Patterns()) > 0 {
+			session := &watchSession{
+				exec:     exec,
+				index:    index,
+				targets:  targets,
+				profile:  *profile,
+				useCache: useCache,
+				log:      log,
+			}
+			// Runs until interrupted.
+			session.watch(cfg)
 		}
 	}
 
+	// Reports are written for failed runs too, since that is when CI needs
+	// them most.
+	endTrace := startTracing(exec, *taskName, *otelFile, log)
+	execErr := exec.Execute(*taskName, *profile, useCache)
+	endTrace(execErr)
+
 	if collector != nil {
 		rep := collector.Generate()
-		if *showReport {
-			rep.Print()
+		if !*dryRun {
+			recordHistory(cfg, log, exec.RunID(), *taskName, rep)
 		}
-		if *reportJSON != "" {
-			if err := rep.WriteJSON(*reportJSON); err != nil {
-				log.Error(fmt.Sprintf("Failed to write report JSON: %s", err.Error()))
-			} else {
-				fmt.Printf("Report saved to %s\n", *reportJSON)
-			}
+		if reportRequested {
+			writeReports(rep, fluxFile, *taskName, cfg.Parallel, *showReport, reportFiles, log)
+		}
+	}
+
+	if execErr != nil {
+		log.Fatal(execErr.Error())
+	}
+}
+
+// reportFile is a report to write and the format to write it in.
+type reportFile struct {
+	path      string
+	kind      string
+	formatter report.Formatter
+}
+
+// writeReports prints rep when show is set and writes the requested report
+// files, with the critical path analysis of target.
+func writeReports(rep *report.Report, fluxFile *ast.FluxFile, target string, parallel, show bool, files []reportFile, log *logger.Logger) {
+	if analysis, err := analyzeTask(fluxFile, target, parallel); err == nil {
+		rep.Analysis = analysis
+	}
+	if show {
+		rep.Print()
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		if err := rep.WriteFile(file.path, file.formatter); err != nil {
+			log.Error(fmt.Sprintf("Failed to write report %s: %s", file.kind, err.Error()))
+		} else {
+			fmt.Printf("Report saved to %s\n", file.path)
 		}
 	}
 }
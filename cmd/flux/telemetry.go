@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ashavijit/fluxfile/internal/executor"
+	"github.com/ashavijit/fluxfile/internal/logger"
+	"github.com/ashavijit/fluxfile/internal/telemetry"
+)
+
+// startTracing traces the run of target with OpenTelemetry when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set or path is given. The returned
+// function ends the run's span and exports the trace.
+func startTracing(exec *executor.Executor, target, path string, log *logger.Logger) func(error) {
+	var exporters []telemetry.Exporter
+	if exporter := telemetry.NewOTLPExporterFromEnv(os.Getenv); exporter != nil {
+		exporters = append(exporters, exporter)
+	}
+	if path != "" {
+		exporters = append(exporters, &telemetry.FileExporter{Path: path, Service: telemetry.ServiceName(os.Getenv)})
+	}
+	if len(exporters) == 0 {
+		return func(error) {}
+	}
+
+	tracer := telemetry.NewTracer(os.Getenv("TRACEPARENT"))
+	root := tracer.Start("flux "+target, nil)
+	root.SetAttribute("flux.target", target)
+	root.SetAttribute("flux.version", version)
+	exec.SetTracer(tracer, root)
+
+	return func(err error) {
+		root.SetAttribute("flux.profile", exec.Profiles())
+		root.SetAttribute("flux.run_id", exec.RunID())
+		root.End(err)
+		for _, exporter := range exporters {
+			if err := exporter.Export(tracer.Spans()); err != nil {
+				log.Warn(fmt.Sprintf("Failed to export trace: %s", err.Error()))
+			}
+		}
+	}
+}
@@ -23,6 +23,12 @@ type TUIState struct {
 }
 
 func runInteractiveTUI(exec *executor.Executor, taskName string, profile string, useCache bool) {
+	// Grouped output would only appear once a task finishes; the TUI shows
+	// it live, labeled with the task name.
+	if exec.OutputMode() == executor.OutputGrouped {
+		exec.SetOutputMode(executor.OutputPrefixed)
+	}
+
 	fmt.Print("\033[?25l") // Hide cursor
 	defer fmt.Print("\033[?25h")
 
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/config"
 	"github.com/ashavijit/fluxfile/internal/executor"
 )
 
@@ -74,3 +77,169 @@ func showTasksEnhanced(exec *executor.Executor) {
 	fmt.Printf("  %sRun a task:%s flux %s<task>%s\n", colorGray, colorReset, colorCyan, colorReset)
 	fmt.Println()
 }
+
+func showTaskDefinition(path, taskName string, resolved bool) error {
+	var fluxFile *ast.FluxFile
+	var err error
+	if resolved {
+		fluxFile, err = config.Load(path)
+	} else {
+		fluxFile, err = config.LoadUnresolved(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range fluxFile.Tasks {
+		if fluxFile.Tasks[i].Name == taskName {
+			fmt.Print(formatTask(&fluxFile.Tasks[i], !resolved))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("task %s not found", taskName)
+}
+
+func formatTask(task *ast.Task, showExtends bool) string {
+	var sb strings.Builder
+	line := func(format string, args ...interface{}) {
+		sb.WriteString("    ")
+		fmt.Fprintf(&sb, format, args...)
+		sb.WriteString("\n")
+	}
+	block := func(name string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		line("%s:", name)
+		for _, item := range items {
+			for _, text := range strings.Split(item, "\n") {
+				line("    %s", text)
+			}
+		}
+	}
+
+	fmt.Fprintf(&sb, "task %s:\n", task.Name)
+	if task.Desc != "" {
+		line("desc: %s", task.Desc)
+	}
+	if showExtends && task.Extends != "" {
+		line("extends: %s", task.Extends)
+	}
+	if len(task.Deps) > 0 {
+		line("deps: %s", strings.Join(task.Deps, ", "))
+	}
+	if task.Parallel || task.Declared["parallel"] {
+		line("parallel: %t", task.Parallel)
+	}
+	if task.If != "" {
+		line("if: %s", task.If)
+	}
+	if task.Profile != "" {
+		line("profile_task: %s", task.Profile)
+	}
+	if task.Shell != "" {
+		line("shell: %s", task.Shell)
+	}
+
+	if task.Restart {
+		line("restart: true")
+	}
+	if len(task.Dotenv) > 0 {
+		line("dotenv: [%s]", strings.Join(task.Dotenv, ", "))
+	}
+	if len(task.Env) > 0 {
+		keys := make([]string, 0, len(task.Env))
+		for k := range task.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		env := make([]string, len(keys))
+		for i, k := range keys {
+			env[i] = fmt.Sprintf("%s = %s", k, task.Env[k])
+		}
+		block("env", env)
+	}
+
+	if len(task.Secrets) > 0 {
+		secrets := make([]string, len(task.Secrets))
+		for i, s := range task.Secrets {
+			secrets[i] = s.Name
+			if s.Source != "" {
+				secrets[i] += " = " + s.Source
+			}
+		}
+		block("secrets", secrets)
+	}
+	if len(task.Pre) > 0 {
+		pre := make([]string, len(task.Pre))
+		for i, p := range task.Pre {
+			pre[i] = fmt.Sprintf("%s: %s", p.Type, p.Value)
+		}
+		block("pre", pre)
+	}
+	if task.Retries > 0 {
+		line("retries: %d", task.Retries)
+	}
+	if task.RetryDelay != "" {
+		line("retry_delay: %s", task.RetryDelay)
+	}
+	if task.Timeout != "" {
+		line("timeout: %s", task.Timeout)
+	}
+	if task.Prompt != "" {
+		line("prompt: %q", task.Prompt)
+	}
+	if task.Cache || task.Declared["cache"] {
+		line("cache: %t", task.Cache)
+	}
+	block("inputs", task.Inputs)
+	block("outputs", task.Outputs)
+	if len(task.Watch) > 0 {
+		line("watch: %s", strings.Join(task.Watch, ", "))
+	}
+	block("ignore", task.WatchIgnore)
+
+	if task.Matrix != nil && len(task.Matrix.Dimensions) > 0 {
+		keys := make([]string, 0, len(task.Matrix.Dimensions))
+		for k := range task.Matrix.Dimensions {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dims := make([]string, len(keys))
+		for i, k := range keys {
+			dims[i] = fmt.Sprintf("%s: %s", k, strings.Join(task.Matrix.Dimensions[k], ", "))
+		}
+		block("matrix", dims)
+	}
+
+	if task.Docker || task.Declared["docker"] {
+		line("docker: %t", task.Docker)
+	}
+	if task.Remote != "" {
+		line("remote: %q", task.Remote)
+	}
+	if task.Notify.Success != "" || task.Notify.Failure != "" {
+		line("notify:")
+		if task.Notify.Success != "" {
+			line("    success: %q", task.Notify.Success)
+		}
+		if task.Notify.Failure != "" {
+			line("    failure: %q", task.Notify.Failure)
+		}
+	}
+	if len(task.Run) == 1 && strings.Contains(task.Run[0], "\n") && !strings.Contains(task.Run[0], "<<") {
+		line("run: |")
+		for _, text := range strings.Split(task.Run[0], "\n") {
+			if text == "" {
+				sb.WriteString("\n")
+				continue
+			}
+			line("    %s", text)
+		}
+	} else {
+		block("run", task.Run)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/vars"
+)
+
+var assignmentPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*=`)
+
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseInterspersed keeps parsing flags after positional arguments, so that
+// "flux deploy --var MODE=prod" works like "flux --var MODE=prod deploy".
+func parseInterspersed() []string {
+	var positional []string
+	rest := flag.Args()
+	for len(rest) > 0 {
+		if rest[0] == "--" {
+			return append(positional, rest[1:]...)
+		}
+		positional = append(positional, rest[0])
+		// flux logs and flux history parse their own flags, such as
+		// tail -f.
+		if len(positional) == 1 && (rest[0] == "logs" || rest[0] == "history") {
+			return append(positional, rest[1:]...)
+		}
+		if err := flag.CommandLine.Parse(rest[1:]); err != nil {
+			return positional
+		}
+		rest = flag.CommandLine.Args()
+	}
+	return positional
+}
+
+// splitVarArgs separates NAME=value arguments from the task name and
+// subcommand arguments.
+func splitVarArgs(args []string) ([]string, []string) {
+	var rest, assignments []string
+	for _, arg := range args {
+		if assignmentPattern.MatchString(arg) {
+			assignments = append(assignments, arg)
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, assignments
+}
+
+// overrideLayers returns one layer per var file followed by the command
+// line vars, in increasing precedence.
+func overrideLayers(varFiles, cliVars []string) ([]vars.Layer, error) {
+	var layers []vars.Layer
+	for _, path := range varFiles {
+		fileVars, err := vars.LoadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load var file: %w", err)
+		}
+		layers = append(layers, vars.Layer{Source: "var-file " + path, Vars: fileVars})
+	}
+
+	cli := make(map[string]string)
+	for _, assignment := range cliVars {
+		if !assignmentPattern.MatchString(assignment) {
+			return nil, fmt.Errorf("invalid --var %q, expected NAME=value", assignment)
+		}
+		eq := strings.Index(assignment, "=")
+		cli[assignment[:eq]] = assignment[eq+1:]
+	}
+	if len(cli) > 0 {
+		layers = append(layers, vars.Layer{Source: "command line", Vars: cli})
+	}
+
+	return layers, nil
+}
+
+func mergeLayers(layers []vars.Layer) map[string]string {
+	merged := make(map[string]string)
+	for _, layer := range layers {
+		merged = vars.MergeVars(merged, layer.Vars)
+	}
+	return merged
+}
+
+// showVars prints every var with the layer that provided it. Environment
+// variables are listed only when the FluxFile references them.
+func showVars(fluxFile *ast.FluxFile, profile string, configEnv map[string]string, overrides []vars.Layer, jsonOut bool) error {
+	env := make(map[string]string)
+	for _, name := range vars.References(fluxFileStrings(fluxFile)...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+
+	layers := []vars.Layer{
+		{Source: "environment", Vars: env},
+		{Source: "config", Vars: configEnv},
+	}
+	layers, err := appendDotenvLayers(layers, fluxFile.Dotenv, vars.MergeVars(vars.MergeVars(configEnv, fluxFile.Vars), mergeLayers(overrides)))
+	if err != nil {
+		return err
+	}
+	layers = append(layers, vars.Layer{Source: "FluxFile", Vars: fluxFile.Vars})
+
+	profiles, err := config.ResolveProfiles(fluxFile, config.SplitProfiles(profile))
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		layers, err = appendDotenvLayers(layers, p.Dotenv, mergeLayers(append(layers, overrides...)))
+		if err != nil {
+			return err
+		}
+		layers = append(layers, vars.Layer{Source: "profile " + p.Name, Vars: config.ProfileVars([]*ast.Profile{p})})
+	}
+
+	resolved, err := vars.ResolveLayers(append(layers, overrides...))
+	if err != nil {
+		return err
+	}
+
+	if jsonOut {
+		data, err := json.MarshalIndent(resolved, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	// Multi-line values, as dotenv files allow, would break the table.
+	for i := range resolved {
+		resolved[i].Value = strings.ReplaceAll(resolved[i].Value, "\n", `\n`)
+	}
+
+	nameWidth, valueWidth := len("NAME"), len("VALUE")
+	for _, v := range resolved {
+		if len(v.Name) > nameWidth {
+			nameWidth = len(v.Name)
+		}
+		if len(v.Value) > valueWidth && len(v.Value) <= 60 {
+			valueWidth = len(v.Value)
+		}
+	}
+
+	fmt.Printf("%s%-*s  %-*s  %s%s\n", colorYellow, nameWidth, "NAME", valueWidth, "VALUE", "SOURCE", colorReset)
+	for _, v := range resolved {
+		fmt.Printf("%s%-*s%s  %-*s  %s%s%s\n", colorGreen, nameWidth, v.Name, colorReset, valueWidth, v.Value, colorGray, v.Source, colorReset)
+	}
+	return nil
+}
+
+// appendDotenvLayers adds a layer for each existing dotenv file, resolving
+// references in the paths against current.
+func appendDotenvLayers(layers []vars.Layer, files []string, current map[string]string) ([]vars.Layer, error) {
+	for _, file := range files {
+		path := vars.Expand(file, current)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		fileVars, err := vars.LoadDotenv([]string{path}, nil)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, vars.Layer{Source: "dotenv " + path, Vars: fileVars})
+	}
+	return layers, nil
+}
+
+func fluxFileStrings(fluxFile *ast.FluxFile) []string {
+	var values []string
+	for _, v := range fluxFile.Vars {
+		values = append(values, v)
+	}
+	for i := range fluxFile.Profiles {
+		for _, v := range config.ProfileVars([]*ast.Profile{&fluxFile.Profiles[i]}) {
+			values = append(values, v)
+		}
+	}
+	for _, task := range fluxFile.Tasks {
+		values = append(values, task.Run...)
+		values = append(values, task.If)
+		for _, v := range task.Env {
+			values = append(values, v)
+		}
+	}
+	return values
+}
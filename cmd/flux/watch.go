@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/executor"
+	"github.com/ashavijit/fluxfile/internal/logger"
+	"github.com/ashavijit/fluxfile/internal/watcher"
+)
+
+// stopGrace is how long a restarted process gets to exit after SIGTERM
+// before it is killed.
+const stopGrace = 5 * time.Second
+
+// watchSession runs the watched tasks once, then only the tasks affected by
+// each batch of changed files.
+type watchSession struct {
+	exec     *executor.Executor
+	index    *executor.WatchIndex
+	targets  []string
+	profile  string
+	useCache bool
+	log      *logger.Logger
+}
+
+// watch runs the tasks, then watches their files and re-runs the affected
+// tasks until interrupted.
+func (s *watchSession) watch(cfg *config.FluxConfig) {
+	debounce, err := cfg.DebounceDuration()
+	if err != nil {
+		s.log.Fatal(err.Error())
+	}
+	interval, err := cfg.PollInterval()
+	if err != nil {
+		s.log.Fatal(err.Error())
+	}
+
+	if len(s.targets) == 1 {
+		s.log.Info(fmt.Sprintf("Starting watch mode for task: %s", s.targets[0]))
+	} else {
+		s.log.Info(fmt.Sprintf("Starting watch mode for tasks: %s", strings.Join(s.targets, ", ")))
+	}
+
+	changes := newChangeQueue()
+	w, err := watcher.New(s.index.Patterns(), changes.add)
+	if err != nil {
+		s.log.Fatal(err.Error())
+	}
+	w.SetIgnore(s.index.Ignore())
+	w.SetDebounce(debounce)
+	w.SetPollInterval(interval)
+	if cfg.WatchPoll {
+		w.UsePolling()
+	}
+
+	go func() {
+		if err := w.Start(); err != nil {
+			s.log.Fatal(err.Error())
+		}
+	}()
+
+	s.loop(changes)
+}
+
+// run runs all targets when changed is nil, and otherwise the tasks whose
+// watched files or inputs changed and the tasks that depend on them.
+func (s *watchSession) run(changed []string) error {
+	if changed == nil {
+		if len(s.targets) == 1 {
+			return s.exec.Execute(s.targets[0], s.profile, s.useCache)
+		}
+		return s.exec.ExecuteTasks(s.index.Tasks(), s.profile, s.useCache)
+	}
+
+	tasks := s.index.Affected(changed)
+	if len(tasks) == 0 {
+		return nil
+	}
+	s.log.Info(fmt.Sprintf("Re-running %s", strings.Join(tasks, ", ")))
+	return s.exec.ExecuteTasks(tasks, s.profile, s.useCache)
+}
+
+// restarts reports whether changed affects a task with restart: true.
+func (s *watchSession) restarts(changed []string) bool {
+	for _, name := range s.index.Affected(changed) {
+		if task := s.index.Task(name); task != nil && task.Restart {
+			return true
+		}
+	}
+	return false
+}
+
+// loop runs the tasks and re-runs them after each batch of changes. Runs
+// never overlap: changes that arrive during a run are queued and handled
+// once it finishes. When a change affects a task with restart: true, the
+// running processes are stopped instead of waited for, and the stopped run
+// is repeated along with the queued changes.
+func (s *watchSession) loop(changes *changeQueue) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan error, 1)
+	var current, pending []string
+	running, queued, stopped := false, false, false
+	start := func(changed []string) {
+		current, running = changed, true
+		go func() { done <- s.run(changed) }()
+	}
+
+	start(nil)
+	for {
+		select {
+		case <-changes.ready:
+			changed := changes.take()
+			if !running {
+				start(changed)
+				continue
+			}
+			if !stopped && s.restarts(changed) {
+				s.log.Info("Change detected, restarting...")
+				s.exec.Stop(stopGrace)
+				stopped = true
+			}
+			pending = append(pending, changed...)
+			queued = true
+
+		case err := <-done:
+			running = false
+			if err != nil && !errors.Is(err, executor.ErrStopped) {
+				s.log.Error(err.Error())
+			}
+			if queued {
+				next := pending
+				if errors.Is(err, executor.ErrStopped) {
+					next = nil
+					if current != nil {
+						next = append(current, pending...)
+					}
+				}
+				pending, queued, stopped = nil, false, false
+				start(next)
+			}
+
+		case <-interrupt:
+			s.exec.Stop(stopGrace)
+			os.Exit(130)
+		}
+	}
+}
+
+// changeQueue collects the files reported by the watcher until the watch
+// loop takes them.
+type changeQueue struct {
+	mu    sync.Mutex
+	files []string
+	ready chan struct{}
+}
+
+func newChangeQueue() *changeQueue {
+	return &changeQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *changeQueue) add(changed []string) {
+	q.mu.Lock()
+	q.files = append(q.files, changed...)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *changeQueue) take() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	files := q.files
+	q.files = nil
+	return files
+}
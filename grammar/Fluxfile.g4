@@ -7,17 +7,41 @@ fluxfile
 statement
     : varDecl
     | taskDecl
+    | templateDecl
     | profileDecl
     | includeDecl
+    | dotenvDirective
     | NEWLINE
     ;
 
 varDecl
-    : VAR IDENT EQUALS expr NEWLINE
+    : VAR IDENT EQUALS (expr | listExpr) NEWLINE
+    ;
+
+listExpr
+    : '[' (listItem (COMMA listItem)*)? ']'
+    ;
+
+listItem
+    : ~(COMMA | NEWLINE | ']')+
     ;
 
 taskDecl
-    : TASK IDENT COLON NEWLINE INDENT taskBody DEDENT
+    : TASK name COLON NEWLINE INDENT taskBody DEDENT
+    ;
+
+templateDecl
+    : TEMPLATE name COLON NEWLINE INDENT taskBody DEDENT
+    ;
+
+// Contextual keywords are only keywords where a directive starts, so they
+// can still name tasks.
+name
+    : IDENT
+    | TEMPLATE
+    | EXTENDS
+    | DOTENV
+    | RESTART
     ;
 
 taskBody
@@ -33,12 +57,16 @@ taskDirective
     | envDirective
     | watchDirective
     | ignoreDirective
+    | restartDirective
     | matrixDirective
     | cacheDirective
     | inputsDirective
     | outputsDirective
     | dockerDirective
     | remoteDirective
+    | extendsDirective
+    | shellDirective
+    | dotenvDirective
     ;
 
 descDirective
@@ -46,7 +74,15 @@ descDirective
     ;
 
 depsDirective
-    : DEPS COLON identList NEWLINE
+    : DEPS COLON taskRef (COMMA taskRef)* NEWLINE
+    ;
+
+taskRef
+    : name (COLON name)*
+    ;
+
+extendsDirective
+    : EXTENDS COLON taskRef NEWLINE
     ;
 
 parallelDirective
@@ -59,12 +95,21 @@ ifDirective
 
 runDirective
     : RUN COLON NEWLINE INDENT commandList DEDENT
+    | RUN COLON PIPE NEWLINE INDENT rawLine+ DEDENT
+    ;
+
+shellDirective
+    : SHELL COLON (IDENT | STRING) NEWLINE
     ;
 
 envDirective
     : ENV COLON NEWLINE INDENT envPairList DEDENT
     ;
 
+dotenvDirective
+    : DOTENV COLON (listExpr | listItem (COMMA listItem)*) NEWLINE
+    ;
+
 watchDirective
     : WATCH COLON pattern NEWLINE
     ;
@@ -73,6 +118,10 @@ ignoreDirective
     : IGNORE COLON NEWLINE INDENT patternList DEDENT
     ;
 
+restartDirective
+    : RESTART COLON IDENT NEWLINE
+    ;
+
 matrixDirective
     : MATRIX COLON NEWLINE INDENT matrixDimensions DEDENT
     ;
@@ -98,21 +147,35 @@ remoteDirective
     ;
 
 profileDecl
-    : PROFILE IDENT COLON NEWLINE INDENT envDirective DEDENT
+    : PROFILE IDENT COLON NEWLINE INDENT profileBody+ DEDENT
+    ;
+
+profileBody
+    : envDirective
+    | dotenvDirective
+    | varDecl
+    | EXTENDS COLON IDENT NEWLINE
+    | taskDecl
     ;
 
 includeDecl
-    : INCLUDE STRING NEWLINE
+    : INCLUDE STRING ('as' IDENT)? NEWLINE
     ;
 
 commandList
     : command+
     ;
 
+// Commands are read verbatim from the source line; a trailing backslash
+// continues the line and a heredoc extends it up to its terminator.
 command
     : ~(NEWLINE | DEDENT)+ NEWLINE
     ;
 
+rawLine
+    : ~(NEWLINE | DEDENT)* NEWLINE
+    ;
+
 envPairList
     : envPair+
     ;
@@ -142,7 +205,24 @@ identList
     ;
 
 conditionExpr
-    : IDENT compOp expr
+    : orExpr
+    ;
+
+orExpr
+    : andExpr ('||' andExpr)*
+    ;
+
+andExpr
+    : unaryExpr ('&&' unaryExpr)*
+    ;
+
+unaryExpr
+    : '!' unaryExpr
+    | comparison
+    ;
+
+comparison
+    : operand (compOp operand)?
     ;
 
 compOp
@@ -152,6 +232,19 @@ compOp
     | '<'
     | '>='
     | '<='
+    | '=~'
+    | '!~'
+    | 'in'
+    ;
+
+operand
+    : STRING
+    | NUMBER
+    | IDENT
+    | DOLLAR '{' IDENT '}'
+    | IDENT LPAREN (orExpr (COMMA orExpr)*)? RPAREN
+    | LPAREN orExpr RPAREN
+    | '[' (operand (COMMA operand)*)? ']'
     ;
 
 expr
@@ -162,11 +255,12 @@ expr
     ;
 
 shellExpr
-    : DOLLAR LPAREN SHELL STRING RPAREN
+    : DOLLAR LPAREN SHELL STRING RPAREN QUESTION?
     ;
 
 VAR         : 'var' ;
 TASK        : 'task' ;
+TEMPLATE    : 'template' ;
 PROFILE     : 'profile' ;
 INCLUDE     : 'include' ;
 DESC        : 'desc' ;
@@ -177,16 +271,21 @@ RUN         : 'run' ;
 ENV         : 'env' ;
 WATCH       : 'watch' ;
 IGNORE      : 'ignore' ;
+RESTART     : 'restart' ;
 MATRIX      : 'matrix' ;
 CACHE       : 'cache' ;
 INPUTS      : 'inputs' ;
 OUTPUTS     : 'outputs' ;
 DOCKER      : 'docker' ;
 REMOTE      : 'remote' ;
+EXTENDS     : 'extends' ;
 SHELL       : 'shell' ;
+DOTENV      : 'dotenv' ;
 
 COLON       : ':' ;
 COMMA       : ',' ;
+PIPE        : '|' ;
+QUESTION    : '?' ;
 EQUALS      : '=' ;
 LPAREN      : '(' ;
 RPAREN      : ')' ;
@@ -0,0 +1,238 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/graph"
+	"github.com/ashavijit/fluxfile/internal/logs"
+)
+
+// Stats summarizes the past executions of a task.
+type Stats struct {
+	// Runs counts the executions that succeeded, cache hits included.
+	Runs      int
+	CacheHits int
+	// SelfTime is the median duration of the executions that did work,
+	// that is, were not cache hits.
+	SelfTime time.Duration
+}
+
+// HitRate returns the fraction of executions that were cache hits.
+func (s *Stats) HitRate() float64 {
+	if s == nil || s.Runs == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(s.Runs)
+}
+
+// CollectStats summarizes the successful task executions in runs by task.
+func CollectStats(runs []*logs.Run) map[string]*Stats {
+	stats := make(map[string]*Stats)
+	durations := make(map[string][]time.Duration)
+	for _, run := range runs {
+		for _, task := range run.Tasks {
+			if task.Status != "success" {
+				continue
+			}
+			s := stats[task.TaskName]
+			if s == nil {
+				s = &Stats{}
+				stats[task.TaskName] = s
+			}
+			s.Runs++
+			if task.CacheHit {
+				s.CacheHits++
+				continue
+			}
+			durations[task.TaskName] = append(durations[task.TaskName], task.EndTime.Sub(task.StartTime))
+		}
+	}
+	for name, d := range durations {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		stats[name].SelfTime = d[len(d)/2]
+	}
+	return stats
+}
+
+// Task is the place of a task in the schedule of a target. Start and
+// Finish are the earliest times the task can start and finish when every
+// task runs as soon as its dependencies are done.
+type Task struct {
+	Name     string        `json:"name"`
+	Deps     []string      `json:"deps,omitempty"`
+	SelfTime time.Duration `json:"self_time_ns"`
+	Start    time.Duration `json:"start_ns"`
+	Finish   time.Duration `json:"finish_ns"`
+	// Slack is how much longer the task could take without delaying
+	// the target.
+	Slack        time.Duration `json:"slack_ns"`
+	Critical     bool          `json:"critical"`
+	Runs         int           `json:"runs"`
+	CacheHitRate float64       `json:"cache_hit_rate"`
+	Cacheable    bool          `json:"cacheable"`
+}
+
+// Analysis is the critical path analysis of a target.
+type Analysis struct {
+	Target string `json:"target"`
+	// Tasks lists the target and its dependencies, each after its own
+	// dependencies.
+	Tasks        []Task   `json:"tasks"`
+	CriticalPath []string `json:"critical_path"`
+	// CriticalTime is how long the target takes when dependencies run in
+	// parallel, and SequentialTime how long it takes when they run one
+	// after another.
+	CriticalTime   time.Duration `json:"critical_time_ns"`
+	SequentialTime time.Duration `json:"sequential_time_ns"`
+	// Parallel reports whether the target runs its dependencies in
+	// parallel.
+	Parallel    bool     `json:"parallel"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Analyze computes the critical path of target from the self times in
+// stats. parallel is whether the dependencies of target run in parallel,
+// which decides whether tasks off the critical path add to its time.
+func Analyze(g *graph.Graph, target string, stats map[string]*Stats, parallel bool) (*Analysis, error) {
+	deps, err := g.GetDependencies(target)
+	if err != nil {
+		return nil, err
+	}
+	names := append(deps, target)
+
+	a := &Analysis{Target: target, Parallel: parallel}
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		task, err := g.GetTask(name)
+		if err != nil {
+			return nil, err
+		}
+		s := stats[name]
+		t := Task{
+			Name:         name,
+			Deps:         task.Deps,
+			CacheHitRate: s.HitRate(),
+			Cacheable:    (task.Cache && len(task.Inputs) > 0) || len(task.Watch) > 0,
+		}
+		if s != nil {
+			t.SelfTime, t.Runs = s.SelfTime, s.Runs
+		}
+		// Dependencies come first, so their finish times are known.
+		for _, dep := range task.Deps {
+			if finish := a.Tasks[index[dep]].Finish; finish > t.Start {
+				t.Start = finish
+			}
+		}
+		t.Finish = t.Start + t.SelfTime
+		a.SequentialTime += t.SelfTime
+		index[name] = i
+		a.Tasks = append(a.Tasks, t)
+	}
+
+	// The latest a task may finish is the latest start of the tasks that
+	// depend on it, found walking back from the target.
+	a.CriticalTime = a.Tasks[len(a.Tasks)-1].Finish
+	latest := make([]time.Duration, len(a.Tasks))
+	for i := range latest {
+		latest[i] = a.CriticalTime
+	}
+	for i := len(a.Tasks) - 1; i >= 0; i-- {
+		t := &a.Tasks[i]
+		t.Slack = latest[i] - t.Finish
+		t.Critical = t.Slack == 0
+		for _, dep := range t.Deps {
+			if start := latest[i] - t.SelfTime; start < latest[index[dep]] {
+				latest[index[dep]] = start
+			}
+		}
+	}
+
+	// Follow the dependencies that finish last back from the target.
+	for i := len(a.Tasks) - 1; ; {
+		a.CriticalPath = append([]string{a.Tasks[i].Name}, a.CriticalPath...)
+		next := -1
+		for _, dep := range a.Tasks[i].Deps {
+			if j := index[dep]; next < 0 || a.Tasks[j].Finish > a.Tasks[next].Finish {
+				next = j
+			}
+		}
+		if next < 0 {
+			break
+		}
+		i = next
+	}
+
+	a.Suggestions = suggest(a)
+	return a, nil
+}
+
+// Time returns how long the target is expected to take.
+func (a *Analysis) Time() time.Duration {
+	if a.Parallel {
+		return a.CriticalTime
+	}
+	return a.SequentialTime
+}
+
+// significant is the share of the run a task must take for suggestions to
+// mention it.
+const significant = 0.1
+
+// suggest names the tasks worth caching or parallelizing.
+func suggest(a *Analysis) []string {
+	var suggestions []string
+	total := a.Time()
+
+	if !a.Parallel && a.SequentialTime-a.CriticalTime > time.Duration(float64(a.SequentialTime)*significant) {
+		suggestions = append(suggestions, fmt.Sprintf("Run the dependencies of %s in parallel (parallel: true): %s instead of %s",
+			a.Target, formatDuration(a.CriticalTime), formatDuration(a.SequentialTime)))
+	}
+
+	// Tasks off the critical path only add to the time when tasks run
+	// one after another.
+	var slow []Task
+	for _, t := range a.Tasks {
+		if (t.Critical || !a.Parallel) && total > 0 && float64(t.SelfTime) >= float64(total)*significant {
+			slow = append(slow, t)
+		}
+	}
+	sort.SliceStable(slow, func(i, j int) bool { return slow[i].SelfTime > slow[j].SelfTime })
+	for i, t := range slow {
+		switch {
+		case !t.Cacheable:
+			suggestions = append(suggestions, fmt.Sprintf("Cache %s (cache: true with inputs:): it takes %s, %d%% of the run, and always runs",
+				t.Name, formatDuration(t.SelfTime), percent(t.SelfTime, total)))
+		case t.CacheHitRate < 0.5:
+			suggestions = append(suggestions, fmt.Sprintf("%s is cached but hit only %d%% of the time; check that its inputs are not broader than needed",
+				t.Name, int(t.CacheHitRate*100)))
+		case i == 0 && t.Critical:
+			suggestions = append(suggestions, fmt.Sprintf("%s is the slowest task on the critical path; splitting it into tasks that run in parallel would shorten the run",
+				t.Name))
+		}
+	}
+
+	var unknown []string
+	for _, t := range a.Tasks {
+		if t.Runs == 0 {
+			unknown = append(unknown, t.Name)
+		}
+	}
+	if len(unknown) > 0 {
+		suggestions = append(suggestions, fmt.Sprintf("No timing data for %s yet; run flux %s to record it", strings.Join(unknown, ", "), a.Target))
+	}
+	return suggestions
+}
+
+func percent(d, total time.Duration) int {
+	return int(float64(d) / float64(total) * 100)
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
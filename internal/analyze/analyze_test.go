@@ -0,0 +1,100 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/graph"
+	"github.com/ashavijit/fluxfile/internal/logs"
+)
+
+func TestCollectStats(t *testing.T) {
+	start := time.Now()
+	task := func(name string, d time.Duration, status string, hit bool) *logs.TaskLog {
+		return &logs.TaskLog{TaskName: name, Status: status, CacheHit: hit, StartTime: start, EndTime: start.Add(d)}
+	}
+	runs := []*logs.Run{
+		{Tasks: []*logs.TaskLog{task("build", 3*time.Second, "success", false), task("test", time.Second, "failed", false)}},
+		{Tasks: []*logs.TaskLog{task("build", time.Second, "success", false)}},
+		{Tasks: []*logs.TaskLog{task("build", 2*time.Second, "success", false)}},
+		{Tasks: []*logs.TaskLog{task("build", 0, "success", true)}},
+	}
+
+	stats := CollectStats(runs)
+	build := stats["build"]
+	if build.Runs != 4 || build.CacheHits != 1 || build.SelfTime != 2*time.Second || build.HitRate() != 0.25 {
+		t.Errorf("Unexpected build stats %+v", build)
+	}
+	if _, ok := stats["test"]; ok {
+		t.Error("Expected failed executions to be left out")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	gen := ast.NewTask("gen")
+	lint := ast.NewTask("lint")
+	compile := ast.NewTask("compile")
+	compile.Deps = []string{"gen"}
+	compile.Cache = true
+	compile.Inputs = []string{"*.go"}
+	all := ast.NewTask("all")
+	all.Deps = []string{"lint", "compile"}
+	g, err := graph.BuildGraph([]ast.Task{gen, lint, compile, all})
+	if err != nil {
+		t.Fatalf("BuildGraph() error = %v", err)
+	}
+
+	stats := map[string]*Stats{
+		"gen":     {Runs: 4, SelfTime: 3 * time.Second},
+		"lint":    {Runs: 4, SelfTime: time.Second},
+		"compile": {Runs: 4, CacheHits: 3, SelfTime: 2 * time.Second},
+		"all":     {Runs: 4, SelfTime: 0},
+	}
+
+	a, err := Analyze(g, "all", stats, false)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if got := strings.Join(a.CriticalPath, " "); got != "gen compile all" {
+		t.Errorf("Expected critical path gen compile all, got %s", got)
+	}
+	if a.CriticalTime != 5*time.Second || a.SequentialTime != 6*time.Second {
+		t.Errorf("Expected 5s critical and 6s sequential time, got %v and %v", a.CriticalTime, a.SequentialTime)
+	}
+
+	tasks := make(map[string]Task)
+	for _, task := range a.Tasks {
+		tasks[task.Name] = task
+	}
+	if lint := tasks["lint"]; lint.Critical || lint.Slack != 4*time.Second {
+		t.Errorf("Expected lint to have 4s of slack, got %+v", lint)
+	}
+	if compile := tasks["compile"]; !compile.Critical || compile.Start != 3*time.Second || compile.CacheHitRate != 0.75 {
+		t.Errorf("Unexpected compile %+v", compile)
+	}
+
+	suggestions := strings.Join(a.Suggestions, "\n")
+	for _, want := range []string{"in parallel", "Cache gen", "Cache lint"} {
+		if !strings.Contains(suggestions, want) {
+			t.Errorf("Expected a suggestion containing %q, got\n%s", want, suggestions)
+		}
+	}
+	if strings.Contains(suggestions, "Cache compile") {
+		t.Errorf("Expected no caching suggestion for a task that is cached, got\n%s", suggestions)
+	}
+
+	// In parallel, tasks off the critical path do not add to the time.
+	a, _ = Analyze(g, "all", stats, true)
+	suggestions = strings.Join(a.Suggestions, "\n")
+	if strings.Contains(suggestions, "in parallel (") || strings.Contains(suggestions, "Cache lint") {
+		t.Errorf("Unexpected suggestions for a parallel target:\n%s", suggestions)
+	}
+
+	delete(stats, "lint")
+	a, _ = Analyze(g, "all", stats, true)
+	if !strings.Contains(strings.Join(a.Suggestions, "\n"), "No timing data for lint") {
+		t.Errorf("Expected tasks without history to be named, got %v", a.Suggestions)
+	}
+}
@@ -0,0 +1,62 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+	colorGray   = "\033[90m"
+	colorBold   = "\033[1m"
+)
+
+// Print writes the analysis as a table, marking the critical tasks, followed
+// by the suggestions.
+func (a *Analysis) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s%sCritical path of %s%s\n", colorBold, colorCyan, a.Target, colorReset)
+	fmt.Fprintln(w, strings.Repeat("-", 72))
+	fmt.Fprintf(w, "  %-28s %10s %10s %10s %10s\n", "TASK", "SELF", "START", "SLACK", "CACHE HITS")
+	for _, t := range a.Tasks {
+		marker, color := " ", ""
+		if t.Critical {
+			marker, color = "*", colorYellow
+		}
+		hits := "-"
+		if t.Runs > 0 {
+			hits = fmt.Sprintf("%d%% of %d", int(t.CacheHitRate*100), t.Runs)
+		}
+		self := formatDuration(t.SelfTime)
+		if t.Runs == 0 {
+			self = "?"
+		}
+		fmt.Fprintf(w, "%s%s %-28s %10s %10s %10s %10s%s\n", color, marker, truncate(t.Name, 28),
+			self, formatDuration(t.Start), formatDuration(t.Slack), hits, colorReset)
+	}
+	fmt.Fprintln(w, strings.Repeat("-", 72))
+
+	fmt.Fprintf(w, "  Critical path: %s (%s)\n", strings.Join(a.CriticalPath, " → "), formatDuration(a.CriticalTime))
+	mode := "one after another"
+	if a.Parallel {
+		mode = "in parallel"
+	}
+	fmt.Fprintf(w, "  Dependencies run %s: expect %s\n", mode, formatDuration(a.Time()))
+	fmt.Fprintf(w, "  %sSelf times are medians of past runs; slack assumes tasks start as soon as their dependencies finish.%s\n", colorGray, colorReset)
+
+	if len(a.Suggestions) > 0 {
+		fmt.Fprintf(w, "\n%sSuggestions:%s\n", colorBold, colorReset)
+		for _, s := range a.Suggestions {
+			fmt.Fprintf(w, "  • %s\n", s)
+		}
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
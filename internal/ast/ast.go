@@ -1,10 +1,19 @@
 package ast
 
+import "fmt"
+
 type FluxFile struct {
-	Vars     map[string]string
-	Tasks    []Task
-	Profiles []Profile
-	Includes []string
+	Vars      map[string]string
+	Dotenv    []string
+	Tasks     []Task
+	Templates []Task
+	Profiles  []Profile
+	Includes  []Include
+}
+
+type Include struct {
+	Path      string
+	Namespace string
 }
 
 type Task struct {
@@ -24,13 +33,20 @@ type Task struct {
 	Docker      bool
 	Remote      string
 	Profile     string
-	Secrets     []string
+	Secrets     []Secret
 	Pre         []Precondition
 	Retries     int
 	RetryDelay  string
 	Timeout     string
 	Prompt      string
 	Notify      NotifyConfig
+	Extends     string
+	Shell       string
+	Dotenv      []string
+	Restart     bool
+	Declared    map[string]bool
+	File        string
+	Line        int
 }
 
 type NotifyConfig struct {
@@ -38,14 +54,26 @@ type NotifyConfig struct {
 	Failure string
 }
 
+// Secret is a secret a task needs. Source names the provider it is read
+// from, as in pass:ci/token; an empty source means the environment or .env.
+type Secret struct {
+	Name   string
+	Source string
+}
+
 type Precondition struct {
 	Type  string
 	Value string
 }
 
 type Profile struct {
-	Name string
-	Env  map[string]string
+	Name    string
+	Env     map[string]string
+	Vars    map[string]string
+	Dotenv  []string
+	Extends string
+	Tasks   []Task
+	Line    int
 }
 
 type Matrix struct {
@@ -79,10 +107,11 @@ func (s *ShellExpr) exprNode()     {}
 
 func NewFluxFile() *FluxFile {
 	return &FluxFile{
-		Vars:     make(map[string]string),
-		Tasks:    []Task{},
-		Profiles: []Profile{},
-		Includes: []string{},
+		Vars:      make(map[string]string),
+		Tasks:     []Task{},
+		Templates: []Task{},
+		Profiles:  []Profile{},
+		Includes:  []Include{},
 	}
 }
 
@@ -104,20 +133,39 @@ func NewTask(name string) Task {
 		Docker:      false,
 		Remote:      "",
 		Profile:     "",
-		Secrets:     []string{},
+		Secrets:     []Secret{},
 		Pre:         []Precondition{},
 		Retries:     0,
 		RetryDelay:  "",
 		Timeout:     "",
 		Prompt:      "",
 		Notify:      NotifyConfig{},
+		Extends:     "",
+		Shell:       "",
+		Dotenv:      []string{},
+		Declared:    make(map[string]bool),
+	}
+}
+
+func (t *Task) Location() string {
+	switch {
+	case t.File != "" && t.Line > 0:
+		return fmt.Sprintf("%s:%d", t.File, t.Line)
+	case t.File != "":
+		return t.File
+	case t.Line > 0:
+		return fmt.Sprintf("line %d", t.Line)
+	default:
+		return "unknown location"
 	}
 }
 
 func NewProfile(name string) Profile {
 	return Profile{
-		Name: name,
-		Env:  make(map[string]string),
+		Name:  name,
+		Env:   make(map[string]string),
+		Vars:  make(map[string]string),
+		Tasks: []Task{},
 	}
 }
 
@@ -8,7 +8,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/ashavijit/fluxfile/internal/glob"
 )
 
 type Cache struct {
@@ -61,7 +64,7 @@ func (c *Cache) Set(entry *CacheEntry) error {
 }
 
 func (c *Cache) entryPath(taskName string) string {
-	return filepath.Join(c.dir, fmt.Sprintf("%s.json", taskName))
+	return filepath.Join(c.dir, fmt.Sprintf("%s.json", strings.ReplaceAll(taskName, ":", "_")))
 }
 
 func (c *Cache) Clear() error {
@@ -73,7 +76,7 @@ func HashFiles(patterns []string) (string, error) {
 	var filesHashed int
 
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
+		matches, err := glob.Glob(pattern)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: invalid glob pattern %q: %v\n", pattern, err)
 			continue
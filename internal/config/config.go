@@ -5,60 +5,121 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/graph"
 	"github.com/ashavijit/fluxfile/internal/lexer"
 	"github.com/ashavijit/fluxfile/internal/parser"
 )
 
 type FluxConfig struct {
-	DefaultProfile string            `json:"default_profile,omitempty"`
-	CacheDir       string            `json:"cache_dir,omitempty"`
-	LogDir         string            `json:"log_dir,omitempty"`
-	Verbosity      string            `json:"verbosity,omitempty"`
-	Parallel       bool              `json:"parallel,omitempty"`
-	NoCache        bool              `json:"no_cache,omitempty"`
-	WatchDebounce  string            `json:"watch_debounce,omitempty"`
-	Env            map[string]string `json:"env,omitempty"`
+	DefaultProfile    string            `json:"default_profile,omitempty"`
+	CacheDir          string            `json:"cache_dir,omitempty"`
+	LogDir            string            `json:"log_dir,omitempty"`
+	LogOutputLimit    string            `json:"log_output_limit,omitempty"`
+	LogKeep           int               `json:"log_keep,omitempty"`
+	LogMaxAge         string            `json:"log_max_age,omitempty"`
+	HistoryDir        string            `json:"history_dir,omitempty"`
+	HistoryKeep       int               `json:"history_keep,omitempty"`
+	HistoryRegression int               `json:"history_regression,omitempty"`
+	Verbosity         string            `json:"verbosity,omitempty"`
+	Output            string            `json:"output,omitempty"`
+	Parallel          bool              `json:"parallel,omitempty"`
+	NoCache           bool              `json:"no_cache,omitempty"`
+	WatchDebounce     string            `json:"watch_debounce,omitempty"`
+	WatchPoll         bool              `json:"watch_poll,omitempty"`
+	WatchPollInterval string            `json:"watch_poll_interval,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
 }
 
 func DefaultConfig() *FluxConfig {
 	return &FluxConfig{
-		CacheDir:      ".flux/cache",
-		LogDir:        ".flux/logs",
-		Verbosity:     "normal",
-		Parallel:      false,
-		NoCache:       false,
-		WatchDebounce: "100ms",
-		Env:           make(map[string]string),
+		CacheDir:          ".flux/cache",
+		LogDir:            ".flux/logs",
+		LogOutputLimit:    "256KB",
+		LogKeep:           50,
+		LogMaxAge:         "0",
+		HistoryDir:        ".flux/history",
+		HistoryKeep:       1000,
+		HistoryRegression: 20,
+		Verbosity:         "normal",
+		Output:            "interleaved",
+		Parallel:          false,
+		NoCache:           false,
+		WatchDebounce:     "100ms",
+		WatchPollInterval: "500ms",
+		Env:               make(map[string]string),
 	}
 }
 
+var projectConfigPaths = []string{
+	".fluxconfig",
+	".fluxconfig.json",
+	".flux/config.json",
+}
+
+// LoadConfig loads the user config, then the project config over it, then
+// FLUX_* environment overrides. Missing files are skipped.
 func LoadConfig() (*FluxConfig, error) {
 	config := DefaultConfig()
 
-	configPaths := []string{
-		".fluxconfig",
-		".fluxconfig.json",
-		".flux/config.json",
+	for _, path := range []string{UserConfigPath(), ProjectConfigPath()} {
+		if path == "" {
+			continue
+		}
+		if err := mergeConfigFile(config, path); err != nil {
+			return nil, err
+		}
 	}
 
-	for _, path := range configPaths {
-		if _, err := os.Stat(path); err == nil {
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
-			}
+	if err := applyEnvOverrides(config); err != nil {
+		return nil, err
+	}
 
-			if err := json.Unmarshal(data, config); err != nil {
-				return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
-			}
+	return config, nil
+}
 
-			return config, nil
+// UserConfigPath returns $XDG_CONFIG_HOME/flux/config.json, falling back to
+// ~/.config/flux/config.json.
+func UserConfigPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "flux", "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "flux", "config.json")
+}
+
+// ProjectConfigPath returns the first project config file that exists, or
+// .fluxconfig when there is none.
+func ProjectConfigPath() string {
+	for _, path := range projectConfigPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
 		}
 	}
+	return projectConfigPaths[0]
+}
 
-	return config, nil
+func mergeConfigFile(config *FluxConfig, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
 }
 
 func SaveConfig(config *FluxConfig, path string) error {
@@ -75,6 +136,50 @@ func SaveConfig(config *FluxConfig, path string) error {
 }
 
 func Load(path string) (*ast.FluxFile, error) {
+	fluxFile, err := LoadUnresolved(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := graph.CheckDuplicates(fluxFile.Tasks); err != nil {
+		return nil, err
+	}
+
+	if err := graph.CheckDuplicates(fluxFile.Templates); err != nil {
+		return nil, err
+	}
+
+	if err := ResolveInheritance(fluxFile); err != nil {
+		return nil, err
+	}
+
+	if err := checkProfiles(fluxFile); err != nil {
+		return nil, err
+	}
+
+	return fluxFile, nil
+}
+
+// LoadUnresolved loads a FluxFile and its includes without applying
+// extends: directives, so tasks keep their declared directives only.
+func LoadUnresolved(path string) (*ast.FluxFile, error) {
+	return load(path, nil)
+}
+
+func load(path string, stack []string) (*ast.FluxFile, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, visited := range stack {
+		if other, _ := filepath.Abs(visited); other == absPath {
+			cycle := append(append([]string{}, stack[i:]...), path)
+			return nil, fmt.Errorf("include cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	stack = append(stack, path)
+
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read FluxFile: %w", err)
@@ -88,12 +193,19 @@ func Load(path string) (*ast.FluxFile, error) {
 		return nil, fmt.Errorf("failed to parse FluxFile: %w", err)
 	}
 
+	for i := range fluxFile.Tasks {
+		fluxFile.Tasks[i].File = path
+	}
+	for i := range fluxFile.Templates {
+		fluxFile.Templates[i].File = path
+	}
+
 	baseDir := filepath.Dir(path)
 	for _, include := range fluxFile.Includes {
-		includePath := filepath.Join(baseDir, include)
-		includedFile, err := Load(includePath)
+		includePath := filepath.Join(baseDir, include.Path)
+		includedFile, err := load(includePath, stack)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load included file %s: %w", include, err)
+			return nil, fmt.Errorf("failed to load included file %s: %w", include.Path, err)
 		}
 
 		for k, v := range includedFile.Vars {
@@ -102,13 +214,71 @@ func Load(path string) (*ast.FluxFile, error) {
 			}
 		}
 
+		// Dotenv files of an include are relative to it and come first, so
+		// that the including file's own files take precedence.
+		var dotenv []string
+		for _, file := range includedFile.Dotenv {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(filepath.Dir(includePath), file)
+			}
+			dotenv = append(dotenv, file)
+		}
+		fluxFile.Dotenv = append(dotenv, fluxFile.Dotenv...)
+
+		if include.Namespace != "" {
+			namespaceTasks(includedFile, include.Namespace)
+		}
+
 		fluxFile.Tasks = append(fluxFile.Tasks, includedFile.Tasks...)
+		fluxFile.Templates = append(fluxFile.Templates, includedFile.Templates...)
 		fluxFile.Profiles = append(fluxFile.Profiles, includedFile.Profiles...)
 	}
 
 	return fluxFile, nil
 }
 
+// namespaceTasks prefixes the tasks and templates of an included file with
+// its namespace. Deps and extends: references to tasks of the same file are
+// prefixed too, as are the tasks its profiles override, so included files
+// can keep using their short names.
+func namespaceTasks(fluxFile *ast.FluxFile, namespace string) {
+	local := make(map[string]bool)
+	for _, task := range fluxFile.Tasks {
+		local[task.Name] = true
+	}
+	for _, template := range fluxFile.Templates {
+		local[template.Name] = true
+	}
+
+	qualify := func(tasks []ast.Task) {
+		for i := range tasks {
+			deps := make([]string, len(tasks[i].Deps))
+			for j, dep := range tasks[i].Deps {
+				if local[dep] {
+					dep = namespace + ":" + dep
+				}
+				deps[j] = dep
+			}
+			tasks[i].Deps = deps
+			if local[tasks[i].Extends] {
+				tasks[i].Extends = namespace + ":" + tasks[i].Extends
+			}
+			tasks[i].Name = namespace + ":" + tasks[i].Name
+		}
+	}
+
+	qualify(fluxFile.Tasks)
+	qualify(fluxFile.Templates)
+
+	for i := range fluxFile.Profiles {
+		for j, task := range fluxFile.Profiles[i].Tasks {
+			if local[task.Name] {
+				fluxFile.Profiles[i].Tasks[j].Name = namespace + ":" + task.Name
+			}
+		}
+	}
+}
+
 func FindFluxFile() (string, error) {
 	candidates := []string{
 		"FluxFile",
@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -29,6 +31,22 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("Expected WatchDebounce 100ms, got %s", config.WatchDebounce)
 	}
 
+	if interval, err := config.PollInterval(); err != nil || interval != 500*time.Millisecond {
+		t.Errorf("Expected a 500ms poll interval, got %v, %v", interval, err)
+	}
+
+	if limit, err := config.OutputLimit(); err != nil || limit != 256*1024 {
+		t.Errorf("Expected a 256KB output limit, got %d, %v", limit, err)
+	}
+
+	if age, err := config.MaxLogAge(); err != nil || age != 0 || config.LogKeep != 50 {
+		t.Errorf("Expected 50 runs kept with no age limit, got %d, %v, %v", config.LogKeep, age, err)
+	}
+
+	if config.HistoryDir != ".flux/history" || config.HistoryKeep != 1000 || config.HistoryRegression != 20 {
+		t.Errorf("Unexpected history defaults %s, %d, %d", config.HistoryDir, config.HistoryKeep, config.HistoryRegression)
+	}
+
 	if config.Parallel {
 		t.Error("Expected Parallel to be false by default")
 	}
@@ -268,3 +286,431 @@ func TestLoadNotFound(t *testing.T) {
 		t.Error("Expected error for non-existent file")
 	}
 }
+
+func TestLoadNamespacedInclude(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "ci"), 0755)
+
+	root := `include "ci/FluxFile" as ci
+
+task build:
+    deps: ci:lint
+    run:
+        go build
+`
+	included := `task setup:
+    run:
+        echo setup
+
+task lint:
+    deps: setup
+    run:
+        golangci-lint run
+
+task build:
+    deps: lint
+    run:
+        echo ci build
+`
+	os.WriteFile(filepath.Join(dir, "FluxFile"), []byte(root), 0644)
+	os.WriteFile(filepath.Join(dir, "ci", "FluxFile"), []byte(included), 0644)
+
+	fluxFile, err := Load(filepath.Join(dir, "FluxFile"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tasks := make(map[string][]string)
+	for _, task := range fluxFile.Tasks {
+		tasks[task.Name] = task.Deps
+	}
+
+	if len(tasks) != 4 {
+		t.Fatalf("Expected 4 tasks, got %v", tasks)
+	}
+	if deps := tasks["ci:lint"]; len(deps) != 1 || deps[0] != "ci:setup" {
+		t.Errorf("Expected ci:lint to depend on ci:setup, got %v", deps)
+	}
+	if deps := tasks["ci:build"]; len(deps) != 1 || deps[0] != "ci:lint" {
+		t.Errorf("Expected ci:build to depend on ci:lint, got %v", deps)
+	}
+	if deps := tasks["build"]; len(deps) != 1 || deps[0] != "ci:lint" {
+		t.Errorf("Expected build to depend on ci:lint, got %v", deps)
+	}
+}
+
+func TestLoadNamespacedIncludeProfile(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "ci"), 0755)
+
+	root := `include "ci/FluxFile" as ci
+
+task build:
+    run:
+        go build
+`
+	included := `profile staging:
+    task build:
+        run: echo staging build
+
+task build:
+    run:
+        echo ci build
+`
+	os.WriteFile(filepath.Join(dir, "FluxFile"), []byte(root), 0644)
+	os.WriteFile(filepath.Join(dir, "ci", "FluxFile"), []byte(included), 0644)
+
+	fluxFile, err := Load(filepath.Join(dir, "FluxFile"))
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	profiles, err := ResolveProfiles(fluxFile, []string{"staging"})
+	if err != nil {
+		t.Fatalf("ResolveProfiles failed: %v", err)
+	}
+	for _, task := range fluxFile.Tasks {
+		run := strings.Join(ProfileTask(task, profiles).Run, ";")
+		switch task.Name {
+		case "ci:build":
+			if run != "echo staging build" {
+				t.Errorf("Expected the staging override of ci:build, got %q", run)
+			}
+		case "build":
+			if run != "go build" {
+				t.Errorf("Expected build not to be overridden, got %q", run)
+			}
+		}
+	}
+}
+
+func TestLoadDuplicateTask(t *testing.T) {
+	dir := t.TempDir()
+
+	root := `include "other.flux"
+
+task build:
+    run:
+        go build
+`
+	other := `task build:
+    run:
+        make
+`
+	os.WriteFile(filepath.Join(dir, "FluxFile"), []byte(root), 0644)
+	os.WriteFile(filepath.Join(dir, "other.flux"), []byte(other), 0644)
+
+	_, err := Load(filepath.Join(dir, "FluxFile"))
+	if err == nil {
+		t.Fatal("Expected duplicate task error")
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "duplicate task build") {
+		t.Errorf("Expected duplicate task error, got %q", msg)
+	}
+	if !strings.Contains(msg, "FluxFile:3") || !strings.Contains(msg, "other.flux:1") {
+		t.Errorf("Expected both locations in error, got %q", msg)
+	}
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	os.WriteFile(filepath.Join(dir, "FluxFile"), []byte("include \"a.flux\"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.flux"), []byte("include \"b.flux\"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.flux"), []byte("include \"a.flux\"\n"), 0644)
+
+	_, err := Load(filepath.Join(dir, "FluxFile"))
+	if err == nil {
+		t.Fatal("Expected include cycle error")
+	}
+
+	if !strings.Contains(err.Error(), "include cycle detected") {
+		t.Errorf("Expected include cycle error, got %q", err.Error())
+	}
+}
+
+func TestLoadInheritance(t *testing.T) {
+	dir := t.TempDir()
+
+	content := `template base:
+    env:
+        GOFLAGS = "-trimpath"
+        MODE = dev
+    pre:
+        command: go
+    cache: true
+    retries: 2
+    run:
+        echo base
+
+task build:
+    extends: base
+    env:
+        MODE = prod
+    cache: false
+    run:
+        go build
+`
+	path := filepath.Join(dir, "FluxFile")
+	os.WriteFile(path, []byte(content), 0644)
+
+	fluxFile, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	task := fluxFile.Tasks[0]
+	if task.Env["GOFLAGS"] != "-trimpath" || task.Env["MODE"] != "prod" {
+		t.Errorf("Expected overlaid env, got %v", task.Env)
+	}
+	if len(task.Pre) != 1 || task.Pre[0].Value != "go" {
+		t.Errorf("Expected inherited precondition, got %v", task.Pre)
+	}
+	if task.Cache {
+		t.Error("Expected cache: false to override template")
+	}
+	if task.Retries != 2 {
+		t.Errorf("Expected inherited retries 2, got %d", task.Retries)
+	}
+	if len(task.Run) != 1 || task.Run[0] != "go build" {
+		t.Errorf("Expected run to be replaced, got %v", task.Run)
+	}
+}
+
+func TestLoadInheritanceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "undefined parent",
+			content: "task a:\n    extends: missing\n",
+			want:    "task a extends undefined task or template missing",
+		},
+		{
+			name:    "cycle",
+			content: "template a:\n    extends: b\n\ntemplate b:\n    extends: a\n",
+			want:    "extends cycle detected",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "FluxFile")
+			os.WriteFile(path, []byte(tt.content), 0644)
+
+			_, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	content := `profile base:
+    var REGION = us-east-1
+    env:
+        LOG_LEVEL = info
+
+profile staging:
+    extends: base
+    var REGION = eu-west-1
+    task deploy:
+        remote: "staging.example.com"
+
+task deploy:
+    remote: "prod.example.com"
+    run:
+        ./deploy.sh
+`
+	path := filepath.Join(t.TempDir(), "FluxFile")
+	os.WriteFile(path, []byte(content), 0644)
+
+	fluxFile, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	profiles, err := ResolveProfiles(fluxFile, SplitProfiles("staging, base"))
+	if err != nil {
+		t.Fatalf("ResolveProfiles failed: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].Name != "base" || profiles[1].Name != "staging" {
+		t.Fatalf("Expected [base staging], got %d profiles", len(profiles))
+	}
+
+	vars := ProfileVars(profiles)
+	if vars["REGION"] != "eu-west-1" || vars["LOG_LEVEL"] != "info" {
+		t.Errorf("Unexpected profile vars %v", vars)
+	}
+
+	task := ProfileTask(fluxFile.Tasks[0], profiles)
+	if task.Remote != "staging.example.com" {
+		t.Errorf("Expected remote override, got %s", task.Remote)
+	}
+	if len(task.Run) != 1 || task.Run[0] != "./deploy.sh" {
+		t.Errorf("Expected run to be kept, got %v", task.Run)
+	}
+	if fluxFile.Tasks[0].Remote != "prod.example.com" {
+		t.Errorf("Override modified the task: %s", fluxFile.Tasks[0].Remote)
+	}
+}
+
+func TestLoadProfileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "undefined parent",
+			content: "profile a:\n    extends: missing\n",
+			want:    "profile a extends undefined profile missing",
+		},
+		{
+			name:    "cycle",
+			content: "profile a:\n    extends: b\n\nprofile b:\n    extends: a\n",
+			want:    "profile extends cycle detected",
+		},
+		{
+			name:    "undefined task",
+			content: "profile a:\n    task missing:\n        remote: \"host\"\n",
+			want:    "profile a overrides undefined task missing",
+		},
+		{
+			name:    "deps override",
+			content: "task b:\n    run:\n        echo b\n\nprofile a:\n    task b:\n        deps: b\n",
+			want:    "profile a cannot override deps of task b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "FluxFile")
+			os.WriteFile(path, []byte(tt.content), 0644)
+
+			_, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	os.Chdir(dir)
+
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	os.MkdirAll(filepath.Join(dir, "xdg", "flux"), 0755)
+	os.WriteFile(UserConfigPath(), []byte(`{"verbosity": "quiet", "parallel": true, "env": {"A": "user", "B": "user"}}`), 0644)
+	os.WriteFile(".fluxconfig", []byte(`{"parallel": false, "env": {"B": "project"}}`), 0644)
+	t.Setenv("FLUX_CACHE_DIR", "env-cache")
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if config.Verbosity != "quiet" {
+		t.Errorf("Expected user verbosity quiet, got %s", config.Verbosity)
+	}
+	if config.Parallel {
+		t.Error("Expected project config to override parallel")
+	}
+	if config.Env["A"] != "user" || config.Env["B"] != "project" {
+		t.Errorf("Expected merged env, got %v", config.Env)
+	}
+	if config.CacheDir != "env-cache" {
+		t.Errorf("Expected FLUX_CACHE_DIR override, got %s", config.CacheDir)
+	}
+
+	t.Setenv("FLUX_NO_CACHE", "sometimes")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "FLUX_NO_CACHE") {
+		t.Errorf("Expected invalid FLUX_NO_CACHE error, got %v", err)
+	}
+}
+
+func TestLoadConfigInvalidValue(t *testing.T) {
+	dir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	os.Chdir(dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	os.MkdirAll(filepath.Join(dir, "xdg", "flux"), 0755)
+
+	os.WriteFile(UserConfigPath(), []byte(`{"output": "prefix"}`), 0644)
+	_, err := LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), UserConfigPath()) || !strings.Contains(err.Error(), "output must be") {
+		t.Errorf("Expected the user config's invalid output to be reported, got %v", err)
+	}
+
+	os.WriteFile(UserConfigPath(), []byte(`{"output": "prefixed"}`), 0644)
+	os.WriteFile(".fluxconfig", []byte(`{"verbosity": "verbos"}`), 0644)
+	_, err = LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), ".fluxconfig") || !strings.Contains(err.Error(), "verbosity must be") {
+		t.Errorf("Expected the project config's invalid verbosity to be reported, got %v", err)
+	}
+}
+
+func TestSetFileValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"cache_dir": "keep"}`), 0644)
+
+	if err := SetFileValue(path, "no_cache", "true"); err != nil {
+		t.Fatalf("SetFileValue failed: %v", err)
+	}
+	if err := SetFileValue(path, "env.TOKEN", "abc"); err != nil {
+		t.Fatalf("SetFileValue failed: %v", err)
+	}
+	if err := SetFileValue(path, "verbosity", "loud"); err == nil {
+		t.Error("Expected invalid verbosity to be rejected")
+	}
+	if err := SetFileValue(path, "watch_poll_interval", "0s"); err == nil {
+		t.Error("Expected a zero poll interval to be rejected")
+	}
+	if err := SetFileValue(path, "log_output_limit", "lots"); err == nil {
+		t.Error("Expected an invalid size to be rejected")
+	}
+	if err := SetFileValue(path, "log_output_limit", "1 MB"); err != nil {
+		t.Errorf("Expected 1 MB to be accepted, got %v", err)
+	}
+	if err := SetFileValue(path, "output", "colorful"); err == nil {
+		t.Error("Expected an unknown output mode to be rejected")
+	}
+	if err := SetFileValue(path, "log_keep", "-1"); err == nil {
+		t.Error("Expected a negative log_keep to be rejected")
+	}
+	if err := SetFileValue(path, "history_regression", "0"); err == nil {
+		t.Error("Expected a zero history_regression to be rejected")
+	}
+	if err := SetFileValue(path, "log_max_age", "30d"); err != nil {
+		t.Errorf("Expected 30d to be accepted, got %v", err)
+	}
+	if err := SetFileValue(path, "log_max_age", "a week"); err == nil {
+		t.Error("Expected an invalid age to be rejected")
+	}
+	if err := SetFileValue(path, "color", "on"); err == nil {
+		t.Error("Expected unknown key to be rejected")
+	}
+
+	config := DefaultConfig()
+	if err := mergeConfigFile(config, path); err != nil {
+		t.Fatalf("mergeConfigFile failed: %v", err)
+	}
+	if config.CacheDir != "keep" || !config.NoCache || config.Env["TOKEN"] != "abc" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+	if value, _ := config.Get("env.TOKEN"); value != "abc" {
+		t.Errorf("Expected env.TOKEN abc, got %s", value)
+	}
+	if age, err := config.MaxLogAge(); err != nil || age != 30*24*time.Hour {
+		t.Errorf("Expected a 30 day log age, got %v, %v", age, err)
+	}
+}
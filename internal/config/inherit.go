@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/vars"
+)
+
+// ResolveInheritance applies extends: directives to every task and template
+// of the file. A task inherits all directives of its parent and overrides
+// the ones it declares itself; lists are merged and maps are overlaid. The
+// run block is the exception: a task that declares run replaces the
+// inherited commands.
+func ResolveInheritance(fluxFile *ast.FluxFile) error {
+	templates := make(map[string]*ast.Task)
+	for i := range fluxFile.Templates {
+		templates[fluxFile.Templates[i].Name] = &fluxFile.Templates[i]
+	}
+	tasks := make(map[string]*ast.Task)
+	for i := range fluxFile.Tasks {
+		tasks[fluxFile.Tasks[i].Name] = &fluxFile.Tasks[i]
+	}
+
+	resolved := make(map[*ast.Task]bool)
+	var resolve func(task *ast.Task, chain []*ast.Task) error
+	resolve = func(task *ast.Task, chain []*ast.Task) error {
+		if resolved[task] || task.Extends == "" {
+			return nil
+		}
+
+		for i, t := range chain {
+			if t == task {
+				var names []string
+				for _, c := range chain[i:] {
+					names = append(names, c.Name)
+				}
+				names = append(names, task.Name)
+				return fmt.Errorf("extends cycle detected: %s", strings.Join(names, " -> "))
+			}
+		}
+
+		parent, ok := templates[task.Extends]
+		if !ok {
+			parent, ok = tasks[task.Extends]
+		}
+		if !ok {
+			return fmt.Errorf("task %s extends undefined task or template %s", task.Name, task.Extends)
+		}
+
+		if err := resolve(parent, append(chain, task)); err != nil {
+			return err
+		}
+
+		inherit(task, parent)
+		resolved[task] = true
+		return nil
+	}
+
+	for i := range fluxFile.Templates {
+		if err := resolve(&fluxFile.Templates[i], nil); err != nil {
+			return err
+		}
+	}
+	for i := range fluxFile.Tasks {
+		if err := resolve(&fluxFile.Tasks[i], nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func inherit(task, parent *ast.Task) {
+	set := func(directive string, nonZero bool) bool {
+		return task.Declared[directive] || nonZero
+	}
+
+	if !set("desc", task.Desc != "") {
+		task.Desc = parent.Desc
+	}
+	if !set("parallel", task.Parallel) {
+		task.Parallel = parent.Parallel
+	}
+	if !set("restart", task.Restart) {
+		task.Restart = parent.Restart
+	}
+	if !set("if", task.If != "") {
+		task.If = parent.If
+	}
+	if !set("run", len(task.Run) > 0) {
+		task.Run = append([]string{}, parent.Run...)
+	}
+	if !set("cache", task.Cache) {
+		task.Cache = parent.Cache
+	}
+	if !set("docker", task.Docker) {
+		task.Docker = parent.Docker
+	}
+	if !set("remote", task.Remote != "") {
+		task.Remote = parent.Remote
+	}
+	if !set("profile_task", task.Profile != "") {
+		task.Profile = parent.Profile
+	}
+	if !set("retries", task.Retries != 0) {
+		task.Retries = parent.Retries
+	}
+	if !set("retry_delay", task.RetryDelay != "") {
+		task.RetryDelay = parent.RetryDelay
+	}
+	if !set("timeout", task.Timeout != "") {
+		task.Timeout = parent.Timeout
+	}
+	if !set("prompt", task.Prompt != "") {
+		task.Prompt = parent.Prompt
+	}
+	if !set("shell", task.Shell != "") {
+		task.Shell = parent.Shell
+	}
+	if !set("notify", task.Notify != (ast.NotifyConfig{})) {
+		task.Notify = parent.Notify
+	}
+
+	task.Deps = mergeList(parent.Deps, task.Deps)
+	task.Watch = mergeList(parent.Watch, task.Watch)
+	task.WatchIgnore = mergeList(parent.WatchIgnore, task.WatchIgnore)
+	task.Inputs = mergeList(parent.Inputs, task.Inputs)
+	task.Outputs = mergeList(parent.Outputs, task.Outputs)
+	task.Secrets = mergeSecrets(parent.Secrets, task.Secrets)
+	task.Dotenv = mergeList(parent.Dotenv, task.Dotenv)
+	task.Pre = mergePreconditions(parent.Pre, task.Pre)
+	task.Env = vars.MergeVars(parent.Env, task.Env)
+
+	if parent.Matrix != nil {
+		matrix := ast.NewMatrix()
+		for k, v := range parent.Matrix.Dimensions {
+			matrix.Dimensions[k] = v
+		}
+		if task.Matrix != nil {
+			for k, v := range task.Matrix.Dimensions {
+				matrix.Dimensions[k] = v
+			}
+		}
+		task.Matrix = matrix
+	}
+
+	declared := make(map[string]bool)
+	for k := range parent.Declared {
+		declared[k] = true
+	}
+	for k := range task.Declared {
+		declared[k] = true
+	}
+	task.Declared = declared
+}
+
+func mergeList(base, extra []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, list := range [][]string{base, extra} {
+		for _, item := range list {
+			if !seen[item] {
+				seen[item] = true
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// mergeSecrets appends extra to base. A secret in both keeps its position
+// from base and the source from extra.
+func mergeSecrets(base, extra []ast.Secret) []ast.Secret {
+	index := make(map[string]int)
+	result := []ast.Secret{}
+	for _, list := range [][]ast.Secret{base, extra} {
+		for _, secret := range list {
+			if i, ok := index[secret.Name]; ok {
+				result[i] = secret
+				continue
+			}
+			index[secret.Name] = len(result)
+			result = append(result, secret)
+		}
+	}
+	return result
+}
+
+func mergePreconditions(base, extra []ast.Precondition) []ast.Precondition {
+	seen := make(map[ast.Precondition]bool)
+	result := []ast.Precondition{}
+	for _, list := range [][]ast.Precondition{base, extra} {
+		for _, pre := range list {
+			if !seen[pre] {
+				seen[pre] = true
+				result = append(result, pre)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/vars"
+)
+
+// SplitProfiles splits a -p value such as "base,prod" into profile names.
+func SplitProfiles(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ResolveProfiles returns the named profiles in order, each preceded by the
+// profiles it extends. A profile reached more than once is applied once, at
+// its first position.
+func ResolveProfiles(fluxFile *ast.FluxFile, names []string) ([]*ast.Profile, error) {
+	profiles := make(map[string]*ast.Profile)
+	for i := range fluxFile.Profiles {
+		profiles[fluxFile.Profiles[i].Name] = &fluxFile.Profiles[i]
+	}
+
+	var result []*ast.Profile
+	added := make(map[string]bool)
+
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		for i, n := range chain {
+			if n == name {
+				cycle := append(append([]string{}, chain[i:]...), name)
+				return fmt.Errorf("profile extends cycle detected: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		profile, ok := profiles[name]
+		if !ok {
+			if len(chain) > 0 {
+				return fmt.Errorf("profile %s extends undefined profile %s", chain[len(chain)-1], name)
+			}
+			return fmt.Errorf("profile not found: %s", name)
+		}
+		if added[name] {
+			return nil
+		}
+
+		if profile.Extends != "" {
+			if err := visit(profile.Extends, append(chain, name)); err != nil {
+				return err
+			}
+		}
+
+		added[name] = true
+		result = append(result, profile)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// ProfileVars merges the vars and env of profiles, later profiles taking
+// precedence.
+func ProfileVars(profiles []*ast.Profile) map[string]string {
+	result := make(map[string]string)
+	for _, profile := range profiles {
+		result = vars.MergeVars(result, profile.Vars)
+		result = vars.MergeVars(result, profile.Env)
+	}
+	return result
+}
+
+// ProfileTask returns a copy of task with the task overrides of profiles
+// applied. Directives declared in an override replace the task's own and
+// env is overlaid.
+func ProfileTask(task ast.Task, profiles []*ast.Profile) ast.Task {
+	for _, profile := range profiles {
+		for i := range profile.Tasks {
+			if profile.Tasks[i].Name == task.Name {
+				task = override(task, &profile.Tasks[i])
+			}
+		}
+	}
+	return task
+}
+
+func override(task ast.Task, o *ast.Task) ast.Task {
+	declared := o.Declared
+
+	if declared["desc"] {
+		task.Desc = o.Desc
+	}
+	if declared["parallel"] {
+		task.Parallel = o.Parallel
+	}
+	if declared["if"] {
+		task.If = o.If
+	}
+	if declared["run"] {
+		task.Run = o.Run
+	}
+	if declared["env"] {
+		task.Env = vars.MergeVars(task.Env, o.Env)
+	}
+	if declared["watch"] {
+		task.Watch = o.Watch
+	}
+	if declared["ignore"] {
+		task.WatchIgnore = o.WatchIgnore
+	}
+	if declared["matrix"] {
+		task.Matrix = o.Matrix
+	}
+	if declared["cache"] {
+		task.Cache = o.Cache
+	}
+	if declared["inputs"] {
+		task.Inputs = o.Inputs
+	}
+	if declared["outputs"] {
+		task.Outputs = o.Outputs
+	}
+	if declared["docker"] {
+		task.Docker = o.Docker
+	}
+	if declared["remote"] {
+		task.Remote = o.Remote
+	}
+	if declared["secrets"] {
+		task.Secrets = o.Secrets
+	}
+	if declared["pre"] {
+		task.Pre = o.Pre
+	}
+	if declared["retries"] {
+		task.Retries = o.Retries
+	}
+	if declared["retry_delay"] {
+		task.RetryDelay = o.RetryDelay
+	}
+	if declared["timeout"] {
+		task.Timeout = o.Timeout
+	}
+	if declared["prompt"] {
+		task.Prompt = o.Prompt
+	}
+	if declared["notify"] {
+		task.Notify = o.Notify
+	}
+	if declared["shell"] {
+		task.Shell = o.Shell
+	}
+	if declared["dotenv"] {
+		task.Dotenv = o.Dotenv
+	}
+	if declared["restart"] {
+		task.Restart = o.Restart
+	}
+
+	merged := make(map[string]bool)
+	for k := range task.Declared {
+		merged[k] = true
+	}
+	for k := range declared {
+		merged[k] = true
+	}
+	task.Declared = merged
+
+	return task
+}
+
+// checkProfiles reports profiles that extend missing profiles or form a
+// cycle, and task overrides that cannot be applied.
+func checkProfiles(fluxFile *ast.FluxFile) error {
+	tasks := make(map[string]bool)
+	for _, task := range fluxFile.Tasks {
+		tasks[task.Name] = true
+	}
+
+	for _, profile := range fluxFile.Profiles {
+		if _, err := ResolveProfiles(fluxFile, []string{profile.Name}); err != nil {
+			return err
+		}
+
+		for _, task := range profile.Tasks {
+			if !tasks[task.Name] {
+				return fmt.Errorf("profile %s overrides undefined task %s", profile.Name, task.Name)
+			}
+			for _, directive := range []string{"deps", "extends", "profile_task"} {
+				if task.Declared[directive] {
+					return fmt.Errorf("profile %s cannot override %s of task %s", profile.Name, directive, task.Name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,364 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys lists the settings accepted by flux config and FLUX_* variables.
+// Env entries are addressed as env.NAME.
+var Keys = []string{
+	"default_profile",
+	"cache_dir",
+	"log_dir",
+	"log_output_limit",
+	"log_keep",
+	"log_max_age",
+	"history_dir",
+	"history_keep",
+	"history_regression",
+	"verbosity",
+	"output",
+	"parallel",
+	"no_cache",
+	"watch_debounce",
+	"watch_poll",
+	"watch_poll_interval",
+}
+
+// Get returns the value of a setting as a string.
+func (c *FluxConfig) Get(key string) (string, error) {
+	if name, ok := envKey(key); ok {
+		return c.Env[name], nil
+	}
+
+	switch key {
+	case "default_profile":
+		return c.DefaultProfile, nil
+	case "cache_dir":
+		return c.CacheDir, nil
+	case "log_dir":
+		return c.LogDir, nil
+	case "log_output_limit":
+		return c.LogOutputLimit, nil
+	case "log_keep":
+		return strconv.Itoa(c.LogKeep), nil
+	case "log_max_age":
+		return c.LogMaxAge, nil
+	case "history_dir":
+		return c.HistoryDir, nil
+	case "history_keep":
+		return strconv.Itoa(c.HistoryKeep), nil
+	case "history_regression":
+		return strconv.Itoa(c.HistoryRegression), nil
+	case "verbosity":
+		return c.Verbosity, nil
+	case "output":
+		return c.Output, nil
+	case "parallel":
+		return strconv.FormatBool(c.Parallel), nil
+	case "no_cache":
+		return strconv.FormatBool(c.NoCache), nil
+	case "watch_debounce":
+		return c.WatchDebounce, nil
+	case "watch_poll":
+		return strconv.FormatBool(c.WatchPoll), nil
+	case "watch_poll_interval":
+		return c.WatchPollInterval, nil
+	}
+	return "", fmt.Errorf("unknown config key %s", key)
+}
+
+// Set parses value and stores it in the setting key.
+func (c *FluxConfig) Set(key, value string) error {
+	parsed, err := parseValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	if name, ok := envKey(key); ok {
+		if c.Env == nil {
+			c.Env = make(map[string]string)
+		}
+		c.Env[name] = value
+		return nil
+	}
+
+	switch key {
+	case "default_profile":
+		c.DefaultProfile = value
+	case "cache_dir":
+		c.CacheDir = value
+	case "log_dir":
+		c.LogDir = value
+	case "log_output_limit":
+		c.LogOutputLimit = value
+	case "log_keep":
+		c.LogKeep = parsed.(int)
+	case "log_max_age":
+		c.LogMaxAge = value
+	case "history_dir":
+		c.HistoryDir = value
+	case "history_keep":
+		c.HistoryKeep = parsed.(int)
+	case "history_regression":
+		c.HistoryRegression = parsed.(int)
+	case "verbosity":
+		c.Verbosity = value
+	case "output":
+		c.Output = value
+	case "parallel":
+		c.Parallel = parsed.(bool)
+	case "no_cache":
+		c.NoCache = parsed.(bool)
+	case "watch_debounce":
+		c.WatchDebounce = value
+	case "watch_poll":
+		c.WatchPoll = parsed.(bool)
+	case "watch_poll_interval":
+		c.WatchPollInterval = value
+	}
+	return nil
+}
+
+// Validate checks every setting the way Set does, so that a typo in a
+// config file is reported instead of ignored.
+func (c *FluxConfig) Validate() error {
+	for _, key := range Keys {
+		value, err := c.Get(key)
+		if err != nil {
+			return err
+		}
+		if _, err := parseValue(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns every setting, including env entries, as key/value pairs
+// sorted by key.
+func (c *FluxConfig) List() [][2]string {
+	var result [][2]string
+	for _, key := range Keys {
+		value, _ := c.Get(key)
+		result = append(result, [2]string{key, value})
+	}
+
+	names := make([]string, 0, len(c.Env))
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, [2]string{"env." + name, c.Env[name]})
+	}
+	return result
+}
+
+// DebounceDuration returns WatchDebounce as a duration.
+func (c *FluxConfig) DebounceDuration() (time.Duration, error) {
+	d, err := time.ParseDuration(c.WatchDebounce)
+	if err != nil {
+		return 0, fmt.Errorf("invalid watch_debounce %q", c.WatchDebounce)
+	}
+	return d, nil
+}
+
+// PollInterval returns WatchPollInterval as a duration.
+func (c *FluxConfig) PollInterval() (time.Duration, error) {
+	d, err := time.ParseDuration(c.WatchPollInterval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid watch_poll_interval %q", c.WatchPollInterval)
+	}
+	return d, nil
+}
+
+// OutputLimit returns LogOutputLimit in bytes.
+func (c *FluxConfig) OutputLimit() (int, error) {
+	n, err := parseSize(c.LogOutputLimit)
+	if err != nil {
+		return 0, fmt.Errorf("invalid log_output_limit %q", c.LogOutputLimit)
+	}
+	return n, nil
+}
+
+// MaxLogAge returns LogMaxAge as a duration; 0 means no limit.
+func (c *FluxConfig) MaxLogAge() (time.Duration, error) {
+	d, err := ParseAge(c.LogMaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid log_max_age %q", c.LogMaxAge)
+	}
+	return d, nil
+}
+
+// ParseAge parses a duration that may also be given in days, as in 30d.
+func ParseAge(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return d, nil
+}
+
+// parseSize parses a byte count with an optional B, KB, MB or GB suffix.
+func parseSize(value string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	unit := 1
+	for _, suffix := range []struct {
+		name string
+		size int
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, suffix.name) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, suffix.name)), suffix.size
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * unit, nil
+}
+
+// SetFileValue sets key in the config file at path, keeping the other
+// settings of that file as written.
+func SetFileValue(path, key, value string) error {
+	parsed, err := parseValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	raw := make(map[string]interface{})
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if name, ok := envKey(key); ok {
+		env, _ := raw["env"].(map[string]interface{})
+		if env == nil {
+			env = make(map[string]interface{})
+		}
+		env[name] = value
+		raw["env"] = env
+	} else {
+		raw[key] = parsed
+	}
+
+	data, err = json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// applyEnvOverrides applies FLUX_<KEY> variables, such as FLUX_CACHE_DIR.
+func applyEnvOverrides(config *FluxConfig) error {
+	for _, key := range Keys {
+		name := "FLUX_" + strings.ToUpper(key)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := config.Set(key, value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func parseValue(key, value string) (interface{}, error) {
+	if name, ok := envKey(key); ok {
+		if name == "" {
+			return nil, fmt.Errorf("missing variable name in %s", key)
+		}
+		return value, nil
+	}
+
+	switch key {
+	case "default_profile", "cache_dir", "log_dir", "history_dir":
+		return value, nil
+	case "verbosity":
+		switch value {
+		case "quiet", "normal", "verbose":
+			return value, nil
+		}
+		return nil, fmt.Errorf("verbosity must be quiet, normal or verbose, got %q", value)
+	case "output":
+		switch value {
+		case "interleaved", "prefixed", "grouped":
+			return value, nil
+		}
+		return nil, fmt.Errorf("output must be interleaved, prefixed or grouped, got %q", value)
+	case "parallel", "no_cache", "watch_poll":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, got %q", key, value)
+		}
+		return b, nil
+	case "log_output_limit":
+		if _, err := parseSize(value); err != nil {
+			return nil, fmt.Errorf("log_output_limit must be a size such as 256KB or 0 for no limit, got %q", value)
+		}
+		return value, nil
+	case "log_keep":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("log_keep must be a number of runs or 0 for no limit, got %q", value)
+		}
+		return n, nil
+	case "history_keep":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("history_keep must be a number of runs or 0 for no limit, got %q", value)
+		}
+		return n, nil
+	case "history_regression":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("history_regression must be a percentage above 0, got %q", value)
+		}
+		return n, nil
+	case "log_max_age":
+		if _, err := ParseAge(value); err != nil {
+			return nil, fmt.Errorf("log_max_age must be a duration such as 72h or 30d, or 0 for no limit, got %q", value)
+		}
+		return value, nil
+	case "watch_debounce", "watch_poll_interval":
+		d, err := time.ParseDuration(value)
+		if err != nil || (key == "watch_poll_interval" && d <= 0) {
+			return nil, fmt.Errorf("%s must be a duration such as 200ms, got %q", key, value)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown config key %s", key)
+}
+
+func envKey(key string) (string, bool) {
+	if strings.HasPrefix(key, "env.") {
+		return strings.TrimPrefix(key, "env."), true
+	}
+	return "", false
+}
@@ -1,21 +1,26 @@
 package executor
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
 	"github.com/ashavijit/fluxfile/internal/cache"
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/expr"
 	"github.com/ashavijit/fluxfile/internal/graph"
 	"github.com/ashavijit/fluxfile/internal/logger"
 	"github.com/ashavijit/fluxfile/internal/logs"
 	"github.com/ashavijit/fluxfile/internal/report"
+	"github.com/ashavijit/fluxfile/internal/secrets"
+	"github.com/ashavijit/fluxfile/internal/telemetry"
 	"github.com/ashavijit/fluxfile/internal/vars"
 )
 
@@ -25,11 +30,43 @@ type Executor struct {
 	cache     *cache.Cache
 	logger    *logger.Logger
 	vars      map[string]string
+	overrides map[string]string
+	configEnv map[string]string
+	dotenv    map[string]string
+	profiles  []*ast.Profile
 	dryRun    bool
+	strict    bool
+	parallel  bool
 	collector *report.Collector
 	logStore  *logs.LogStore
+	tracer    *telemetry.Tracer
+	traceRoot *telemetry.Span
+	secrets   *secrets.Resolver
+	masker    *secrets.Masker
+	// outputLimit caps the bytes of command output kept per command.
+	outputLimit int
+	outputMode  string
+	groupMu     sync.Mutex
+
+	procMu   sync.Mutex
+	procs    map[*exec.Cmd]bool
+	stopping bool
+
+	// ran records the tasks that ran, rather than being skipped, in the
+	// current invocation, and finished when each task ended.
+	ranMu    sync.Mutex
+	ran      map[string]bool
+	finished map[string]time.Time
+	runStart time.Time
+
+	// workers holds which worker lanes are running a task.
+	workerMu sync.Mutex
+	workers  []bool
 }
 
+// ErrStopped is returned by Execute when Stop ended its commands.
+var ErrStopped = errors.New("stopped")
+
 type ExecutionResult struct {
 	TaskName string
 	Success  bool
@@ -48,26 +85,109 @@ func New(fluxFile *ast.FluxFile, cacheDir string, dryRun bool) (*Executor, error
 		return nil, err
 	}
 
+	masker := secrets.NewMasker()
+	log := logger.New()
+	log.SetMasker(masker)
+
 	return &Executor{
-		fluxFile: fluxFile,
-		graph:    g,
-		cache:    c,
-		logger:   logger.New(),
-		vars:     fluxFile.Vars,
-		dryRun:   dryRun,
+		fluxFile:    fluxFile,
+		graph:       g,
+		cache:       c,
+		logger:      log,
+		vars:        fluxFile.Vars,
+		dryRun:      dryRun,
+		secrets:     secrets.NewResolver(),
+		masker:      masker,
+		procs:       make(map[*exec.Cmd]bool),
+		outputLimit: defaultOutputLimit,
+		outputMode:  OutputInterleaved,
 	}, nil
 }
 
 func (e *Executor) SetCollector(c *report.Collector) {
+	c.SetMasker(e.masker)
 	e.collector = c
 }
 
-func (e *Executor) Execute(taskName string, profile string, useCache bool) error {
-	if profile != "" {
-		e.applyProfile(profile)
+// Masker returns the masker that holds the values of the secrets loaded so
+// far, for redacting output produced outside the executor.
+func (e *Executor) Masker() *secrets.Masker {
+	return e.masker
+}
+
+// SetOverrides sets vars from the command line and var files. They take
+// precedence over FluxFile vars, profiles and task env.
+func (e *Executor) SetOverrides(overrides map[string]string) {
+	e.overrides = overrides
+	e.vars = vars.MergeVars(e.vars, overrides)
+}
+
+// SetEnv sets vars from the env section of .fluxconfig. FluxFile vars take
+// precedence over them.
+func (e *Executor) SetEnv(env map[string]string) {
+	e.configEnv = env
+}
+
+// SetParallel runs the dependencies of every task in parallel, as if the
+// task declared parallel: true.
+func (e *Executor) SetParallel(parallel bool) {
+	e.parallel = parallel
+}
+
+// SetVerbosity sets the logger level: quiet, normal or verbose.
+func (e *Executor) SetVerbosity(level string) {
+	e.logger.SetVerbosity(level)
+}
+
+// SetOutputLimit sets how many bytes of each command's output are kept in
+// the log store. 0 keeps all of it.
+func (e *Executor) SetOutputLimit(limit int) {
+	e.outputLimit = limit
+}
+
+// SetOutputMode sets how the output of tasks is written: OutputInterleaved,
+// OutputPrefixed or OutputGrouped.
+func (e *Executor) SetOutputMode(mode string) {
+	e.outputMode = mode
+}
+
+func (e *Executor) OutputMode() string {
+	return e.outputMode
+}
+
+// SetTracer records a span for every task and command, as children of root.
+// Commands get the trace context of their span in TRACEPARENT.
+func (e *Executor) SetTracer(t *telemetry.Tracer, root *telemetry.Span) {
+	e.tracer = t
+	e.traceRoot = root
+}
+
+// Profiles returns the names of the profiles applied to the current run,
+// joined by commas.
+func (e *Executor) Profiles() string {
+	names := make([]string, len(e.profiles))
+	for i, p := range e.profiles {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// RunID returns the ID of the task logs of the current or last run, or ""
+// when they could not be written.
+func (e *Executor) RunID() string {
+	if e.logStore == nil {
+		return ""
 	}
+	return e.logStore.RunID()
+}
 
-	if err := vars.ResolveVars(e.vars); err != nil {
+// SetStrict makes unresolved ${...} references in commands an error.
+func (e *Executor) SetStrict(strict bool) {
+	e.strict = strict
+}
+
+func (e *Executor) Execute(taskName string, profile string, useCache bool) error {
+	if err := e.begin(profile); err != nil {
 		return err
 	}
 
@@ -81,7 +201,13 @@ func (e *Executor) Execute(taskName string, profile string, useCache bool) error
 		return err
 	}
 
-	if task.Parallel && len(deps) > 0 {
+	// A profile may override parallel:.
+	profiled, _, err := e.profileTask(task, e.profiles)
+	if err != nil {
+		return err
+	}
+
+	if (profiled.Parallel || e.parallel) && len(deps) > 0 {
 		if err := e.executeDependenciesParallel(deps, useCache); err != nil {
 			return err
 		}
@@ -100,48 +226,219 @@ func (e *Executor) Execute(taskName string, profile string, useCache bool) error
 	return e.executeTask(task, useCache)
 }
 
-func (e *Executor) executeTask(task *ast.Task, useCache bool) error {
-	e.logger.TaskStart(task.Name)
-	start := time.Now()
+// ExecuteTasks runs the named tasks in the given order without running
+// their dependencies first.
+func (e *Executor) ExecuteTasks(names []string, profile string, useCache bool) error {
+	if err := e.begin(profile); err != nil {
+		return err
+	}
 
-	if e.logStore == nil {
-		e.logStore, _ = logs.NewLogStore(logs.GetLogDir())
+	for _, name := range names {
+		task, err := e.graph.GetTask(name)
+		if err != nil {
+			return err
+		}
+		if err := e.executeTask(task, useCache); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// begin resets the per-run state and applies profile.
+func (e *Executor) begin(profile string) error {
+	vars.ResetShellCache()
+	e.secrets = secrets.NewResolver()
+
+	e.procMu.Lock()
+	e.stopping = false
+	e.procMu.Unlock()
+
+	e.ranMu.Lock()
+	e.ran = make(map[string]bool)
+	e.finished = make(map[string]time.Time)
+	e.runStart = time.Now()
+	e.ranMu.Unlock()
+
+	// Every run logs to a directory of its own. Tasks are not logged when
+	// it cannot be created.
+	e.logStore, _ = logs.NewLogStore(logs.GetLogDir())
 	if e.logStore != nil {
-		e.logStore.StartTask(task.Name)
-		e.logStore.Log("info", fmt.Sprintf("Starting task: %s", task.Name))
+		e.logStore.SetMasker(e.masker)
+	}
+
+	profiles, err := config.ResolveProfiles(e.fluxFile, config.SplitProfiles(profile))
+	if err != nil {
+		return err
+	}
+	e.profiles = profiles
+	for _, p := range profiles {
+		e.logger.Info(fmt.Sprintf("Applied profile: %s", p.Name))
 	}
 
-	taskVars := vars.MergeVars(e.vars, task.Env)
+	if err := vars.ResolveVars(e.vars); err != nil {
+		return err
+	}
 
-	if task.Profile != "" {
-		e.applyProfile(task.Profile)
-		taskVars = vars.MergeVars(e.vars, task.Env)
+	e.dotenv, err = vars.LoadDotenv(e.fluxFile.Dotenv, vars.MergeVars(e.configEnv, e.vars))
+	return err
+}
+
+func (e *Executor) executeTask(task *ast.Task, useCache bool) error {
+	worker := e.acquireWorker()
+	defer e.releaseWorker(worker)
+
+	log := e.logStore.StartTask(task.Name)
+	log.Log("info", fmt.Sprintf("Starting task: %s", task.Name))
+
+	run := e.newTaskRun(task, log)
+	run.worker, run.wait = worker, e.waitTime(task)
+	log.SetSchedule(worker, run.wait)
+	run.span = e.tracer.Start(task.Name, e.traceRoot)
+	run.span.SetAttribute("flux.task.name", task.Name)
+	run.span.SetAttribute("flux.profile", e.Profiles())
+	run.span.SetAttribute("flux.worker", worker)
+	err := e.runTask(task, useCache, run)
+	e.flush(run)
+	if err != nil {
+		log.SetError(err.Error())
+	}
+	log.End(err == nil)
+	run.span.End(err)
+
+	e.ranMu.Lock()
+	e.finished[task.Name] = time.Now()
+	e.ranMu.Unlock()
+	return err
+}
+
+// acquireWorker returns the lowest numbered worker lane that is free and
+// marks it busy. Lanes are numbered from 1 and show which tasks ran at the
+// same time in traces and timelines.
+func (e *Executor) acquireWorker() int {
+	e.workerMu.Lock()
+	defer e.workerMu.Unlock()
+	for i, busy := range e.workers {
+		if !busy {
+			e.workers[i] = true
+			return i + 1
+		}
+	}
+	e.workers = append(e.workers, true)
+	return len(e.workers)
+}
+
+func (e *Executor) releaseWorker(worker int) {
+	e.workerMu.Lock()
+	defer e.workerMu.Unlock()
+	e.workers[worker-1] = false
+}
+
+// waitTime returns how long task was blocked on its dependencies: the time
+// from the start of the run until the last of them finished.
+func (e *Executor) waitTime(task *ast.Task) time.Duration {
+	e.ranMu.Lock()
+	defer e.ranMu.Unlock()
+	var last time.Time
+	for _, dep := range task.Deps {
+		if end := e.finished[dep]; end.After(last) {
+			last = end
+		}
+	}
+	if last.IsZero() {
+		return 0
+	}
+	return last.Sub(e.runStart)
+}
+
+// addResult adds the outcome of run to its span and to the report, if
+// there is one.
+func (e *Executor) addResult(name string, run *taskRun, status string, err error) {
+	run.span.SetAttribute("flux.task.status", status)
+	run.span.SetAttribute("flux.task.cache_hit", status == "cached")
+	if e.collector == nil {
+		return
+	}
+	result := report.TaskResult{
+		Name:      name,
+		Status:    status,
+		CacheHit:  status == "cached",
+		StartTime: run.start,
+		EndTime:   time.Now(),
+		Worker:    run.worker,
+		WaitTime:  run.wait,
+		InputHash: run.inputHash,
+	}
+	if status == "passed" || status == "failed" {
+		result.Duration = result.EndTime.Sub(result.StartTime)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if run.stdout != nil {
+		result.Stdout = run.stdout.String()
+		result.Stderr = run.stderr.String()
+	}
+	e.collector.AddResult(result)
+}
+
+// failed records err as the result of a task that failed before its
+// commands ran, and returns it.
+func (e *Executor) failed(name string, run *taskRun, err error) error {
+	e.addResult(name, run, "failed", err)
+	return err
+}
+
+// runTask runs a task. run belongs to this execution of the task alone.
+func (e *Executor) runTask(task *ast.Task, useCache bool, run *taskRun) error {
+	e.logger.TaskStart(task.Name)
+	start := time.Now()
+	run.start = start
+
+	name := task.Name
+	task, taskVars, err := e.prepareTask(task)
+	if err != nil {
+		return e.failed(name, run, err)
 	}
 
 	if len(task.Secrets) > 0 {
 		if err := e.loadSecrets(task.Secrets, taskVars); err != nil {
-			return err
+			return e.failed(name, run, err)
 		}
 	}
 
+	env, err := e.taskEnv(task, taskVars)
+	if err != nil {
+		return e.failed(name, run, err)
+	}
+
+	var snapshots []*cache.CacheEntry
 	if task.If != "" {
-		shouldRun, err := e.evaluateCondition(task.If, taskVars)
+		env := &expr.Env{
+			Vars: env,
+			Changed: func(pattern string) (bool, error) {
+				changed, entry, err := e.changedSince(task.Name, pattern)
+				if entry != nil {
+					snapshots = append(snapshots, entry)
+				}
+				return changed, err
+			},
+		}
+		shouldRun, err := e.evaluateCondition(task.If, env)
 		if err != nil {
-			return fmt.Errorf("condition evaluation failed: %w", err)
+			return e.failed(name, run, fmt.Errorf("condition evaluation failed: %w", err))
 		}
 		if !shouldRun {
 			e.logger.Info(fmt.Sprintf("Skipping task %s (condition not  met)", task.Name))
-			if e.collector != nil {
-				e.collector.AddSkipped(task.Name)
-			}
+			run.log.Log("info", "Skipped: condition not met")
+			e.addResult(task.Name, run, "skipped", nil)
 			return nil
 		}
 	}
 
 	if len(task.Pre) > 0 {
 		if err := e.checkPreconditions(task.Pre); err != nil {
-			return err
+			return e.failed(name, run, err)
 		}
 	}
 
@@ -150,42 +447,65 @@ func (e *Executor) executeTask(task *ast.Task, useCache bool) error {
 		var response string
 		_, _ = fmt.Scanln(&response)
 		if strings.ToLower(response) != "y" {
-			return fmt.Errorf("task aborted by user")
+			return e.failed(name, run, fmt.Errorf("task aborted by user"))
 		}
 	}
 
 	cached, inputHash := e.checkEnhancedCache(task, useCache)
+	run.inputHash = inputHash
 	if cached {
 		e.logger.TaskCached(task.Name)
-		if e.collector != nil {
-			e.collector.Add(task.Name, 0, true, true, nil)
-		}
+		run.log.SetCacheHit(true)
+		e.addResult(task.Name, run, "cached", nil)
 		return nil
 	}
 
+	// The watched files are hashed before running so that changes made
+	// while the task runs trigger another run. A task whose dependencies
+	// ran is never skipped.
+	var watchHash string
 	if !cached && useCache && len(task.Watch) > 0 {
 		hash, err := cache.HashFiles(task.Watch)
 		if err == nil {
-			if entry, ok := e.cache.Get(task.Name, hash); ok && entry.Success {
+			watchHash = hash
+			if len(task.Inputs) == 0 {
+				run.inputHash = hash
+			}
+			if entry, ok := e.cache.Get(task.Name, hash); ok && entry.Success && !e.depsRan(task) {
 				e.logger.TaskCached(task.Name)
-				if e.collector != nil {
-					e.collector.Add(task.Name, 0, true, true, nil)
-				}
+				run.log.SetCacheHit(true)
+				e.addResult(task.Name, run, "cached", nil)
 				return nil
 			}
 		}
 	}
 
+	// The report records what the task ran on even when caching is off,
+	// so that history can tell flaky tasks from changed inputs.
+	if run.inputHash == "" && e.collector != nil {
+		patterns := task.Inputs
+		if len(patterns) == 0 {
+			patterns = task.Watch
+		}
+		if len(patterns) > 0 {
+			run.inputHash, _ = cache.HashFiles(patterns)
+		}
+	}
+
 	success := true
 	var execErr error
 
 	if task.Timeout != "" || task.Retries > 0 {
-		execErr = e.executeWithTimeout(task, taskVars)
+		execErr = e.executeWithTimeout(task, taskVars, env, run)
 		success = (execErr == nil)
 	} else {
-		expandedRun := vars.ExpandSlice(task.Run, taskVars)
+		expandedRun, err := vars.ExpandCommands(task.Run, taskVars, e.strict)
+		if err != nil {
+			success = false
+			execErr = err
+		}
 		for _, cmd := range expandedRun {
-			if err := e.runCommand(cmd, taskVars); err != nil {
+			if err := e.runCommand(task, cmd, env, run); err != nil {
 				success = false
 				execErr = err
 				break
@@ -205,11 +525,10 @@ func (e *Executor) executeTask(task *ast.Task, useCache bool) error {
 				Timestamp: time.Now(),
 			}
 			_ = e.cache.Set(entry)
-		} else if len(task.Watch) > 0 {
-			hash, _ := cache.HashFiles(task.Watch)
+		} else if watchHash != "" {
 			entry := &cache.CacheEntry{
 				TaskName:  task.Name,
-				InputHash: hash,
+				InputHash: watchHash,
 				Success:   success,
 				Duration:  duration,
 				Timestamp: time.Now(),
@@ -218,35 +537,56 @@ func (e *Executor) executeTask(task *ast.Task, useCache bool) error {
 		}
 	}
 
-	if e.collector != nil {
-		e.collector.Add(task.Name, duration, success, false, execErr)
+	if success {
+		e.ranMu.Lock()
+		e.ran[task.Name] = true
+		e.ranMu.Unlock()
 	}
 
+	if success {
+		e.addResult(task.Name, run, "passed", nil)
+	} else {
+		e.addResult(task.Name, run, "failed", execErr)
+	}
+
+	if success && !e.dryRun {
+		for _, entry := range snapshots {
+			_ = e.cache.Set(entry)
+		}
+	}
+
+	e.flush(run)
 	if success {
 		e.logger.TaskComplete(task.Name, duration)
 		if task.Notify.Success != "" {
 			e.sendNotification("Flux Task Success", task.Notify.Success)
 		}
-		if e.logStore != nil {
-			e.logStore.Log("info", fmt.Sprintf("Task completed in %v", duration))
-			e.logStore.EndTask(task.Name, true)
-			_ = e.logStore.Save()
-		}
+		run.log.Log("info", fmt.Sprintf("Task completed in %v", duration))
+	} else if errors.Is(execErr, ErrStopped) {
+		e.logger.Info(fmt.Sprintf("Stopped task %s", task.Name))
+		run.log.Log("info", "Task stopped")
 	} else {
 		e.logger.TaskFailed(task.Name, execErr)
 		if task.Notify.Failure != "" {
 			e.sendNotification("Flux Task Failure", task.Notify.Failure)
 		}
-		if e.logStore != nil {
-			e.logStore.Log("error", fmt.Sprintf("Task failed: %v", execErr))
-			e.logStore.EndTask(task.Name, false)
-			_ = e.logStore.Save()
-		}
+		run.log.Log("error", fmt.Sprintf("Task failed: %v", execErr))
 	}
 
 	return execErr
 }
 
+func (e *Executor) depsRan(task *ast.Task) bool {
+	e.ranMu.Lock()
+	defer e.ranMu.Unlock()
+	for _, dep := range task.Deps {
+		if e.ran[dep] {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *Executor) sendNotification(title, message string) {
 	if e.dryRun {
 		e.logger.Info(fmt.Sprintf("[DryRun] Notification: %s - %s", title, message))
@@ -266,66 +606,219 @@ func (e *Executor) sendNotification(title, message string) {
 	_ = cmd.Start()
 }
 
-func (e *Executor) runCommand(command string, env map[string]string) error {
+func (e *Executor) runCommand(task *ast.Task, command string, env map[string]string, run *taskRun) error {
 	if e.dryRun {
-		e.logger.Info(fmt.Sprintf("[DryRun] %s", command))
+		run.out.Info(fmt.Sprintf("[DryRun] %s", command))
 		return nil
 	}
 
-	e.logger.Command(command)
+	run.out.Command(command)
 
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("powershell", "-Command", command)
-	} else {
-		cmd = exec.Command("sh", "-c", command)
-	}
+	cmd := shellCommand(task.Shell, command)
 	cmd.Env = os.Environ()
+	if task.Restart {
+		setProcessGroup(cmd)
+	}
 
 	for k, v := range env {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
+	// Tools that trace themselves attach their spans to the command's.
+	span := e.tracer.Start(commandSpanName(e.masker.Mask(command)), run.span)
+	span.SetAttribute("flux.task.name", task.Name)
+	span.SetAttribute("flux.command", e.masker.Mask(command))
+	span.SetAttribute("flux.profile", e.Profiles())
+	if traceparent := span.Traceparent(); traceparent != "" {
+		cmd.Env = append(cmd.Env, "TRACEPARENT="+traceparent)
+	}
+
+	// Output is written line by line in the output mode and captured for
+	// the log store.
+	// Wait returns once it has all been read, or shortly after the command
+	// exits if a background process keeps the output open.
+	stdout := &lineWriter{emit: run.out.Stdout}
+	stderr := &lineWriter{emit: run.out.Stderr}
+	output := &outputCapture{limit: e.outputLimit}
+	stdouts := []io.Writer{stdout, output}
+	stderrs := []io.Writer{stderr, output}
+	if run.stdout != nil {
+		stdouts = append(stdouts, run.stdout)
+		stderrs = append(stderrs, run.stderr)
+	}
+	cmd.Stdout = io.MultiWriter(stdouts...)
+	cmd.Stderr = io.MultiWriter(stderrs...)
+	cmd.WaitDelay = time.Second
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
+	start := time.Now()
+	e.procMu.Lock()
+	if e.stopping {
+		e.procMu.Unlock()
+		span.End(ErrStopped)
+		return ErrStopped
 	}
-
 	if err := cmd.Start(); err != nil {
+		e.procMu.Unlock()
+		run.log.LogCommandWithOutput(command, time.Since(start), -1, err.Error())
+		span.SetAttribute("process.exit_code", -1)
+		span.End(err)
 		return err
 	}
+	e.procs[cmd] = true
+	e.procMu.Unlock()
 
-	go streamOutput(stdout, e.logger.Stdout)
-	go streamOutput(stderr, e.logger.Stderr)
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	stdout.Flush()
+	stderr.Flush()
+
+	e.procMu.Lock()
+	delete(e.procs, cmd)
+	stopped := e.stopping
+	e.procMu.Unlock()
+
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	run.log.LogCommandWithOutput(command, time.Since(start), exitCode, output.String())
+	span.SetAttribute("process.exit_code", exitCode)
+	if stopped {
+		span.End(ErrStopped)
+	} else {
+		span.End(err)
+	}
 
-	if err := cmd.Wait(); err != nil {
+	if stopped {
+		return ErrStopped
+	}
+	if err != nil {
 		return fmt.Errorf("command failed: %w", err)
 	}
 
 	return nil
 }
 
-func streamOutput(r io.Reader, writer func(string)) {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		writer(scanner.Text())
+// commandSpanName names the span of a command after its first line, cut
+// short to keep trace views readable.
+func commandSpanName(command string) string {
+	name, _, _ := strings.Cut(command, "\n")
+	if r := []rune(name); len(r) > 64 {
+		name = string(r[:61]) + "..."
 	}
+	return name
 }
 
-func (e *Executor) applyProfile(profileName string) {
-	for _, profile := range e.fluxFile.Profiles {
-		if profile.Name == profileName {
-			e.vars = vars.MergeVars(e.vars, profile.Env)
-			e.logger.Info(fmt.Sprintf("Applied profile: %s", profileName))
+// Stop ends the commands that are running, along with the processes they
+// started for tasks with restart: true. They are sent SIGTERM and, if
+// still running after grace, SIGKILL. No further commands start until the
+// next Execute.
+func (e *Executor) Stop(grace time.Duration) {
+	e.procMu.Lock()
+	e.stopping = true
+	var procs []*exec.Cmd
+	for cmd := range e.procs {
+		procs = append(procs, cmd)
+	}
+	e.procMu.Unlock()
+
+	for _, cmd := range procs {
+		_ = terminate(cmd)
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		e.procMu.Lock()
+		remaining := len(e.procs)
+		e.procMu.Unlock()
+		if remaining == 0 {
 			return
 		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	for cmd := range e.procs {
+		_ = kill(cmd)
+	}
+}
+
+// shellCommand builds the command that runs a script with the given
+// interpreter. An empty shell selects the platform default.
+func shellCommand(shell, command string) *exec.Cmd {
+	switch shell {
+	case "":
+		if runtime.GOOS == "windows" {
+			return exec.Command("powershell", "-Command", command)
+		}
+		return exec.Command("sh", "-c", command)
+	case "powershell", "pwsh":
+		return exec.Command(shell, "-Command", command)
+	case "cmd":
+		return exec.Command("cmd", "/C", command)
+	case "node":
+		return exec.Command("node", "-e", command)
+	default:
+		return exec.Command(shell, "-c", command)
+	}
+}
+
+// prepareTask applies the active profiles and the task's own profile to
+// task and returns it with the vars it runs with. The task profile is scoped
+// to this task only.
+//
+// Vars are layered from lowest to highest precedence: .fluxconfig env,
+// FluxFile dotenv files, FluxFile vars, then for each profile its dotenv
+// files and its vars and env, then the task's dotenv files and env, and
+// finally command-line overrides.
+func (e *Executor) prepareTask(task *ast.Task) (*ast.Task, map[string]string, error) {
+	task, profiles, err := e.profileTask(task, e.profiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	taskVars := vars.MergeVars(vars.MergeVars(e.configEnv, e.dotenv), e.vars)
+	for _, profile := range profiles {
+		dotenv, err := vars.LoadDotenv(profile.Dotenv, taskVars)
+		if err != nil {
+			return nil, nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+		}
+		taskVars = vars.MergeVars(taskVars, dotenv)
+		taskVars = vars.MergeVars(taskVars, config.ProfileVars([]*ast.Profile{profile}))
+	}
+
+	dotenv, err := vars.LoadDotenv(task.Dotenv, taskVars)
+	if err != nil {
+		return nil, nil, fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	taskVars = vars.MergeVars(taskVars, dotenv)
+	taskVars = vars.MergeVars(taskVars, task.Env)
+	return task, vars.MergeVars(taskVars, e.overrides), nil
+}
+
+// profileTask returns task with the overrides of profiles and of its own
+// profile_task applied, and the profiles that apply to it.
+func (e *Executor) profileTask(task *ast.Task, profiles []*ast.Profile) (*ast.Task, []*ast.Profile, error) {
+	if task.Profile != "" {
+		taskProfiles, err := config.ResolveProfiles(e.fluxFile, []string{task.Profile})
+		if err != nil {
+			return nil, nil, fmt.Errorf("task %s: %w", task.Name, err)
+		}
+		profiles = append(append([]*ast.Profile{}, profiles...), taskProfiles...)
 	}
-	e.logger.Warn(fmt.Sprintf("Profile not found: %s", profileName))
+
+	if len(profiles) > 0 {
+		overridden := config.ProfileTask(*task, profiles)
+		task = &overridden
+	}
+	return task, profiles, nil
 }
 
 func (e *Executor) ListTasks() []string {
@@ -341,6 +834,10 @@ func (e *Executor) GetTaskInfo(taskName string) (*ast.Task, error) {
 }
 
 func (e *Executor) ExecuteAll() error {
+	if err := e.begin(""); err != nil {
+		return err
+	}
+
 	order, err := e.graph.TopologicalSort()
 	if err != nil {
 		return err
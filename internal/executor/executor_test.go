@@ -1,11 +1,27 @@
 package executor
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/expr"
 	"github.com/ashavijit/fluxfile/internal/graph"
+	"github.com/ashavijit/fluxfile/internal/history"
+	"github.com/ashavijit/fluxfile/internal/logger"
+	"github.com/ashavijit/fluxfile/internal/logs"
+	"github.com/ashavijit/fluxfile/internal/report"
+	"github.com/ashavijit/fluxfile/internal/telemetry"
+	"github.com/ashavijit/fluxfile/internal/vars"
 )
 
 func TestNew(t *testing.T) {
@@ -113,17 +129,38 @@ func TestEvaluateCondition(t *testing.T) {
 			wantErr:   false,
 		},
 		{
-			name:      "no operator",
+			name:      "undefined variable is false",
 			condition: "INVALID",
 			vars:      map[string]string{},
 			expected:  false,
+			wantErr:   false,
+		},
+		{
+			name:      "compound condition",
+			condition: "MODE == prod && REGION != eu",
+			vars:      map[string]string{"MODE": "prod", "REGION": "us"},
+			expected:  true,
+			wantErr:   false,
+		},
+		{
+			name:      "float comparison",
+			condition: "RATIO > 0.5",
+			vars:      map[string]string{"RATIO": "0.75"},
+			expected:  true,
+			wantErr:   false,
+		},
+		{
+			name:      "syntax error",
+			condition: "MODE ==",
+			vars:      map[string]string{},
+			expected:  false,
 			wantErr:   true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := exec.evaluateCondition(tt.condition, tt.vars)
+			result, err := exec.evaluateCondition(tt.condition, &expr.Env{Vars: tt.vars})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("evaluateCondition() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -353,6 +390,7 @@ func TestMatrixExpansion(t *testing.T) {
 
 func TestApplyProfile(t *testing.T) {
 	fluxFile := ast.NewFluxFile()
+	fluxFile.Vars["MODE"] = "production"
 	fluxFile.Profiles = []ast.Profile{
 		{
 			Name: "dev",
@@ -362,20 +400,70 @@ func TestApplyProfile(t *testing.T) {
 			},
 		},
 	}
+	fluxFile.Tasks = []ast.Task{
+		{Name: "serve", Profile: "dev", Run: []string{"echo serve"}},
+		{Name: "build", Run: []string{"echo build"}},
+	}
 
 	exec, err := New(fluxFile, t.TempDir(), false)
 	if err != nil {
 		t.Fatalf("Failed to create executor: %v", err)
 	}
 
-	exec.applyProfile("dev")
+	serve, _ := exec.graph.GetTask("serve")
+	_, taskVars, err := exec.prepareTask(serve)
+	if err != nil {
+		t.Fatalf("prepareTask failed: %v", err)
+	}
+	if taskVars["MODE"] != "development" || taskVars["DEBUG"] != "true" {
+		t.Errorf("Expected dev profile vars, got %v", taskVars)
+	}
 
-	if exec.vars["MODE"] != "development" {
-		t.Errorf("Expected MODE=development, got %s", exec.vars["MODE"])
+	build, _ := exec.graph.GetTask("build")
+	_, taskVars, _ = exec.prepareTask(build)
+	if taskVars["MODE"] != "production" {
+		t.Errorf("Task profile leaked into another task: MODE=%s", taskVars["MODE"])
+	}
+	if exec.vars["MODE"] != "production" {
+		t.Errorf("Profile should not change executor vars, got MODE=%s", exec.vars["MODE"])
 	}
+}
 
-	if exec.vars["DEBUG"] != "true" {
-		t.Errorf("Expected DEBUG=true, got %s", exec.vars["DEBUG"])
+func TestStackedProfiles(t *testing.T) {
+	fluxFile := ast.NewFluxFile()
+	fluxFile.Profiles = []ast.Profile{
+		{Name: "base", Vars: map[string]string{"REGION": "us", "REPLICAS": "1"}},
+		{Name: "prod", Extends: "base", Vars: map[string]string{"REPLICAS": "3"}},
+		{Name: "eu", Vars: map[string]string{"REGION": "eu"}, Tasks: []ast.Task{
+			{Name: "deploy", Remote: "eu.example.com", Declared: map[string]bool{"remote": true}},
+		}},
+	}
+	fluxFile.Tasks = []ast.Task{
+		{Name: "deploy", Remote: "us.example.com", Run: []string{"echo deploy"}},
+	}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.profiles, err = config.ResolveProfiles(fluxFile, config.SplitProfiles("prod,eu"))
+	if err != nil {
+		t.Fatalf("ResolveProfiles failed: %v", err)
+	}
+
+	deploy, _ := exec.graph.GetTask("deploy")
+	task, taskVars, err := exec.prepareTask(deploy)
+	if err != nil {
+		t.Fatalf("prepareTask failed: %v", err)
+	}
+	if taskVars["REGION"] != "eu" || taskVars["REPLICAS"] != "3" {
+		t.Errorf("Unexpected stacked vars %v", taskVars)
+	}
+	if task.Remote != "eu.example.com" {
+		t.Errorf("Expected profile remote override, got %s", task.Remote)
+	}
+	if deploy.Remote != "us.example.com" {
+		t.Errorf("Override should not modify the task, got %s", deploy.Remote)
 	}
 }
 
@@ -413,3 +501,739 @@ func TestGraphIntegration(t *testing.T) {
 		t.Errorf("Expected 4 tasks in order, got %d", len(order))
 	}
 }
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		shell    string
+		expected []string
+	}{
+		{"bash", []string{"bash", "-c", "echo hi"}},
+		{"python3", []string{"python3", "-c", "echo hi"}},
+		{"pwsh", []string{"pwsh", "-Command", "echo hi"}},
+		{"node", []string{"node", "-e", "echo hi"}},
+	}
+
+	for _, tt := range tests {
+		cmd := shellCommand(tt.shell, "echo hi")
+		if strings.Join(cmd.Args, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("shellCommand(%q) = %v, expected %v", tt.shell, cmd.Args, tt.expected)
+		}
+	}
+}
+
+func TestOverridesTakePrecedence(t *testing.T) {
+	fluxFile := ast.NewFluxFile()
+	fluxFile.Vars["MODE"] = "dev"
+	fluxFile.Vars["REGION"] = "us"
+	fluxFile.Profiles = []ast.Profile{
+		{Name: "prod", Env: map[string]string{"MODE": "prod", "REGION": "eu"}},
+	}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	exec.SetOverrides(map[string]string{"MODE": "cli"})
+	exec.profiles, _ = config.ResolveProfiles(fluxFile, []string{"prod"})
+	_, taskVars, err := exec.prepareTask(&ast.Task{Name: "deploy"})
+	if err != nil {
+		t.Fatalf("prepareTask failed: %v", err)
+	}
+
+	if taskVars["MODE"] != "cli" {
+		t.Errorf("Expected override MODE=cli, got %s", taskVars["MODE"])
+	}
+	if taskVars["REGION"] != "eu" {
+		t.Errorf("Expected profile REGION=eu, got %s", taskVars["REGION"])
+	}
+}
+
+func TestDotenvPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		return path
+	}
+
+	fluxFile := ast.NewFluxFile()
+	fluxFile.Vars["MODE"] = "prod"
+	fluxFile.Vars["FROM_VAR"] = "var"
+	fluxFile.Dotenv = []string{write(".env", "FROM_VAR=dotenv\nBASE=file\nLEVEL=file\n"), filepath.Join(dir, ".env.${MODE}")}
+	write(".env.prod", "LEVEL=mode\n")
+	fluxFile.Profiles = []ast.Profile{
+		{Name: "ci", Dotenv: []string{write(".env.ci", "LEVEL=profile\nPROFILE_ONLY=yes\n")}},
+	}
+	fluxFile.Tasks = []ast.Task{
+		{Name: "build", Dotenv: []string{write(".env.task", "LEVEL=task\nTASK_ENV=dotenv\n")}, Env: map[string]string{"TASK_ENV": "env"}},
+	}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.dotenv, _ = vars.LoadDotenv(fluxFile.Dotenv, exec.vars)
+	exec.profiles, _ = config.ResolveProfiles(fluxFile, []string{"ci"})
+
+	build, _ := exec.graph.GetTask("build")
+	_, taskVars, err := exec.prepareTask(build)
+	if err != nil {
+		t.Fatalf("prepareTask failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"FROM_VAR":     "var",
+		"BASE":         "file",
+		"LEVEL":        "task",
+		"PROFILE_ONLY": "yes",
+		"TASK_ENV":     "env",
+	}
+	for k, v := range expected {
+		if taskVars[k] != v {
+			t.Errorf("Expected %s=%s, got %s", k, v, taskVars[k])
+		}
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	t.Setenv("FLUX_TEST_TOKEN", "t0k${HOME}en")
+
+	fluxFile := ast.NewFluxFile()
+	fluxFile.Tasks = []ast.Task{{Name: "deploy", Run: []string{"echo ${TOKEN}"}}}
+
+	exec, err := New(fluxFile, t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	taskVars := map[string]string{}
+	secrets := []ast.Secret{{Name: "TOKEN", Source: "env:FLUX_TEST_TOKEN"}}
+	if err := exec.loadSecrets(secrets, taskVars); err != nil {
+		t.Fatalf("loadSecrets failed: %v", err)
+	}
+
+	commands, err := vars.ExpandCommands(fluxFile.Tasks[0].Run, taskVars, true)
+	if err != nil || commands[0] != "echo t0k${HOME}en" {
+		t.Errorf("Expected the secret to be used verbatim, got %v, %v", commands, err)
+	}
+	if masked := exec.Masker().Mask(commands[0]); masked != "echo ***" {
+		t.Errorf("Expected the secret to be masked, got %q", masked)
+	}
+
+	if err := exec.loadSecrets([]ast.Secret{{Name: "FLUX_TEST_MISSING"}}, taskVars); err == nil {
+		t.Error("Expected an error for a missing secret")
+	}
+}
+
+func TestStop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sleep")
+	}
+	logs.SetLogDir(t.TempDir())
+
+	fluxFile := ast.NewFluxFile()
+	task := ast.NewTask("serve")
+	task.Run = []string{"sleep 10"}
+	task.Restart = true
+	fluxFile.Tasks = []ast.Task{task}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- exec.Execute("serve", "", false) }()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	exec.Stop(time.Second)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrStopped) {
+			t.Errorf("Expected ErrStopped, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Execute to return after Stop")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Stop took %v", elapsed)
+	}
+}
+
+func TestWatchIndex(t *testing.T) {
+	fluxFile := ast.NewFluxFile()
+	fluxFile.Tasks = []ast.Task{
+		{Name: "gen", Inputs: []string{"schema/*.json"}},
+		{Name: "build", Deps: []string{"gen"}, Inputs: []string{"src/**/*.go"}, WatchIgnore: []string{"src/gen/**", "vendor/**"}},
+		{Name: "lint", Watch: []string{"src/**/*.go"}, WatchIgnore: []string{"vendor/**"}},
+		{Name: "test", Deps: []string{"build"}, Watch: []string{"test/*.txt"}, WatchIgnore: []string{"vendor/**"}},
+		{Name: "docs", Watch: []string{"docs/*.md"}},
+	}
+
+	exec, err := New(fluxFile, t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	index, err := exec.WatchIndex([]string{"test", "lint"}, "")
+	if err != nil {
+		t.Fatalf("WatchIndex failed: %v", err)
+	}
+	if got := strings.Join(index.Tasks(), " "); got != "gen build test lint" {
+		t.Errorf("Unexpected tasks %s", got)
+	}
+	if got := strings.Join(index.Patterns(), " "); got != "schema/*.json src/**/*.go test/*.txt" {
+		t.Errorf("Unexpected patterns %s", got)
+	}
+	if got := strings.Join(index.Ignore(), " "); got != "" {
+		t.Errorf("Expected no shared ignore patterns since gen has none, got %s", got)
+	}
+
+	dir, _ := os.Getwd()
+	tests := map[string]string{
+		"schema/a.json":  "gen build test",
+		"src/pkg/a.go":   "build test lint",
+		"src/gen/x.go":   "lint",
+		"test/a.txt":     "test",
+		"docs/readme.md": "",
+	}
+	for file, want := range tests {
+		got := strings.Join(index.Affected([]string{filepath.Join(dir, file)}), " ")
+		if got != want {
+			t.Errorf("Affected(%s) = %q, expected %q", file, got, want)
+		}
+	}
+
+	lint, _ := exec.WatchIndex([]string{"lint"}, "")
+	if got := strings.Join(lint.Ignore(), " "); got != "vendor/**" {
+		t.Errorf("Expected vendor/** to be shared, got %s", got)
+	}
+
+	if _, err := exec.WatchIndex([]string{"missing"}, ""); err == nil {
+		t.Error("Expected an error for an undefined task")
+	}
+}
+
+func TestProfileOverridesSchedule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sleep")
+	}
+	logs.SetLogDir(t.TempDir())
+
+	fluxFile := ast.NewFluxFile()
+	all := ast.NewTask("all")
+	for _, name := range []string{"a", "b"} {
+		task := ast.NewTask(name)
+		task.Run = []string{"sleep 0.1"}
+		task.Inputs = []string{name + "/*.go"}
+		fluxFile.Tasks = append(fluxFile.Tasks, task)
+		all.Deps = append(all.Deps, name)
+	}
+	fluxFile.Tasks = append(fluxFile.Tasks, all)
+
+	ci := ast.NewProfile("ci")
+	parallel := ast.NewTask("all")
+	parallel.Parallel = true
+	parallel.Declared["parallel"] = true
+	inputs := ast.NewTask("a")
+	inputs.Inputs = []string{"gen/*.go"}
+	inputs.Watch = []string{"gen/*.json"}
+	inputs.Declared["inputs"] = true
+	inputs.Declared["watch"] = true
+	ci.Tasks = []ast.Task{parallel, inputs}
+	fluxFile.Profiles = []ast.Profile{ci}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	workers := func(profile string) map[int]bool {
+		collector := report.NewCollector()
+		exec.SetCollector(collector)
+		if err := exec.Execute("all", profile, false); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		used := make(map[int]bool)
+		for _, result := range collector.Generate().Tasks {
+			if result.Name != "all" {
+				used[result.Worker] = true
+			}
+		}
+		return used
+	}
+	if used := workers(""); len(used) != 1 {
+		t.Errorf("Expected the deps to run one after another without the profile, got workers %v", used)
+	}
+	if used := workers("ci"); len(used) != 2 {
+		t.Errorf("Expected the ci profile to run the deps in parallel, got workers %v", used)
+	}
+
+	index, err := exec.WatchIndex([]string{"all"}, "ci")
+	if err != nil {
+		t.Fatalf("WatchIndex failed: %v", err)
+	}
+	if got := strings.Join(index.Patterns(), " "); got != "gen/*.json gen/*.go b/*.go" {
+		t.Errorf("Expected the watch and inputs of the ci profile, got %s", got)
+	}
+	dir, _ := os.Getwd()
+	if got := strings.Join(index.Affected([]string{filepath.Join(dir, "gen/x.go")}), " "); got != "a all" {
+		t.Errorf("Expected a change to gen/x.go to affect a and all, got %q", got)
+	}
+}
+
+func TestFailedPreconditionReported(t *testing.T) {
+	logs.SetLogDir(t.TempDir())
+
+	fluxFile := ast.NewFluxFile()
+	task := ast.NewTask("deploy")
+	task.Pre = []ast.Precondition{{Type: "file", Value: "missing.txt"}}
+	task.Run = []string{"echo deploy"}
+	fluxFile.Tasks = append(fluxFile.Tasks, task)
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	collector := report.NewCollector()
+	exec.SetCollector(collector)
+	runErr := exec.Execute("deploy", "", false)
+	if runErr == nil {
+		t.Fatal("Expected the failed precondition to fail the run")
+	}
+
+	rep := collector.Generate()
+	if len(rep.Tasks) != 1 || rep.Tasks[0].Status != "failed" || rep.Failed != 1 {
+		t.Fatalf("Expected one failed result for deploy, got %+v", rep.Tasks)
+	}
+	if !strings.Contains(runErr.Error(), rep.Tasks[0].Error) || rep.Tasks[0].Error == "" {
+		t.Errorf("Expected the result to carry the precondition error, got %q", rep.Tasks[0].Error)
+	}
+
+	var junit bytes.Buffer
+	if err := (report.JUnitFormatter{}).Format(&junit, rep); err != nil {
+		t.Fatalf("JUnit Format() error = %v", err)
+	}
+	out := junit.String()
+	for _, want := range []string{`tests="1" failures="1"`, `<testcase name="deploy"`, "<failure message=", "missing.txt"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected the JUnit report to contain %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestFailedPreconditionInHistory(t *testing.T) {
+	logs.SetLogDir(t.TempDir())
+
+	fluxFile := ast.NewFluxFile()
+	build := ast.NewTask("build")
+	build.Run = []string{"echo build"}
+	deploy := ast.NewTask("deploy")
+	deploy.Deps = []string{"build"}
+	deploy.Pre = []ast.Precondition{{Type: "command", Value: "no-such-command-flux"}}
+	deploy.Run = []string{"echo deploy"}
+	fluxFile.Tasks = append(fluxFile.Tasks, build, deploy)
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		collector := report.NewCollector()
+		exec.SetCollector(collector)
+		if err := exec.Execute("deploy", "", false); err == nil {
+			t.Fatal("Expected the failed precondition to fail the run")
+		}
+		run := history.FromReport(fmt.Sprintf("run-%d", i), "deploy", collector.Generate())
+		if err := history.Append(dir, run, 0); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	runs, err := history.Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	trend := history.TaskTrend(runs, "deploy", 0)
+	if trend == nil {
+		t.Fatal("Expected deploy to be recorded in the history")
+	}
+	if trend.Runs != 2 || trend.Failures != 2 {
+		t.Errorf("Expected 2 failed runs of deploy, got %d runs and %d failures", trend.Runs, trend.Failures)
+	}
+	if build := history.TaskTrend(runs, "build", 0); build == nil || build.Failures != 0 {
+		t.Errorf("Expected build to be recorded as passed, got %+v", build)
+	}
+}
+
+func TestOutputCapture(t *testing.T) {
+	c := &outputCapture{limit: 32}
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(c, "line %d\n", i)
+	}
+
+	got := c.String()
+	if !strings.HasPrefix(got, "line 0\nline 1\n") || !strings.HasSuffix(got, "\nline 8\nline 9") {
+		t.Errorf("Expected the head and tail to be kept, got %q", got)
+	}
+	if !strings.Contains(got, "bytes truncated") {
+		t.Errorf("Expected a truncation marker, got %q", got)
+	}
+
+	all := &outputCapture{}
+	fmt.Fprint(all, "a\nb\n")
+	if all.String() != "a\nb" {
+		t.Errorf("Expected all output without a limit, got %q", all.String())
+	}
+}
+
+func TestCommandOutputLogged(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	logs.SetLogDir(dir)
+
+	fluxFile := ast.NewFluxFile()
+	task := ast.NewTask("fail")
+	task.Run = []string{"echo working", "sh -c 'echo broken >&2; exit 3'"}
+	fluxFile.Tasks = []ast.Task{task}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	if err := exec.Execute("fail", "", false); err == nil {
+		t.Fatal("Expected the task to fail")
+	}
+
+	taskLogs, err := logs.LoadLogs(dir)
+	if err != nil || len(taskLogs) != 1 {
+		t.Fatalf("Expected one task log, got %d, %v", len(taskLogs), err)
+	}
+
+	var commands []logs.LogEntry
+	for _, entry := range taskLogs[0].Entries {
+		if entry.Command != "" {
+			commands = append(commands, entry)
+		}
+	}
+	if len(commands) != 2 {
+		t.Fatalf("Expected two commands, got %+v", commands)
+	}
+	if commands[0].Output != "working" || commands[0].ExitCode != 0 {
+		t.Errorf("Unexpected first command %+v", commands[0])
+	}
+	if commands[1].Output != "broken" || commands[1].ExitCode != 3 {
+		t.Errorf("Unexpected second command %+v", commands[1])
+	}
+	if !strings.Contains(taskLogs[0].Error, "exit status 3") {
+		t.Errorf("Expected the task error to be logged, got %q", taskLogs[0].Error)
+	}
+}
+
+func TestParallelTaskLogs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sleep")
+	}
+	dir := t.TempDir()
+	logs.SetLogDir(dir)
+
+	fluxFile := ast.NewFluxFile()
+	all := ast.NewTask("all")
+	all.Parallel = true
+	for _, name := range []string{"a", "b", "c"} {
+		task := ast.NewTask(name)
+		task.Run = []string{"echo " + name, "sleep 0.1", "echo " + name}
+		fluxFile.Tasks = append(fluxFile.Tasks, task)
+		all.Deps = append(all.Deps, name)
+	}
+	fluxFile.Tasks = append(fluxFile.Tasks, all)
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	if err := exec.Execute("all", "", false); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	taskLogs, err := logs.LoadLogs(dir)
+	if err != nil || len(taskLogs) != 4 {
+		t.Fatalf("Expected four task logs, got %d, %v", len(taskLogs), err)
+	}
+	for _, log := range taskLogs {
+		if log.RunID != taskLogs[0].RunID || log.RunID == "" {
+			t.Errorf("Expected all tasks in one run, got %q and %q", log.RunID, taskLogs[0].RunID)
+		}
+		if log.Status != "success" {
+			t.Errorf("Expected %s to succeed, got %s", log.TaskName, log.Status)
+		}
+		for _, entry := range log.Entries {
+			if entry.Task != log.TaskName || (entry.Output != "" && entry.Output != log.TaskName) {
+				t.Errorf("Unexpected entry %+v in the log of %s", entry, log.TaskName)
+			}
+		}
+	}
+
+	if err := exec.Execute("a", "", false); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	runs, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(runs) != 2 {
+		t.Errorf("Expected a run directory per Execute, got %v", runs)
+	}
+}
+
+func TestTaskSchedule(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sleep")
+	}
+	logs.SetLogDir(t.TempDir())
+
+	fluxFile := ast.NewFluxFile()
+	all := ast.NewTask("all")
+	all.Parallel = true
+	for _, name := range []string{"a", "b", "c"} {
+		task := ast.NewTask(name)
+		task.Run = []string{"sleep 0.1"}
+		fluxFile.Tasks = append(fluxFile.Tasks, task)
+		all.Deps = append(all.Deps, name)
+	}
+	fluxFile.Tasks = append(fluxFile.Tasks, all)
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	collector := report.NewCollector()
+	exec.SetCollector(collector)
+	if err := exec.Execute("all", "", false); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	results := make(map[string]report.TaskResult)
+	for _, result := range collector.Generate().Tasks {
+		results[result.Name] = result
+	}
+	workers := make(map[int]bool)
+	for _, name := range []string{"a", "b", "c"} {
+		result := results[name]
+		if result.Worker < 1 || result.Worker > 3 || workers[result.Worker] {
+			t.Errorf("Expected parallel tasks on workers 1-3 of their own, got %d for %s", result.Worker, name)
+		}
+		workers[result.Worker] = true
+		if result.WaitTime != 0 {
+			t.Errorf("Expected %s not to wait, got %v", name, result.WaitTime)
+		}
+		if results["all"].StartTime.Before(result.EndTime) {
+			t.Errorf("Expected all to start after %s ended", name)
+		}
+	}
+	if result := results["all"]; result.Worker != 1 || result.WaitTime < 100*time.Millisecond {
+		t.Errorf("Expected all to run on worker 1 after waiting for its deps, got %+v", result)
+	}
+
+	taskLogs, _ := logs.LoadLogs(logs.GetLogDir())
+	for _, log := range taskLogs {
+		if log.Worker != results[log.TaskName].Worker || log.WaitMs != results[log.TaskName].WaitTime.Milliseconds() {
+			t.Errorf("Expected the log of %s to record its schedule, got worker %d, wait %dms", log.TaskName, log.Worker, log.WaitMs)
+		}
+	}
+}
+
+func TestTaskPrefix(t *testing.T) {
+	color := func(prefix string) string {
+		return strings.TrimPrefix(prefix[:strings.Index(prefix, "m")], "\033[")
+	}
+	for _, name := range []string{"lint", "test", "build:docker"} {
+		prefix := taskPrefix(name)
+		if !strings.Contains(prefix, "["+name+"]") {
+			t.Errorf("Expected the prefix of %s to label it, got %q", name, prefix)
+		}
+		found := false
+		for _, c := range prefixColors {
+			found = found || color(prefix) == c
+		}
+		if !found {
+			t.Errorf("Expected the prefix of %s to use a task color, got %q", name, prefix)
+		}
+		for i := 0; i < 3; i++ {
+			if again := taskPrefix(name); again != prefix {
+				t.Errorf("Expected the prefix of %s to be stable, got %q and %q", name, prefix, again)
+			}
+		}
+	}
+	if taskPrefix("lint") == taskPrefix("test") {
+		t.Error("Expected tasks to get prefixes of their own")
+	}
+}
+
+// syncBuffer is a bytes.Buffer that tasks running in parallel can share.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPrefixedParallelOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	logs.SetLogDir(t.TempDir())
+
+	fluxFile := ast.NewFluxFile()
+	all := ast.NewTask("all")
+	all.Parallel = true
+	names := []string{"lint", "test", "vet"}
+	for _, name := range names {
+		task := ast.NewTask(name)
+		task.Run = []string{fmt.Sprintf("for i in 1 2 3; do echo %s-$i; sleep 0.01; done", name)}
+		fluxFile.Tasks = append(fluxFile.Tasks, task)
+		all.Deps = append(all.Deps, name)
+	}
+	fluxFile.Tasks = append(fluxFile.Tasks, all)
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	var terminal syncBuffer
+	exec.logger = logger.New().WithOutput(&terminal, &terminal)
+	exec.SetOutputMode(OutputPrefixed)
+	if err := exec.Execute("all", "", false); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, line := range strings.Split(terminal.String(), "\n") {
+		for _, name := range names {
+			if !strings.Contains(line, name+"-") || strings.Contains(line, "$") {
+				continue
+			}
+			counts[name]++
+			if want := taskPrefix(name) + " " + name + "-"; !strings.HasPrefix(line, want) {
+				t.Errorf("Expected the output of %s to start with its prefix, got %q", name, line)
+			}
+		}
+	}
+	for _, name := range names {
+		if counts[name] != 3 {
+			t.Errorf("Expected 3 output lines of %s, got %d in:\n%s", name, counts[name], terminal.String())
+		}
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	exec, err := New(ast.NewFluxFile(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	var terminal bytes.Buffer
+	exec.logger = logger.New().WithOutput(&terminal, &terminal)
+	lint := &ast.Task{Name: "lint"}
+
+	exec.SetOutputMode(OutputPrefixed)
+	exec.newTaskRun(lint, nil).out.Stdout("ok")
+	if got := terminal.String(); got != taskPrefix("lint")+" ok\n" || !strings.Contains(got, "[lint]") {
+		t.Errorf("Expected a prefixed line, got %q", got)
+	}
+
+	terminal.Reset()
+	exec.SetOutputMode(OutputGrouped)
+	run := exec.newTaskRun(lint, nil)
+	run.out.Stdout("a")
+	run.out.Stderr("b")
+	run.out.Stdout("c")
+	if terminal.Len() != 0 {
+		t.Errorf("Expected grouped output to be held back, got %q", terminal.String())
+	}
+	var stdout, stderr bytes.Buffer
+	run.group.writeTo(&stdout, &stderr)
+	if stdout.String() != "  a\n  c\n" || stderr.String() != "  b\n" {
+		t.Errorf("Unexpected grouped output %q, %q", stdout.String(), stderr.String())
+	}
+
+	serve := exec.newTaskRun(&ast.Task{Name: "serve", Restart: true}, nil)
+	if serve.group != nil {
+		t.Error("Expected tasks with restart: true to stream in grouped mode")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	logs.SetLogDir(t.TempDir())
+	path := filepath.Join(t.TempDir(), "traceparent.txt")
+
+	fluxFile := ast.NewFluxFile()
+	lint := ast.NewTask("lint")
+	lint.Run = []string{"echo $TRACEPARENT > " + path}
+	build := ast.NewTask("build")
+	build.Deps = []string{"lint"}
+	build.Run = []string{"exit 3"}
+	fluxFile.Tasks = []ast.Task{lint, build}
+
+	exec, err := New(fluxFile, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	tracer := telemetry.NewTracer("")
+	root := tracer.Start("flux build", nil)
+	exec.SetTracer(tracer, root)
+	if err := exec.Execute("build", "", false); err == nil {
+		t.Fatal("Expected build to fail")
+	}
+	root.End(nil)
+
+	spans := make(map[string]*telemetry.Span)
+	children := make(map[string][]*telemetry.Span)
+	for _, span := range tracer.Spans() {
+		if span.TraceID != tracer.TraceID() {
+			t.Errorf("Expected %s in trace %s, got %s", span.Name, tracer.TraceID(), span.TraceID)
+		}
+		spans[span.Name] = span
+		children[span.ParentID] = append(children[span.ParentID], span)
+	}
+	for _, name := range []string{"lint", "build"} {
+		span := spans[name]
+		if span == nil || span.ParentID != root.SpanID || span.Attributes["flux.task.name"] != name || span.Attributes["flux.task.cache_hit"] != false {
+			t.Fatalf("Expected a span of task %s under the root, got %+v", name, span)
+		}
+	}
+	if spans["lint"].Attributes["flux.task.status"] != "passed" || spans["build"].Error == "" {
+		t.Errorf("Expected lint to pass and build to fail, got %+v and %+v", spans["lint"], spans["build"])
+	}
+
+	command := spans["exit 3"]
+	if command == nil || command.ParentID != spans["build"].SpanID || command.Attributes["process.exit_code"] != 3 {
+		t.Errorf("Expected a span of the failed command under build, got %+v", command)
+	}
+	if len(children[spans["lint"].SpanID]) != 1 {
+		t.Fatalf("Expected a span of the echo command under lint, got %v", children[spans["lint"].SpanID])
+	}
+	echo := children[spans["lint"].SpanID][0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read traceparent: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != echo.Traceparent() {
+		t.Errorf("Expected TRACEPARENT %s in the command's environment, got %s", echo.Traceparent(), got)
+	}
+}
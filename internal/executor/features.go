@@ -2,91 +2,50 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
 	"github.com/ashavijit/fluxfile/internal/cache"
+	"github.com/ashavijit/fluxfile/internal/expr"
+	"github.com/ashavijit/fluxfile/internal/vars"
 )
 
-func (e *Executor) evaluateCondition(condition string, vars map[string]string) (bool, error) {
+func (e *Executor) evaluateCondition(condition string, env *expr.Env) (bool, error) {
 	if condition == "" {
 		return true, nil
 	}
 
-	condition = strings.TrimSpace(condition)
-	condition = strings.ReplaceAll(condition, " = = ", "==")
-	condition = strings.ReplaceAll(condition, " ! = ", "!=")
-	condition = strings.ReplaceAll(condition, " > = ", ">=")
-	condition = strings.ReplaceAll(condition, " < = ", "<=")
-
-	operators := []string{"==", "!=", ">=", "<=", ">", "<"}
-	var operator string
-	var leftPart, rightPart string
-
-	for _, op := range operators {
-		if strings.Contains(condition, op) {
-			parts := strings.SplitN(condition, op, 2)
-			if len(parts) == 2 {
-				operator = op
-				leftPart = strings.TrimSpace(parts[0])
-				rightPart = strings.TrimSpace(parts[1])
-				break
-			}
-		}
+	parsed, err := expr.Parse(condition)
+	if err != nil {
+		return false, err
 	}
 
-	if operator == "" {
-		return false, fmt.Errorf("no valid operator found in condition: %s", condition)
-	}
+	return parsed.Eval(env)
+}
 
-	left := vars[leftPart]
-	if left == "" {
-		left = leftPart
+// changedSince reports whether the files matching pattern differ from the
+// snapshot recorded after the task's last successful run. The returned entry
+// records the current snapshot once the task succeeds.
+func (e *Executor) changedSince(taskName, pattern string) (bool, *cache.CacheEntry, error) {
+	hash, err := cache.HashFiles([]string{pattern})
+	if err != nil {
+		return false, nil, err
 	}
 
-	right := strings.Trim(rightPart, "\"'")
+	key := fmt.Sprintf("%s_changed_%s", taskName, cache.HashString(pattern)[:12])
+	_, unchanged := e.cache.Get(key, hash)
 
-	switch operator {
-	case "==":
-		return left == right, nil
-	case "!=":
-		return left != right, nil
-	case ">":
-		leftNum, err1 := strconv.Atoi(left)
-		rightNum, err2 := strconv.Atoi(right)
-		if err1 != nil || err2 != nil {
-			return false, fmt.Errorf("invalid numeric comparison")
-		}
-		return leftNum > rightNum, nil
-	case "<":
-		leftNum, err1 := strconv.Atoi(left)
-		rightNum, err2 := strconv.Atoi(right)
-		if err1 != nil || err2 != nil {
-			return false, fmt.Errorf("invalid numeric comparison")
-		}
-		return leftNum < rightNum, nil
-	case ">=":
-		leftNum, err1 := strconv.Atoi(left)
-		rightNum, err2 := strconv.Atoi(right)
-		if err1 != nil || err2 != nil {
-			return false, fmt.Errorf("invalid numeric comparison")
-		}
-		return leftNum >= rightNum, nil
-	case "<=":
-		leftNum, err1 := strconv.Atoi(left)
-		rightNum, err2 := strconv.Atoi(right)
-		if err1 != nil || err2 != nil {
-			return false, fmt.Errorf("invalid numeric comparison")
-		}
-		return leftNum <= rightNum, nil
-	default:
-		return false, fmt.Errorf("unknown operator: %s", operator)
-	}
+	return !unchanged, &cache.CacheEntry{
+		TaskName:  key,
+		InputHash: hash,
+		Success:   true,
+		Timestamp: time.Now(),
+	}, nil
 }
 
 func (e *Executor) checkPreconditions(preconditions []ast.Precondition) error {
@@ -111,7 +70,7 @@ func (e *Executor) checkPreconditions(preconditions []ast.Precondition) error {
 	return nil
 }
 
-func (e *Executor) executeWithRetry(task *ast.Task, vars map[string]string) error {
+func (e *Executor) executeWithRetry(task *ast.Task, taskVars, env map[string]string, run *taskRun) error {
 	maxRetries := task.Retries
 	if maxRetries <= 0 {
 		maxRetries = 1
@@ -125,9 +84,9 @@ func (e *Executor) executeWithRetry(task *ast.Task, vars map[string]string) erro
 			time.Sleep(delay)
 		}
 
-		err := e.runCommands(task, vars)
-		if err == nil {
-			return nil
+		err := e.runCommands(task, taskVars, env, run)
+		if err == nil || errors.Is(err, ErrStopped) {
+			return err
 		}
 		lastErr = err
 	}
@@ -135,9 +94,9 @@ func (e *Executor) executeWithRetry(task *ast.Task, vars map[string]string) erro
 	return lastErr
 }
 
-func (e *Executor) executeWithTimeout(task *ast.Task, vars map[string]string) error {
+func (e *Executor) executeWithTimeout(task *ast.Task, taskVars, env map[string]string, run *taskRun) error {
 	if task.Timeout == "" {
-		return e.executeWithRetry(task, vars)
+		return e.executeWithRetry(task, taskVars, env, run)
 	}
 
 	timeout, err := time.ParseDuration(task.Timeout)
@@ -150,7 +109,7 @@ func (e *Executor) executeWithTimeout(task *ast.Task, vars map[string]string) er
 
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- e.executeWithRetry(task, vars)
+		errChan <- e.executeWithRetry(task, taskVars, env, run)
 	}()
 
 	select {
@@ -161,16 +120,43 @@ func (e *Executor) executeWithTimeout(task *ast.Task, vars map[string]string) er
 	}
 }
 
-func (e *Executor) runCommands(task *ast.Task, vars map[string]string) error {
-	expandedRun := expandSlice(task.Run, vars)
+func (e *Executor) runCommands(task *ast.Task, taskVars, env map[string]string, run *taskRun) error {
+	expandedRun, err := vars.ExpandCommands(task.Run, taskVars, e.strict)
+	if err != nil {
+		return err
+	}
 	for _, cmd := range expandedRun {
-		if err := e.runCommand(cmd, vars); err != nil {
+		if err := e.runCommand(task, cmd, env, run); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// taskEnv expands the vars a task runs with, including its secrets.
+// $(shell ...) vars are only evaluated when the task's commands, condition
+// or env refer to them.
+func (e *Executor) taskEnv(task *ast.Task, taskVars map[string]string) (map[string]string, error) {
+	values := append([]string{}, task.Run...)
+	names := make([]string, 0, len(task.Env))
+	for name, value := range task.Env {
+		values = append(values, value)
+		names = append(names, name)
+	}
+	names = append(names, vars.References(values...)...)
+	for _, secret := range task.Secrets {
+		names = append(names, secret.Name)
+	}
+
+	if task.If != "" {
+		if parsed, err := expr.Parse(task.If); err == nil {
+			names = append(names, parsed.Vars()...)
+		}
+	}
+
+	return vars.Resolve(taskVars, names, e.strict)
+}
+
 func (e *Executor) checkEnhancedCache(task *ast.Task, useCache bool) (bool, string) {
 	if !useCache || !task.Cache {
 		return false, ""
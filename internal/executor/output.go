@@ -0,0 +1,228 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/logger"
+	"github.com/ashavijit/fluxfile/internal/logs"
+	"github.com/ashavijit/fluxfile/internal/telemetry"
+)
+
+// Output modes select how the output of tasks reaches the terminal.
+const (
+	// OutputInterleaved writes output lines as they come, unlabeled.
+	OutputInterleaved = "interleaved"
+	// OutputPrefixed starts every line with the task name in a color of
+	// its own.
+	OutputPrefixed = "prefixed"
+	// OutputGrouped holds back the output of each task and prints it when
+	// the task finishes.
+	OutputGrouped = "grouped"
+)
+
+// defaultOutputLimit is the default number of bytes of a command's output
+// kept in the log store.
+const defaultOutputLimit = 256 << 10
+
+// lineWriter calls emit for every line written to it.
+type lineWriter struct {
+	emit func(string)
+	buf  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits a last line that did not end in a newline.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// outputCapture keeps up to limit bytes of a command's output. Longer
+// output keeps its beginning and end, which usually holds the error, and
+// the middle is replaced by a truncation marker. A limit of 0 keeps
+// everything.
+type outputCapture struct {
+	mu      sync.Mutex
+	limit   int
+	head    []byte
+	tail    []byte
+	dropped int
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(p)
+	if c.limit <= 0 {
+		c.head = append(c.head, p...)
+		return n, nil
+	}
+
+	half := c.limit / 2
+	if room := half - len(c.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		c.head = append(c.head, p[:room]...)
+		p = p[room:]
+	}
+
+	// The tail grows to twice its size before being cut back, so that
+	// bytes are not moved on every write.
+	c.tail = append(c.tail, p...)
+	if keep := c.limit - half; len(c.tail) > 2*keep {
+		cut := len(c.tail) - keep
+		c.dropped += cut
+		c.tail = append(c.tail[:0:0], c.tail[cut:]...)
+	}
+	return n, nil
+}
+
+func (c *outputCapture) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tail, dropped := c.tail, c.dropped
+	if keep := c.limit - c.limit/2; c.limit > 0 && len(tail) > keep {
+		dropped += len(tail) - keep
+		tail = tail[len(tail)-keep:]
+	}
+	if dropped == 0 {
+		return strings.TrimRight(string(c.head)+string(tail), "\n")
+	}
+
+	// Cut the kept output at line boundaries.
+	head := c.head
+	if i := bytes.LastIndexByte(head, '\n'); i > 0 {
+		dropped += len(head) - i
+		head = head[:i]
+	}
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		dropped += i + 1
+		tail = tail[i+1:]
+	}
+	return fmt.Sprintf("%s\n... [%d bytes truncated] ...\n%s", head, dropped, strings.TrimRight(string(tail), "\n"))
+}
+
+// taskRun is the state of one execution of a task.
+type taskRun struct {
+	log *logs.TaskHandle
+	// out echoes commands and writes their output in the output mode.
+	out   *logger.Logger
+	group *outputGroup
+	// stdout and stderr collect the task's output for the report, when
+	// there is one.
+	stdout *outputCapture
+	stderr *outputCapture
+	// worker is the lane the task runs on, start is when it started and
+	// wait is how long it was blocked on its dependencies.
+	worker int
+	start  time.Time
+	wait   time.Duration
+	// inputHash is the hash of the task's input files, once known.
+	inputHash string
+	// span traces the task when tracing is on.
+	span *telemetry.Span
+}
+
+func (e *Executor) newTaskRun(task *ast.Task, log *logs.TaskHandle) *taskRun {
+	run := &taskRun{log: log, out: e.logger}
+	if e.collector != nil {
+		run.stdout = &outputCapture{limit: e.outputLimit}
+		run.stderr = &outputCapture{limit: e.outputLimit}
+	}
+	switch e.outputMode {
+	case OutputPrefixed:
+		run.out = e.logger.WithPrefix(taskPrefix(task.Name))
+	case OutputGrouped:
+		// Tasks with restart: true run until stopped, so they stream.
+		if profiled, _, err := e.profileTask(task, e.profiles); err == nil && profiled.Restart {
+			run.out = e.logger.WithPrefix(taskPrefix(task.Name))
+			break
+		}
+		run.group = &outputGroup{}
+		run.out = e.logger.WithOutput(&groupWriter{run.group, false}, &groupWriter{run.group, true})
+	}
+	return run
+}
+
+// flush prints the output that run held back in grouped mode. Groups are
+// printed one at a time so that they do not mix.
+func (e *Executor) flush(run *taskRun) {
+	if run.group == nil {
+		return
+	}
+	e.groupMu.Lock()
+	defer e.groupMu.Unlock()
+	run.group.writeTo(os.Stdout, os.Stderr)
+}
+
+var prefixColors = []string{"36", "32", "33", "34", "35", "96", "92", "93", "94", "95"}
+
+// taskPrefix returns [name] in a color picked by name, so that a task keeps
+// its color across runs.
+func taskPrefix(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	color := prefixColors[h.Sum32()%uint32(len(prefixColors))]
+	return fmt.Sprintf("\033[%sm[%s]\033[0m", color, name)
+}
+
+// outputGroup holds the output of a task in grouped mode.
+type outputGroup struct {
+	mu     sync.Mutex
+	chunks []outputChunk
+}
+
+type outputChunk struct {
+	stderr bool
+	data   []byte
+}
+
+func (g *outputGroup) writeTo(stdout, stderr io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, chunk := range g.chunks {
+		if chunk.stderr {
+			stderr.Write(chunk.data)
+		} else {
+			stdout.Write(chunk.data)
+		}
+	}
+	g.chunks = nil
+}
+
+type groupWriter struct {
+	group  *outputGroup
+	stderr bool
+}
+
+func (w *groupWriter) Write(p []byte) (int, error) {
+	w.group.mu.Lock()
+	defer w.group.mu.Unlock()
+	w.group.chunks = append(w.group.chunks, outputChunk{w.stderr, append([]byte(nil), p...)})
+	return len(p), nil
+}
@@ -2,9 +2,10 @@ package executor
 
 import (
 	"fmt"
-	"os"
-	"strings"
 	"sync"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/vars"
 )
 
 func (e *Executor) executeDependenciesParallel(deps []string, useCache bool) error {
@@ -38,32 +39,17 @@ func (e *Executor) executeDependenciesParallel(deps []string, useCache bool) err
 	return nil
 }
 
-func (e *Executor) loadSecrets(secrets []string, vars map[string]string) error {
-	for _, secretKey := range secrets {
-		value := os.Getenv(secretKey)
-		if value == "" {
-			envFile := ".env"
-			if envContent, err := os.ReadFile(envFile); err == nil {
-				lines := strings.Split(string(envContent), "\n")
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if line == "" || strings.HasPrefix(line, "#") {
-						continue
-					}
-					parts := strings.SplitN(line, "=", 2)
-					if len(parts) == 2 && parts[0] == secretKey {
-						value = strings.Trim(parts[1], "\"'")
-						break
-					}
-				}
-			}
-		}
-
-		if value != "" {
-			vars[secretKey] = value
-		} else {
-			return fmt.Errorf("secret %s not found in environment or .env file", secretKey)
+// loadSecrets resolves the task's secrets into taskVars and registers their
+// values with the masker so that they never appear in output or logs. The
+// values are escaped so that they are never expanded.
+func (e *Executor) loadSecrets(taskSecrets []ast.Secret, taskVars map[string]string) error {
+	for _, secret := range taskSecrets {
+		value, err := e.secrets.Resolve(secret.Name, secret.Source)
+		if err != nil {
+			return err
 		}
+		e.masker.Add(value)
+		taskVars[secret.Name] = vars.Escape(value)
 	}
 	return nil
 }
@@ -0,0 +1,23 @@
+package executor
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in its own process group, so that the
+// processes it spawns can be stopped together.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminate stops cmd and the processes it spawned. Windows has no SIGTERM,
+// so this is the same as kill.
+func terminate(cmd *exec.Cmd) error {
+	return kill(cmd)
+}
+
+func kill(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
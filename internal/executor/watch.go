@@ -0,0 +1,166 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/config"
+	"github.com/ashavijit/fluxfile/internal/glob"
+)
+
+// WatchIndex maps files to the tasks that watch them or read them as
+// inputs, for a set of target tasks and everything they depend on.
+type WatchIndex struct {
+	dir   string
+	tasks []*ast.Task
+}
+
+// WatchIndex builds the index for targets with the overrides of profile
+// applied. Patterns are relative to the working directory.
+func (e *Executor) WatchIndex(targets []string, profile string) (*WatchIndex, error) {
+	order, err := e.graph.Order(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := config.ResolveProfiles(e.fluxFile, config.SplitProfiles(profile))
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &WatchIndex{dir: dir}
+	for _, name := range order {
+		task, err := e.graph.GetTask(name)
+		if err != nil {
+			return nil, err
+		}
+		task, _, err = e.profileTask(task, profiles)
+		if err != nil {
+			return nil, err
+		}
+		index.tasks = append(index.tasks, task)
+	}
+	return index, nil
+}
+
+// Tasks returns the indexed tasks in dependency order.
+func (x *WatchIndex) Tasks() []string {
+	var names []string
+	for _, task := range x.tasks {
+		names = append(names, task.Name)
+	}
+	return names
+}
+
+// Task returns the indexed task name, with profile overrides applied, or
+// nil when it is not indexed.
+func (x *WatchIndex) Task(name string) *ast.Task {
+	for _, task := range x.tasks {
+		if task.Name == name {
+			return task
+		}
+	}
+	return nil
+}
+
+// Patterns returns the watch and input patterns of all tasks.
+func (x *WatchIndex) Patterns() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, task := range x.tasks {
+		for _, pattern := range watchPatterns(task) {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// Ignore returns the ignore patterns shared by all tasks that have
+// patterns. Patterns ignored by only some tasks are applied by Affected.
+func (x *WatchIndex) Ignore() []string {
+	var shared []string
+	first := true
+	for _, task := range x.tasks {
+		if len(watchPatterns(task)) == 0 {
+			continue
+		}
+		if first {
+			shared = append(shared, task.WatchIgnore...)
+			first = false
+			continue
+		}
+
+		var kept []string
+		for _, pattern := range shared {
+			for _, other := range task.WatchIgnore {
+				if pattern == other {
+					kept = append(kept, pattern)
+					break
+				}
+			}
+		}
+		shared = kept
+	}
+	return shared
+}
+
+// Affected returns the tasks that watch or read one of the changed files,
+// which are absolute paths, together with the tasks that depend on them,
+// in dependency order.
+func (x *WatchIndex) Affected(changed []string) []string {
+	rerun := make(map[string]bool)
+	var names []string
+	for _, task := range x.tasks {
+		affected := false
+		for _, dep := range task.Deps {
+			if rerun[dep] {
+				affected = true
+				break
+			}
+		}
+		for _, path := range changed {
+			if affected {
+				break
+			}
+			affected = x.matches(task, path)
+		}
+
+		if affected {
+			rerun[task.Name] = true
+			names = append(names, task.Name)
+		}
+	}
+	return names
+}
+
+func (x *WatchIndex) matches(task *ast.Task, path string) bool {
+	for _, pattern := range task.WatchIgnore {
+		pattern = strings.TrimPrefix(pattern, "!")
+		for p := path; p != x.dir && filepath.Dir(p) != p; p = filepath.Dir(p) {
+			if glob.MatchFile(pattern, p, x.dir) {
+				return false
+			}
+		}
+	}
+
+	for _, pattern := range watchPatterns(task) {
+		if glob.MatchFile(pattern, path, x.dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func watchPatterns(task *ast.Task) []string {
+	return append(append([]string{}, task.Watch...), task.Inputs...)
+}
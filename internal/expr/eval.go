@@ -0,0 +1,331 @@
+package expr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Env supplies the variables and hooks an expression is evaluated against.
+type Env struct {
+	Vars map[string]string
+	// Changed reports whether files matching pattern changed since the task
+	// last ran successfully. changed() fails when it is nil.
+	Changed func(pattern string) (bool, error)
+}
+
+// lookup returns the value of a variable. The process environment is only
+// read by env(), so that a stray environment variable cannot change what a
+// bare word means.
+func (env *Env) lookup(name string) (string, bool) {
+	if env == nil {
+		return "", false
+	}
+	v, ok := env.Vars[name]
+	return v, ok
+}
+
+// Eval evaluates the expression and converts the result to a bool.
+func (e *Expression) Eval(env *Env) (bool, error) {
+	return truthy(e.root, env)
+}
+
+type node interface {
+	eval(env *Env) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+type variable struct {
+	name     string
+	explicit bool
+}
+
+type list struct {
+	items []node
+}
+
+type not struct {
+	operand node
+}
+
+type logical struct {
+	op          tokenType
+	left, right node
+}
+
+type comparison struct {
+	op          tokenType
+	left, right node
+	re          *regexp.Regexp
+}
+
+type call struct {
+	name string
+	fn   function
+	args []node
+}
+
+type function struct {
+	arity int
+	call  func(env *Env, args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"exists": {1, func(env *Env, args []interface{}) (interface{}, error) {
+		path := toString(args[0])
+		if strings.ContainsAny(path, "*?[") {
+			matches, err := filepath.Glob(path)
+			return len(matches) > 0, err
+		}
+		_, err := os.Stat(path)
+		return err == nil, nil
+	}},
+	"env": {1, func(env *Env, args []interface{}) (interface{}, error) {
+		name := toString(args[0])
+		if v, ok := env.lookup(name); ok {
+			return v, nil
+		}
+		return os.Getenv(name), nil
+	}},
+	"os": {0, func(env *Env, args []interface{}) (interface{}, error) {
+		return runtime.GOOS, nil
+	}},
+	"arch": {0, func(env *Env, args []interface{}) (interface{}, error) {
+		return runtime.GOARCH, nil
+	}},
+	"changed": {1, func(env *Env, args []interface{}) (interface{}, error) {
+		if env == nil || env.Changed == nil {
+			return nil, fmt.Errorf("changed() is not available here")
+		}
+		return env.Changed(toString(args[0]))
+	}},
+}
+
+func (n *literal) eval(env *Env) (interface{}, error) {
+	return n.value, nil
+}
+
+// A bare word that is not a defined variable evaluates to itself; ${NAME}
+// evaluates to the empty string instead.
+func (n *variable) eval(env *Env) (interface{}, error) {
+	if v, ok := env.lookup(n.name); ok {
+		return v, nil
+	}
+	if n.explicit {
+		return "", nil
+	}
+	return n.name, nil
+}
+
+func (n *list) eval(env *Env) (interface{}, error) {
+	values := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (n *not) eval(env *Env) (interface{}, error) {
+	v, err := truthy(n.operand, env)
+	return !v, err
+}
+
+func (n *logical) eval(env *Env) (interface{}, error) {
+	left, err := truthy(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == tokAnd && !left || n.op == tokOr && left {
+		return left, nil
+	}
+	return truthy(n.right, env)
+}
+
+func (n *comparison) eval(env *Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case tokEq:
+		return equal(left, right, numeric(n.left) || numeric(n.right)), nil
+	case tokNe:
+		return !equal(left, right, numeric(n.left) || numeric(n.right)), nil
+	case tokIn:
+		items, _ := n.right.(*list)
+		for i, item := range toList(right) {
+			asNumbers := numeric(n.left) || items != nil && numeric(items.items[i])
+			if equal(left, item, asNumbers) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case tokMatch, tokNotMatch:
+		re := n.re
+		if re == nil {
+			if re, err = regexp.Compile(toString(right)); err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %v", toString(right), err)
+			}
+		}
+		return re.MatchString(toString(left)) == (n.op == tokMatch), nil
+	}
+
+	var cmp int
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	switch {
+	case lok && rok && l < r:
+		cmp = -1
+	case lok && rok && l > r:
+		cmp = 1
+	case !lok || !rok:
+		cmp = strings.Compare(toString(left), toString(right))
+	}
+
+	switch n.op {
+	case tokLt:
+		return cmp < 0, nil
+	case tokLe:
+		return cmp <= 0, nil
+	case tokGt:
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func (n *call) eval(env *Env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	v, err := n.fn.call(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return v, nil
+}
+
+// truthy evaluates n as a condition. A bare variable name that is not
+// defined is false rather than the name itself.
+func truthy(n node, env *Env) (bool, error) {
+	if v, ok := n.(*variable); ok {
+		value, defined := env.lookup(v.name)
+		return defined && truthyString(value), nil
+	}
+
+	value, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		return v != 0, nil
+	case []interface{}:
+		return len(v) > 0, nil
+	default:
+		return truthyString(toString(v)), nil
+	}
+}
+
+func truthyString(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
+
+// equal compares a and b as numbers when asNumbers is set and both are
+// numbers, and as strings otherwise.
+func equal(a, b interface{}, asNumbers bool) bool {
+	if asNumbers {
+		if x, ok := toNumber(a); ok {
+			if y, ok := toNumber(b); ok {
+				return x == y
+			}
+		}
+	}
+	return toString(a) == toString(b)
+}
+
+// numeric reports whether n is a number written in the expression. Values
+// are only equal as numbers when compared with one, so that strings such as
+// the versions "1.10" and "1.1" stay different.
+func numeric(n node) bool {
+	l, ok := n.(*literal)
+	if !ok {
+		return false
+	}
+	_, ok = l.value.(float64)
+	return ok
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(s)
+	case []interface{}:
+		parts := make([]string, len(s))
+		for i, item := range s {
+			parts[i] = toString(item)
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// toList splits a string on commas and whitespace so that a variable holding
+// "linux, darwin" can be used on the right of in.
+func toList(v interface{}) []interface{} {
+	if l, ok := v.([]interface{}); ok {
+		return l
+	}
+
+	var items []interface{}
+	for _, field := range strings.FieldsFunc(toString(v), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		items = append(items, field)
+	}
+	return items
+}
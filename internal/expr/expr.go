@@ -0,0 +1,479 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokWord
+	tokString
+	tokVar
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokAnd
+	tokOr
+	tokNot
+	tokEq
+	tokNe
+	tokLt
+	tokLe
+	tokGt
+	tokGe
+	tokMatch
+	tokNotMatch
+	tokIn
+)
+
+var tokenNames = map[tokenType]string{
+	tokEOF:      "end of expression",
+	tokLParen:   "(",
+	tokRParen:   ")",
+	tokLBracket: "[",
+	tokRBracket: "]",
+	tokComma:    ",",
+	tokAnd:      "&&",
+	tokOr:       "||",
+	tokNot:      "!",
+	tokEq:       "==",
+	tokNe:       "!=",
+	tokLt:       "<",
+	tokLe:       "<=",
+	tokGt:       ">",
+	tokGe:       ">=",
+	tokMatch:    "=~",
+	tokNotMatch: "!~",
+	tokIn:       "in",
+}
+
+type token struct {
+	typ tokenType
+	lit string
+	pos int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokWord:
+		return t.lit
+	case tokString:
+		return strconv.Quote(t.lit)
+	case tokVar:
+		return "${" + t.lit + "}"
+	}
+	return tokenNames[t.typ]
+}
+
+// Expression is a parsed if: condition.
+type Expression struct {
+	src  string
+	root node
+}
+
+// Parse parses a condition such as
+//
+//	MODE == "prod" && (os() == linux || env("CI") != "")
+//
+// Bare words are variable names when such a variable is defined and plain
+// strings otherwise, so `MODE == prod` compares the MODE variable with "prod".
+func Parse(src string) (*Expression, error) {
+	tokens, err := scan(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at column %d", tok, tok.pos+1)
+	}
+
+	return &Expression{src: src, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+// Vars returns the names of the variables the expression refers to,
+// including bare words that may turn out to be plain strings.
+func (e *Expression) Vars() []string {
+	var names []string
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *variable:
+			names = append(names, n.name)
+		case *list:
+			for _, item := range n.items {
+				walk(item)
+			}
+		case *not:
+			walk(n.operand)
+		case *logical:
+			walk(n.left)
+			walk(n.right)
+		case *comparison:
+			walk(n.left)
+			walk(n.right)
+		case *call:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.root)
+	return names
+}
+
+func scan(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+
+	for i < len(src) {
+		ch := src[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			i++
+			continue
+		case ch == '#':
+			i = len(src)
+			continue
+		case ch == '"' || ch == '\'':
+			lit, end, err := scanString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{typ: tokString, lit: lit, pos: i})
+			i = end
+			continue
+		case ch == '$' && i+1 < len(src) && src[i+1] == '{':
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ${ at column %d", i+1)
+			}
+			tokens = append(tokens, token{typ: tokVar, lit: src[i+2 : i+end], pos: i})
+			i += end + 1
+			continue
+		case isWordChar(ch):
+			start := i
+			for i < len(src) && isWordChar(src[i]) {
+				i++
+			}
+			word := src[start:i]
+			if word == "in" {
+				tokens = append(tokens, token{typ: tokIn, lit: word, pos: start})
+			} else {
+				tokens = append(tokens, token{typ: tokWord, lit: word, pos: start})
+			}
+			continue
+		}
+
+		two := ""
+		if i+1 < len(src) {
+			two = src[i : i+2]
+		}
+
+		var typ tokenType
+		width := 2
+		switch two {
+		case "&&":
+			typ = tokAnd
+		case "||":
+			typ = tokOr
+		case "==":
+			typ = tokEq
+		case "!=":
+			typ = tokNe
+		case "<=":
+			typ = tokLe
+		case ">=":
+			typ = tokGe
+		case "=~":
+			typ = tokMatch
+		case "!~":
+			typ = tokNotMatch
+		default:
+			width = 1
+			switch ch {
+			case '(':
+				typ = tokLParen
+			case ')':
+				typ = tokRParen
+			case '[':
+				typ = tokLBracket
+			case ']':
+				typ = tokRBracket
+			case ',':
+				typ = tokComma
+			case '!':
+				typ = tokNot
+			case '<':
+				typ = tokLt
+			case '>':
+				typ = tokGt
+			default:
+				return nil, fmt.Errorf("unexpected character %q at column %d", ch, i+1)
+			}
+		}
+
+		tokens = append(tokens, token{typ: typ, lit: src[i : i+width], pos: i})
+		i += width
+	}
+
+	return append(tokens, token{typ: tokEOF, pos: len(src)}), nil
+}
+
+func scanString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var sb strings.Builder
+
+	for i := start + 1; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '\\' && i+1 < len(src):
+			i++
+			sb.WriteByte(src[i])
+		case ch == quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string at column %d", start+1)
+}
+
+func isWordChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		ch == '_' || ch == '.' || ch == '-' || ch == '/' || ch == '*'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(typ tokenType) (token, error) {
+	tok := p.next()
+	if tok.typ != typ {
+		return tok, fmt.Errorf("expected %s, got %s at column %d", tokenNames[typ], tok, tok.pos+1)
+	}
+	return tok, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().typ == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: tokOr, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().typ == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: tokAnd, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().typ == tokNot {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	switch op.typ {
+	case tokEq, tokNe, tokLt, tokLe, tokGt, tokGe, tokIn:
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &comparison{op: op.typ, left: left, right: right}, nil
+	case tokMatch, tokNotMatch:
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		cmp := &comparison{op: op.typ, left: left, right: right}
+		if lit, ok := right.(*literal); ok {
+			re, err := regexp.Compile(toString(lit.value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression at column %d: %v", op.pos+1, err)
+			}
+			cmp.re = re
+		}
+		return cmp, nil
+	}
+
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+
+	switch tok.typ {
+	case tokString:
+		return &literal{value: tok.lit}, nil
+	case tokVar:
+		return &variable{name: tok.lit, explicit: true}, nil
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case tokLBracket:
+		return p.parseList()
+	case tokWord:
+		if p.peek().typ == tokLParen {
+			return p.parseCall(tok)
+		}
+		switch tok.lit {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		}
+		if n, err := strconv.ParseFloat(tok.lit, 64); err == nil {
+			return &literal{value: n}, nil
+		}
+		if isIdentifier(tok.lit) {
+			return &variable{name: tok.lit}, nil
+		}
+		return &literal{value: tok.lit}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %s at column %d", tok, tok.pos+1)
+}
+
+func (p *parser) parseList() (node, error) {
+	l := &list{}
+	if p.peek().typ == tokRBracket {
+		p.next()
+		return l, nil
+	}
+
+	for {
+		item, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		l.items = append(l.items, item)
+
+		tok := p.next()
+		if tok.typ == tokRBracket {
+			return l, nil
+		}
+		if tok.typ != tokComma {
+			return nil, fmt.Errorf("expected , or ] in list, got %s at column %d", tok, tok.pos+1)
+		}
+	}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.lit]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at column %d", name.lit, name.pos+1)
+	}
+
+	p.next()
+	c := &call{name: name.lit, fn: fn}
+	if p.peek().typ != tokRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+
+	if len(c.args) != fn.arity {
+		return nil, fmt.Errorf("%s() takes %d argument(s), got %d at column %d", name.lit, fn.arity, len(c.args), name.pos+1)
+	}
+
+	return c, nil
+}
+
+func isIdentifier(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || i > 0 && ch >= '0' && ch <= '9' {
+			continue
+		}
+		return false
+	}
+	return s != ""
+}
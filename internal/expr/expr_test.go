@@ -0,0 +1,163 @@
+package expr
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "go.mod")
+	os.WriteFile(file, []byte("module x\n"), 0644)
+
+	vars := map[string]string{
+		"MODE":    "prod",
+		"REGION":  "us-east-1",
+		"COUNT":   "10",
+		"VERSION": "1.21.3",
+		"TARGETS": "linux, darwin",
+		"EMPTY":   "",
+		"OLD":     "1.1",
+		"NEW":     "1.10",
+		"BIG":     "1e3",
+	}
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{`MODE == prod`, true},
+		{`MODE == "prod"`, true},
+		{`MODE != prod`, false},
+		{`MODE == prod && REGION != eu`, true},
+		{`MODE == dev || COUNT > 5`, true},
+		{`!(MODE == dev)`, true},
+		{`!MODE`, false},
+		{`EMPTY`, false},
+		{`UNDEFINED_VAR`, false},
+		{`${UNDEFINED_VAR} == ""`, true},
+		{`COUNT >= 10 && COUNT < 10.5`, true},
+		{`COUNT > 9.99`, true},
+		{`MODE in [dev, prod]`, true},
+		{`MODE in ["dev", "staging"]`, false},
+		{`darwin in TARGETS`, true},
+		{`VERSION =~ "^1\\.21\\."`, true},
+		{`REGION !~ '^eu-'`, true},
+		{`true && !false`, true},
+		{`exists("` + filepath.ToSlash(file) + `")`, true},
+		{`exists("` + filepath.ToSlash(dir) + `/*.mod")`, true},
+		{`exists("` + filepath.ToSlash(dir) + `/missing")`, false},
+		{`env("MODE") == prod`, true},
+		{`os() == ` + runtime.GOOS + ` && arch() == ` + runtime.GOARCH, true},
+		{`MODE == prod # trailing comment`, true},
+		{`NEW == OLD`, false},
+		{`NEW == "1.1"`, false},
+		{`"1.10" == "1.1"`, false},
+		{`"1e3" == "1000"`, false},
+		{`BIG == "1000"`, false},
+		{`BIG == 1000`, true},
+		{`COUNT == 10.0`, true},
+		{`COUNT != "10.0"`, true},
+		{`COUNT in [5, 10.0]`, true},
+		{`NEW in ["1.1", "1.2"]`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			e, err := Parse(tt.condition)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.condition, err)
+			}
+
+			result, err := e.Eval(&Env{Vars: vars})
+			if err != nil {
+				t.Fatalf("Eval(%q) failed: %v", tt.condition, err)
+			}
+			if result != tt.expected {
+				t.Errorf("Eval(%q) = %v, expected %v", tt.condition, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvalIgnoresEnvironment(t *testing.T) {
+	t.Setenv("prod", "staging")
+	t.Setenv("FLUX_EXPR_FLAG", "yes")
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{`MODE == prod`, true},
+		{`FLUX_EXPR_FLAG`, false},
+		{`FLUX_EXPR_FLAG == yes`, false},
+		{`env("FLUX_EXPR_FLAG") == yes`, true},
+		{`env("MODE") == prod`, true},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.condition)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.condition, err)
+		}
+		result, err := e.Eval(&Env{Vars: map[string]string{"MODE": "prod"}})
+		if err != nil {
+			t.Fatalf("Eval(%q) failed: %v", tt.condition, err)
+		}
+		if result != tt.expected {
+			t.Errorf("Eval(%q) = %v, expected %v", tt.condition, result, tt.expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		condition string
+		want      string
+	}{
+		{`MODE ==`, "unexpected end of expression"},
+		{`(MODE == prod`, "expected )"},
+		{`MODE == prod &&`, "unexpected end of expression"},
+		{`MODE = prod`, "unexpected character '='"},
+		{`"unterminated`, "unterminated string"},
+		{`missing("x")`, "unknown function missing"},
+		{`os("x")`, "os() takes 0 argument(s), got 1"},
+		{`VERSION =~ "("`, "invalid regular expression"},
+		{`MODE == prod prod`, "unexpected prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.condition, func(t *testing.T) {
+			_, err := Parse(tt.condition)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse(%q) error = %v, expected %q", tt.condition, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	e, err := Parse(`changed("src/*.go")`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if _, err := e.Eval(&Env{}); err == nil {
+		t.Error("Expected error without a Changed hook")
+	}
+
+	var pattern string
+	result, err := e.Eval(&Env{Changed: func(p string) (bool, error) {
+		pattern = p
+		return true, nil
+	}})
+	if err != nil || !result {
+		t.Errorf("Expected changed() to be true, got %v, %v", result, err)
+	}
+	if pattern != "src/*.go" {
+		t.Errorf("Expected pattern src/*.go, got %q", pattern)
+	}
+}
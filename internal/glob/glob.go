@@ -0,0 +1,153 @@
+package glob
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Match reports whether name matches pattern. Both use / or the OS path
+// separator. Patterns support the path.Match syntax, ** for any number of
+// directories and {a,b} alternatives.
+func Match(pattern, name string) bool {
+	name = clean(name)
+	for _, p := range expandBraces(clean(pattern)) {
+		if matchSegments(strings.Split(p, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchFile reports whether path, which is absolute, matches pattern. A
+// relative pattern is taken relative to dir.
+func MatchFile(pattern, path, dir string) bool {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	return Match(pattern, path)
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces expands {a,b} alternatives into separate patterns.
+func expandBraces(pattern string) []string {
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return []string{pattern}
+	}
+
+	depth := 0
+	var options []string
+	last := start + 1
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				options = append(options, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth == 0 {
+				options = append(options, pattern[last:i])
+				var result []string
+				for _, option := range options {
+					result = append(result, expandBraces(pattern[:start]+option+pattern[i+1:])...)
+				}
+				return result
+			}
+		}
+	}
+	return []string{pattern}
+}
+
+// Base returns the directory part of pattern that contains no wildcards.
+func Base(pattern string) string {
+	segments := strings.Split(clean(pattern), "/")
+	var static []string
+	for _, segment := range segments[:len(segments)-1] {
+		if hasMeta(segment) {
+			break
+		}
+		static = append(static, segment)
+	}
+
+	if len(static) == 0 {
+		return "."
+	}
+	if len(static) == 1 && static[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(static, "/"))
+}
+
+// Recursive reports whether pattern can match files below the
+// subdirectories of its Base.
+func Recursive(pattern string) bool {
+	segments := strings.Split(clean(pattern), "/")
+	for _, segment := range segments[:len(segments)-1] {
+		if hasMeta(segment) {
+			return true
+		}
+	}
+	return strings.Contains(segments[len(segments)-1], "**")
+}
+
+// Glob returns the files matching pattern. Unlike filepath.Glob it supports
+// ** and {a,b}.
+func Glob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") && !strings.Contains(pattern, "{") {
+		return filepath.Glob(pattern)
+	}
+
+	var matches []string
+	err := filepath.WalkDir(Base(pattern), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && Match(pattern, p) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[{")
+}
+
+func clean(p string) string {
+	p = filepath.ToSlash(p)
+	for strings.HasPrefix(p, "./") {
+		p = p[2:]
+	}
+	return p
+}
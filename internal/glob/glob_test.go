@@ -0,0 +1,92 @@
+package glob
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/flux/main.go", true},
+		{"src/**/*", "src/a/b/c.ts", true},
+		{"src/**/*", "lib/a.ts", false},
+		{"vendor/**", "vendor", true},
+		{"vendor/**", "vendor/x/y.go", true},
+		{"**/*_test.go", "internal/a_test.go", true},
+		{"**/*_test.go", "internal/a.go", false},
+		{"{*.go,*.mod}", "go.mod", true},
+		{"src/{a,b}/*.go", "src/b/x.go", true},
+		{"src/{a,b}/*.go", "src/c/x.go", false},
+		{"./src/*.go", "src/x.go", true},
+		{".git/**", ".git/objects/ab", true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, expected %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	dir := filepath.FromSlash("/work/app")
+	for pattern, want := range map[string]bool{"src/*.go": true, "./src/*.go": true, "**/*.go": true, "../app/src/*.go": true, "*.go": false} {
+		if got := MatchFile(pattern, filepath.Join(dir, "src", "main.go"), dir); got != want {
+			t.Errorf("MatchFile(%q) = %v, expected %v", pattern, got, want)
+		}
+	}
+}
+
+func TestBase(t *testing.T) {
+	tests := map[string]string{
+		"*.go":           ".",
+		"**/*.go":        ".",
+		"src/**/*.go":    "src",
+		"src/cmd/*.go":   filepath.FromSlash("src/cmd"),
+		"src/{a,b}/*.go": "src",
+		"/abs/dir/*.go":  filepath.FromSlash("/abs/dir"),
+	}
+	for pattern, want := range tests {
+		if got := Base(pattern); got != want {
+			t.Errorf("Base(%q) = %q, expected %q", pattern, got, want)
+		}
+	}
+
+	for pattern, want := range map[string]bool{"*.go": false, "src/*.go": false, "**/*.go": true, "src/*/x.go": true} {
+		if got := Recursive(pattern); got != want {
+			t.Errorf("Recursive(%q) = %v, expected %v", pattern, got, want)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "sub/b.go", "sub/deep/c.go", "sub/readme.md"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, nil, 0644)
+	}
+
+	matches, err := Glob(filepath.Join(dir, "**", "*.go"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+
+	var names []string
+	for _, m := range matches {
+		rel, _ := filepath.Rel(dir, m)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "a.go sub/b.go sub/deep/c.go" {
+		t.Errorf("Unexpected matches %v", names)
+	}
+}
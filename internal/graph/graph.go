@@ -113,7 +113,43 @@ func (g *Graph) GetDependencies(taskName string) ([]string, error) {
 	return result, nil
 }
 
+// Order returns the named tasks and everything they depend on, each once,
+// with every task after its dependencies.
+func (g *Graph) Order(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, name := range names {
+		deps, err := g.GetDependencies(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range append(deps, name) {
+			if !seen[task] {
+				seen[task] = true
+				result = append(result, task)
+			}
+		}
+	}
+	return result, nil
+}
+
+func CheckDuplicates(tasks []ast.Task) error {
+	seen := make(map[string]*ast.Task)
+	for i := range tasks {
+		task := &tasks[i]
+		if first, ok := seen[task.Name]; ok {
+			return fmt.Errorf("duplicate task %s: defined at %s and %s", task.Name, first.Location(), task.Location())
+		}
+		seen[task.Name] = task
+	}
+	return nil
+}
+
 func BuildGraph(tasks []ast.Task) (*Graph, error) {
+	if err := CheckDuplicates(tasks); err != nil {
+		return nil, err
+	}
+
 	g := New()
 	for i := range tasks {
 		g.AddTask(&tasks[i])
@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
@@ -65,3 +66,46 @@ func TestUndefinedDependency(t *testing.T) {
 		t.Error("Expected undefined dependency error")
 	}
 }
+
+func TestDuplicateTasks(t *testing.T) {
+	tasks := []ast.Task{
+		{Name: "build", File: "FluxFile", Line: 3},
+		{Name: "build", File: "ci.flux", Line: 7},
+	}
+
+	_, err := BuildGraph(tasks)
+	if err == nil {
+		t.Fatal("Expected duplicate task error")
+	}
+
+	expected := "duplicate task build: defined at FluxFile:3 and ci.flux:7"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tasks := []ast.Task{
+		{Name: "gen"},
+		{Name: "build", Deps: []string{"gen"}},
+		{Name: "lint", Deps: []string{"gen"}},
+		{Name: "test", Deps: []string{"build"}},
+	}
+
+	g, err := BuildGraph(tasks)
+	if err != nil {
+		t.Fatalf("BuildGraph error: %v", err)
+	}
+
+	order, err := g.Order([]string{"test", "lint"})
+	if err != nil {
+		t.Fatalf("Order error: %v", err)
+	}
+	if got := strings.Join(order, " "); got != "gen build test lint" {
+		t.Errorf("Expected gen build test lint, got %s", got)
+	}
+
+	if _, err := g.Order([]string{"missing"}); err == nil {
+		t.Error("Expected an error for an undefined task")
+	}
+}
@@ -0,0 +1,138 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/report"
+)
+
+// fileName is the file in the history directory that runs are appended to,
+// one JSON object per line.
+const fileName = "runs.jsonl"
+
+// Run is the record of one invocation of flux.
+type Run struct {
+	// ID is the ID of the run's task logs.
+	ID        string        `json:"id,omitempty"`
+	Target    string        `json:"target"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration_ns"`
+	Tasks     []Task        `json:"tasks"`
+}
+
+// Task is the outcome of one task in a run.
+type Task struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Duration  time.Duration `json:"duration_ns,omitempty"`
+	InputHash string        `json:"input_hash,omitempty"`
+}
+
+// FromReport returns the run that produced r.
+func FromReport(id, target string, r *report.Report) *Run {
+	run := &Run{
+		ID:        id,
+		Target:    target,
+		StartTime: r.StartTime,
+		Duration:  r.TotalTime,
+	}
+	for _, result := range r.Tasks {
+		run.Tasks = append(run.Tasks, Task{
+			Name:      result.Name,
+			Status:    result.Status,
+			Duration:  result.Duration,
+			InputHash: result.InputHash,
+		})
+	}
+	return run
+}
+
+// Append adds run to the history in dir. When the history holds more than
+// keep runs, the oldest are dropped; 0 keeps every run.
+func Append(dir string, run *Run, keep int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fileName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if keep > 0 {
+		return compact(path, keep)
+	}
+	return nil
+}
+
+// compact rewrites the history file with its last keep lines. To avoid
+// rewriting on every run, it waits until the file holds a tenth more.
+func compact(path string, keep int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := bytes.Count(data, []byte{'\n'})
+	if lines <= keep+keep/10 {
+		return nil
+	}
+
+	for drop := lines - keep; drop > 0; drop-- {
+		data = data[bytes.IndexByte(data, '\n')+1:]
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load returns the runs in the history in dir, oldest first. Lines that
+// cannot be read, such as a line cut short by a crash, are skipped.
+func Load(dir string) ([]*Run, error) {
+	f, err := os.Open(filepath.Join(dir, fileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []*Run
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err == nil {
+			runs = append(runs, &run)
+		}
+	}
+	return runs, scanner.Err()
+}
+
+// Clear removes the history in dir.
+func Clear(dir string) error {
+	err := os.Remove(filepath.Join(dir, fileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ashavijit/fluxfile/internal/report"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	start := time.Now()
+	rep := &report.Report{
+		StartTime: start,
+		TotalTime: time.Second,
+		Tasks: []report.TaskResult{
+			{Name: "build", Status: "passed", Duration: time.Second, InputHash: "abc", Stdout: "not kept"},
+		},
+	}
+
+	for i := 0; i < 12; i++ {
+		run := FromReport("run", "build", rep)
+		run.StartTime = start.Add(time.Duration(i) * time.Second)
+		if err := Append(dir, run, 5); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	f, _ := os.OpenFile(filepath.Join(dir, fileName), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"target": "cut sho`)
+	f.Close()
+
+	runs, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(runs) < 5 || len(runs) > 6 {
+		t.Fatalf("Expected the history to be cut back to about 5 runs, got %d", len(runs))
+	}
+	last := runs[len(runs)-1]
+	if !last.StartTime.Equal(start.Add(11*time.Second)) || last.Target != "build" {
+		t.Errorf("Expected the newest run last, got %+v", last)
+	}
+	if task := last.Tasks[0]; task.Name != "build" || task.Status != "passed" || task.InputHash != "abc" || task.Duration != time.Second {
+		t.Errorf("Unexpected task %+v", task)
+	}
+
+	if err := Clear(dir); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if runs, _ := Load(dir); len(runs) != 0 {
+		t.Errorf("Expected no runs after Clear, got %d", len(runs))
+	}
+}
+
+func TestTaskTrend(t *testing.T) {
+	start := time.Now()
+	var runs []*Run
+	add := func(status string, d time.Duration, hash string) {
+		runs = append(runs, &Run{
+			StartTime: start.Add(time.Duration(len(runs)) * time.Minute),
+			Tasks:     []Task{{Name: "test", Status: status, Duration: d, InputHash: hash}},
+		})
+	}
+	for i := 0; i < 4; i++ {
+		add("passed", time.Second, "a")
+	}
+	add("failed", time.Second, "a")
+	add("cached", 0, "a")
+	add("passed", 2*time.Second, "a")
+	add("failed", time.Second, "b")
+	add("passed", time.Second, "c")
+
+	trend := TaskTrend(runs, "test", 20)
+	if trend.Runs != 8 || trend.Failures != 2 || len(trend.Samples) != 9 {
+		t.Errorf("Unexpected counts %+v", trend)
+	}
+	if trend.P50 != time.Second || trend.P90 != 2*time.Second {
+		t.Errorf("Expected p50 1s and p90 2s, got %v and %v", trend.P50, trend.P90)
+	}
+	if !trend.Flaky || len(trend.FlakyInputs) != 1 || trend.FlakyInputs[0] != "a" {
+		t.Errorf("Expected only input a to be flaky, got %v", trend.FlakyInputs)
+	}
+	if trend.Regression {
+		t.Errorf("Expected no regression after getting back to 1s, got %+v", trend)
+	}
+
+	add("passed", 2*time.Second, "c")
+	trend = TaskTrend(runs, "test", 20)
+	if !trend.Regression || trend.Latest != 2*time.Second || trend.Average != 7*time.Second/6 {
+		t.Errorf("Expected a regression from about 1.17s to 2s, got %+v", trend)
+	}
+	if trend = TaskTrend(runs, "test", 100); trend.Regression {
+		t.Errorf("Expected no regression with a 100%% threshold, got %.0f%%", trend.Slowdown)
+	}
+
+	if TaskTrend(runs, "missing", 20) != nil {
+		t.Error("Expected no trend for a task that never ran")
+	}
+	if trends := Trends(runs, 20); len(trends) != 1 || trends[0].Task != "test" {
+		t.Errorf("Unexpected trends %v", trends)
+	}
+}
@@ -0,0 +1,171 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Window is the number of earlier passing runs that the moving average of
+// a task's duration covers.
+const Window = 10
+
+// minSamples is the number of earlier passing runs needed before a task
+// can be called slower than usual.
+const minSamples = 3
+
+// minSlowdown is how much slower than usual a task must get to count as a
+// regression, so that the noise in the timing of quick tasks does not.
+const minSlowdown = 100 * time.Millisecond
+
+// Sample is one execution of a task.
+type Sample struct {
+	RunID     string        `json:"run_id,omitempty"`
+	Time      time.Time     `json:"time"`
+	Status    string        `json:"status"`
+	Duration  time.Duration `json:"duration_ns,omitempty"`
+	InputHash string        `json:"input_hash,omitempty"`
+}
+
+// Trend summarizes the history of a task. Durations are those of the runs
+// that passed; cached and skipped runs did no work and count only in
+// Samples.
+type Trend struct {
+	Task        string        `json:"task"`
+	Samples     []Sample      `json:"samples"`
+	Runs        int           `json:"runs"`
+	Failures    int           `json:"failures"`
+	FailureRate float64       `json:"failure_rate"`
+	P50         time.Duration `json:"p50_ns"`
+	P90         time.Duration `json:"p90_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	// Latest is the duration of the last passing run and Average the
+	// moving average of the runs that passed before it.
+	Latest  time.Duration `json:"latest_ns"`
+	Average time.Duration `json:"average_ns"`
+	// Flaky is set when the task both passed and failed, back and forth,
+	// on the same inputs, which are listed in FlakyInputs.
+	Flaky       bool     `json:"flaky"`
+	FlakyInputs []string `json:"flaky_inputs,omitempty"`
+	// Regression is set when Latest is more than the threshold, and at
+	// least 100ms, slower than Average; Slowdown is by how many percent.
+	Regression bool    `json:"regression"`
+	Slowdown   float64 `json:"slowdown_percent"`
+}
+
+// TaskTrend returns the trend of task in runs, or nil when it never ran.
+// threshold is the percentage by which a task must be slower than its
+// moving average to count as a regression.
+func TaskTrend(runs []*Run, task string, threshold int) *Trend {
+	trend := &Trend{Task: task}
+	for _, run := range runs {
+		for _, t := range run.Tasks {
+			if t.Name == task {
+				trend.Samples = append(trend.Samples, Sample{
+					RunID:     run.ID,
+					Time:      run.StartTime,
+					Status:    t.Status,
+					Duration:  t.Duration,
+					InputHash: t.InputHash,
+				})
+			}
+		}
+	}
+	if len(trend.Samples) == 0 {
+		return nil
+	}
+	sort.SliceStable(trend.Samples, func(i, j int) bool {
+		return trend.Samples[i].Time.Before(trend.Samples[j].Time)
+	})
+
+	var passed []time.Duration
+	outcomes := make(map[string][]string)
+	for _, s := range trend.Samples {
+		switch s.Status {
+		case "passed":
+			passed = append(passed, s.Duration)
+		case "failed":
+			trend.Failures++
+		default:
+			continue
+		}
+		trend.Runs++
+		if s.InputHash != "" {
+			outcomes[s.InputHash] = append(outcomes[s.InputHash], s.Status)
+		}
+	}
+	if trend.Runs > 0 {
+		trend.FailureRate = float64(trend.Failures) / float64(trend.Runs)
+	}
+
+	if len(passed) > 0 {
+		sorted := append([]time.Duration(nil), passed...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		trend.P50 = percentile(sorted, 0.50)
+		trend.P90 = percentile(sorted, 0.90)
+		trend.P99 = percentile(sorted, 0.99)
+
+		last := len(passed) - 1
+		trend.Latest = passed[last]
+		earlier := passed[max(0, last-Window):last]
+		if len(earlier) > 0 {
+			var sum time.Duration
+			for _, d := range earlier {
+				sum += d
+			}
+			trend.Average = sum / time.Duration(len(earlier))
+		}
+		if len(earlier) >= minSamples && trend.Average > 0 {
+			trend.Slowdown = (float64(trend.Latest)/float64(trend.Average) - 1) * 100
+			trend.Regression = trend.Slowdown > float64(threshold) && trend.Latest-trend.Average >= minSlowdown
+		}
+	}
+
+	// Passing, failing and passing again, or the other way round, on the
+	// same inputs is flaky. A single change may be the environment or a
+	// fix outside the inputs.
+	for hash, statuses := range outcomes {
+		flips := 0
+		for i := 1; i < len(statuses); i++ {
+			if statuses[i] != statuses[i-1] {
+				flips++
+			}
+		}
+		if flips >= 2 {
+			trend.Flaky = true
+			trend.FlakyInputs = append(trend.FlakyInputs, hash)
+		}
+	}
+	sort.Strings(trend.FlakyInputs)
+	return trend
+}
+
+// Trends returns the trend of every task in runs, sorted by task name.
+func Trends(runs []*Run, threshold int) []*Trend {
+	seen := make(map[string]bool)
+	var names []string
+	for _, run := range runs {
+		for _, t := range run.Tasks {
+			if !seen[t.Name] {
+				seen[t.Name] = true
+				names = append(names, t.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	trends := make([]*Trend, 0, len(names))
+	for _, name := range names {
+		trends = append(trends, TaskTrend(runs, name, threshold))
+	}
+	return trends
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
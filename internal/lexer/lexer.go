@@ -14,6 +14,7 @@ type Lexer struct {
 	column        int
 	indentStack   []int
 	pendingDedent int
+	lineOffsets   []int
 }
 
 func New(input string) *Lexer {
@@ -214,6 +215,55 @@ func (l *Lexer) Tokenize() []Token {
 	return tokens
 }
 
+// Line returns the raw source text of the 1-based line n without its line
+// terminator. Commands are read from the source rather than rebuilt from
+// tokens so that they reach the shell verbatim.
+func (l *Lexer) Line(n int) string {
+	offsets := l.offsets()
+	if n < 1 || n > len(offsets) {
+		return ""
+	}
+
+	end := len(l.input)
+	if n < len(offsets) {
+		end = offsets[n]
+	}
+	text := strings.TrimSuffix(l.input[offsets[n-1]:end], "\n")
+	return strings.TrimSuffix(text, "\r")
+}
+
+// LineCount returns the number of lines in the source.
+func (l *Lexer) LineCount() int {
+	return len(l.offsets())
+}
+
+// SkipToLine continues lexing at the start of line n. The skipped lines are
+// not tokenized, so their indentation does not affect the indent stack.
+func (l *Lexer) SkipToLine(n int) {
+	offsets := l.offsets()
+	offset := len(l.input)
+	if n >= 1 && n <= len(offsets) {
+		offset = offsets[n-1]
+	}
+
+	l.readPosition = offset
+	l.line = n
+	l.column = 0
+	l.readChar()
+}
+
+func (l *Lexer) offsets() []int {
+	if l.lineOffsets == nil {
+		l.lineOffsets = []int{0}
+		for i := 0; i < len(l.input); i++ {
+			if l.input[i] == '\n' && i+1 < len(l.input) {
+				l.lineOffsets = append(l.lineOffsets, i+1)
+			}
+		}
+	}
+	return l.lineOffsets
+}
+
 func (l *Lexer) IsAtLineStart() bool {
 	return l.column == 1
 }
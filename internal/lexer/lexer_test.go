@@ -63,3 +63,27 @@ func TestIndentation(t *testing.T) {
 		t.Error("Expected INDENT tokens")
 	}
 }
+
+func TestLineAndSkipToLine(t *testing.T) {
+	input := "task a:\r\n    run: |\n        echo \"unterminated\n    cache: true\n"
+
+	l := New(input)
+	if l.LineCount() != 4 {
+		t.Fatalf("Expected 4 lines, got %d", l.LineCount())
+	}
+	if got := l.Line(1); got != "task a:" {
+		t.Errorf("Expected line without terminator, got %q", got)
+	}
+	if got := l.Line(3); got != "        echo \"unterminated" {
+		t.Errorf("Unexpected line 3: %q", got)
+	}
+
+	l.SkipToLine(4)
+	tok := l.NextToken()
+	if tok.Type != INDENT || tok.Line != 4 {
+		t.Fatalf("Expected INDENT on line 4, got %s on line %d", tok.Type, tok.Line)
+	}
+	if tok = l.NextToken(); tok.Type != CACHE {
+		t.Errorf("Expected CACHE, got %s", tok.Type)
+	}
+}
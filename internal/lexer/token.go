@@ -48,6 +48,10 @@ const (
 	TIMEOUT
 	PROMPT
 	NOTIFY
+	TEMPLATE
+	EXTENDS
+	DOTENV
+	RESTART
 
 	SHELL
 	DOLLAR
@@ -92,6 +96,15 @@ type Token struct {
 	Column  int
 }
 
+// directives are keywords only where a declaration or directive starts.
+// They lex as IDENT, so tasks and deps can still use them as names.
+var directives = map[string]TokenType{
+	"template": TEMPLATE,
+	"extends":  EXTENDS,
+	"dotenv":   DOTENV,
+	"restart":  RESTART,
+}
+
 func LookupIdent(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok
@@ -99,6 +112,17 @@ func LookupIdent(ident string) TokenType {
 	return IDENT
 }
 
+// LookupDirective returns the keyword type of tok when it is a contextual
+// keyword such as template, and the type of tok otherwise.
+func LookupDirective(tok Token) TokenType {
+	if tok.Type == IDENT {
+		if typ, ok := directives[tok.Literal]; ok {
+			return typ
+		}
+	}
+	return tok.Type
+}
+
 func (t TokenType) String() string {
 	switch t {
 	case ILLEGAL:
@@ -181,6 +205,14 @@ func (t TokenType) String() string {
 		return "PROMPT"
 	case NOTIFY:
 		return "NOTIFY"
+	case TEMPLATE:
+		return "TEMPLATE"
+	case EXTENDS:
+		return "EXTENDS"
+	case DOTENV:
+		return "DOTENV"
+	case RESTART:
+		return "RESTART"
 	case SHELL:
 		return "SHELL"
 	case DOLLAR:
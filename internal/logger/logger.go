@@ -2,63 +2,109 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
+
+	"github.com/ashavijit/fluxfile/internal/secrets"
 )
 
 type Logger struct {
 	verbose bool
+	quiet   bool
+	masker  *secrets.Masker
+	stdout  io.Writer
+	stderr  io.Writer
+	// prefix starts command echoes and output lines, as in [lint].
+	prefix string
 }
 
 func New() *Logger {
-	return &Logger{verbose: true}
+	return &Logger{verbose: true, stdout: os.Stdout, stderr: os.Stderr}
+}
+
+// WithPrefix returns a copy of l that starts command echoes and output
+// lines with prefix instead of indenting them.
+func (l *Logger) WithPrefix(prefix string) *Logger {
+	c := *l
+	c.prefix = prefix
+	return &c
+}
+
+// WithOutput returns a copy of l that writes to stdout and stderr.
+func (l *Logger) WithOutput(stdout, stderr io.Writer) *Logger {
+	c := *l
+	c.stdout, c.stderr = stdout, stderr
+	return &c
 }
 
 func (l *Logger) SetVerbose(v bool) {
 	l.verbose = v
 }
 
+// SetVerbosity applies a quiet, normal or verbose level. Quiet hides info
+// messages and command echoes.
+func (l *Logger) SetVerbosity(level string) {
+	l.quiet = level == "quiet"
+	l.verbose = !l.quiet
+}
+
+// SetMasker redacts the secret values known to m from everything logged.
+func (l *Logger) SetMasker(m *secrets.Masker) {
+	l.masker = m
+}
+
 func (l *Logger) Info(msg string) {
-	fmt.Printf("[\033[34mINFO\033[0m] %s\n", msg)
+	if l.quiet {
+		return
+	}
+	fmt.Fprintf(l.stdout, "[\033[34mINFO\033[0m] %s\n", l.masker.Mask(msg))
 }
 
 func (l *Logger) Warn(msg string) {
-	fmt.Printf("[\033[33mWARN\033[0m] %s\n", msg)
+	fmt.Fprintf(l.stdout, "[\033[33mWARN\033[0m] %s\n", l.masker.Mask(msg))
 }
 
 func (l *Logger) Error(msg string) {
-	fmt.Fprintf(os.Stderr, "[\033[31mERROR\033[0m] %s\n", msg)
+	fmt.Fprintf(l.stderr, "[\033[31mERROR\033[0m] %s\n", l.masker.Mask(msg))
 }
 
 func (l *Logger) TaskStart(name string) {
-	fmt.Printf("[\033[36m→\033[0m] Running task: \033[1m%s\033[0m\n", name)
+	fmt.Fprintf(l.stdout, "[\033[36m→\033[0m] Running task: \033[1m%s\033[0m\n", name)
 }
 
 func (l *Logger) TaskComplete(name string, duration time.Duration) {
-	fmt.Printf("[\033[32m✓\033[0m] Task \033[1m%s\033[0m completed in %v\n", name, duration)
+	fmt.Fprintf(l.stdout, "[\033[32m✓\033[0m] Task \033[1m%s\033[0m completed in %v\n", name, duration)
 }
 
 func (l *Logger) TaskFailed(name string, err error) {
-	fmt.Fprintf(os.Stderr, "[\033[31m✗\033[0m] Task \033[1m%s\033[0m failed: %v\n", name, err)
+	fmt.Fprintf(l.stderr, "[\033[31m✗\033[0m] Task \033[1m%s\033[0m failed: %s\n", name, l.masker.Mask(fmt.Sprint(err)))
 }
 
 func (l *Logger) TaskCached(name string) {
-	fmt.Printf("[\033[35m⚡\033[0m] Task \033[1m%s\033[0m (cached)\n", name)
+	fmt.Fprintf(l.stdout, "[\033[35m⚡\033[0m] Task \033[1m%s\033[0m (cached)\n", name)
 }
 
 func (l *Logger) Command(cmd string) {
 	if l.verbose {
 		timestamp := time.Now().Format("15:04:05")
-		fmt.Printf("  \033[90m[%s] $\033[0m %s\n", timestamp, cmd)
+		fmt.Fprintf(l.stdout, "%s\033[90m[%s] $\033[0m %s\n", l.indent(), timestamp, l.masker.Mask(cmd))
 	}
 }
 
 func (l *Logger) Stdout(line string) {
-	fmt.Println("  " + line)
+	fmt.Fprintln(l.stdout, l.indent()+l.masker.Mask(line))
 }
 
 func (l *Logger) Stderr(line string) {
-	fmt.Fprintln(os.Stderr, "  "+line)
+	fmt.Fprintln(l.stderr, l.indent()+l.masker.Mask(line))
+}
+
+func (l *Logger) indent() string {
+	if l.prefix != "" {
+		return l.prefix + " "
+	}
+	return "  "
 }
 
 func (l *Logger) Fatal(msg string) {
@@ -126,6 +126,43 @@ const htmlTemplate = `<!DOCTYPE html>
         }
         .toggle-btn:hover { background: #21262d; color: #c9d1d9; }
         
+        .timeline {
+            background: #161b22;
+            border: 1px solid #30363d;
+            border-radius: 8px;
+            padding: 16px;
+            margin-bottom: 24px;
+        }
+        .timeline-header { display: flex; justify-content: space-between; margin-bottom: 12px; font-size: 13px; }
+        .timeline-row { display: flex; align-items: center; height: 24px; }
+        .timeline-label {
+            width: 200px;
+            flex-shrink: 0;
+            font-size: 13px;
+            overflow: hidden;
+            text-overflow: ellipsis;
+            white-space: nowrap;
+            padding-right: 12px;
+        }
+        .timeline-track { position: relative; flex: 1; height: 16px; background: #0d1117; border-radius: 3px; }
+        .bar { position: absolute; top: 0; height: 100%; min-width: 2px; border-radius: 3px; }
+        .bar-success { background: #238636; }
+        .bar-failed { background: #da3633; }
+        .bar-running { background: #d29922; }
+        .bar-cached { background: #1f6feb; }
+        .bar-wait { background: repeating-linear-gradient(45deg, #30363d, #30363d 4px, #21262d 4px, #21262d 8px); }
+        .timeline-legend { display: flex; gap: 16px; margin-top: 12px; font-size: 12px; color: #8b949e; }
+        .timeline-legend span::before {
+            content: "";
+            display: inline-block;
+            width: 10px;
+            height: 10px;
+            margin-right: 6px;
+            border-radius: 2px;
+            vertical-align: middle;
+            background: var(--c);
+        }
+
         .empty-state {
             text-align: center;
             padding: 80px 20px;
@@ -158,6 +195,31 @@ const htmlTemplate = `<!DOCTYPE html>
             </div>
         </div>
         
+        {{range .Timelines}}
+        <div class="timeline">
+            <div class="timeline-header">
+                <span class="task-name">Run <span class="mono">{{.ID}}</span></span>
+                <span class="mono muted">{{.Duration}}</span>
+            </div>
+            {{range .Bars}}
+            <div class="timeline-row">
+                <div class="timeline-label"><span class="mono muted">w{{.Worker}}</span> {{.Task}}</div>
+                <div class="timeline-track">
+                    {{if .Wait}}<div class="bar bar-wait" style="left: 0; width: {{percent .Wait}}" title="waited {{.WaitMs}}ms for dependencies"></div>{{end}}
+                    <div class="bar bar-{{.Status}}" style="left: {{percent .Left}}; width: {{percent .Width}}" title="{{.Title}}"></div>
+                </div>
+            </div>
+            {{end}}
+            <div class="timeline-legend">
+                <span style="--c: #238636">success</span>
+                <span style="--c: #da3633">failed</span>
+                <span style="--c: #1f6feb">cached</span>
+                <span style="--c: #d29922">running</span>
+                <span style="--c: #30363d">waiting on dependencies</span>
+            </div>
+        </div>
+        {{end}}
+
         <table>
             <thead>
                 <tr>
@@ -246,6 +308,105 @@ type HTMLData struct {
 	TotalTasks   int
 	SuccessCount int
 	FailedCount  int
+	Timelines    []Timeline
+}
+
+// maxTimelines is the number of most recent runs drawn as a timeline.
+const maxTimelines = 5
+
+// Timeline is a Gantt chart of the tasks of one run. Bar positions are
+// fractions of the run's duration.
+type Timeline struct {
+	ID       string
+	Duration string
+	Bars     []TimelineBar
+}
+
+type TimelineBar struct {
+	Task   string
+	Status string
+	Worker int
+	Left   float64
+	Width  float64
+	Wait   float64
+	WaitMs int64
+	Title  string
+}
+
+// buildTimelines returns the timelines of the latest runs in logs, newest
+// first. Logs written before runs were recorded have no run and are left
+// out.
+func buildTimelines(logs []*TaskLog) []Timeline {
+	runs := make(map[string][]*TaskLog)
+	var ids []string
+	for _, log := range logs {
+		if log.RunID == "" {
+			continue
+		}
+		if _, ok := runs[log.RunID]; !ok {
+			ids = append(ids, log.RunID)
+		}
+		runs[log.RunID] = append(runs[log.RunID], log)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	if len(ids) > maxTimelines {
+		ids = ids[:maxTimelines]
+	}
+
+	var timelines []Timeline
+	for _, id := range ids {
+		tasks := runs[id]
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return tasks[i].StartTime.Before(tasks[j].StartTime)
+		})
+
+		start, end := tasks[0].StartTime, tasks[0].StartTime
+		for _, task := range tasks {
+			if taskEnd := task.end(); taskEnd.After(end) {
+				end = taskEnd
+			}
+		}
+		total := end.Sub(start)
+		if total <= 0 {
+			total = time.Millisecond
+		}
+
+		timeline := Timeline{ID: id, Duration: formatDuration(total)}
+		for _, task := range tasks {
+			status := task.Status
+			if task.CacheHit {
+				status = "cached"
+			}
+			d := task.end().Sub(task.StartTime)
+			timeline.Bars = append(timeline.Bars, TimelineBar{
+				Task:   task.TaskName,
+				Status: status,
+				Worker: task.Worker,
+				Left:   float64(task.StartTime.Sub(start)) / float64(total),
+				Width:  float64(d) / float64(total),
+				Wait:   float64(time.Duration(task.WaitMs)*time.Millisecond) / float64(total),
+				WaitMs: task.WaitMs,
+				Title:  fmt.Sprintf("%s: %s in %s", task.TaskName, status, formatDuration(d)),
+			})
+		}
+		timelines = append(timelines, timeline)
+	}
+	return timelines
+}
+
+// end returns when the task ended, or now while it is still running.
+func (l *TaskLog) end() time.Time {
+	if l.EndTime.IsZero() {
+		return time.Now()
+	}
+	return l.EndTime
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return fmt.Sprintf("%.2fs", d.Seconds())
 }
 
 func GenerateHTML(logs []*TaskLog) (string, error) {
@@ -257,6 +418,7 @@ func GenerateHTML(logs []*TaskLog) (string, error) {
 	data := HTMLData{
 		Tasks:      logs,
 		TotalTasks: len(logs),
+		Timelines:  buildTimelines(logs),
 	}
 
 	for _, log := range logs {
@@ -270,11 +432,10 @@ func GenerateHTML(logs []*TaskLog) (string, error) {
 
 	funcs := template.FuncMap{
 		"duration": func(start, end time.Time) string {
-			d := end.Sub(start)
-			if d < time.Second {
-				return fmt.Sprintf("%dms", d.Milliseconds())
-			}
-			return fmt.Sprintf("%.2fs", d.Seconds())
+			return formatDuration(end.Sub(start))
+		},
+		"percent": func(f float64) string {
+			return fmt.Sprintf("%.2f%%", f*100)
 		},
 	}
 
@@ -1,12 +1,19 @@
 package logs
 
 import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/ashavijit/fluxfile/internal/secrets"
 )
 
 type LogEntry struct {
@@ -21,150 +28,298 @@ type LogEntry struct {
 }
 
 type TaskLog struct {
-	TaskName  string     `json:"task_name"`
-	StartTime time.Time  `json:"start_time"`
-	EndTime   time.Time  `json:"end_time,omitempty"`
-	Status    string     `json:"status"`
-	WorkDir   string     `json:"work_dir,omitempty"`
-	Profile   string     `json:"profile,omitempty"`
-	CacheHit  bool       `json:"cache_hit,omitempty"`
-	DepsCount int        `json:"deps_count,omitempty"`
-	Error     string     `json:"error,omitempty"`
-	Entries   []LogEntry `json:"entries"`
+	RunID     string    `json:"run_id,omitempty"`
+	TaskName  string    `json:"task_name"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time,omitempty"`
+	Status    string    `json:"status"`
+	WorkDir   string    `json:"work_dir,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	CacheHit  bool      `json:"cache_hit,omitempty"`
+	DepsCount int       `json:"deps_count,omitempty"`
+	// Worker is the lane the task ran on and WaitMs how long it was
+	// blocked on its dependencies.
+	Worker  int        `json:"worker,omitempty"`
+	WaitMs  int64      `json:"wait_ms,omitempty"`
+	Error   string     `json:"error,omitempty"`
+	Entries []LogEntry `json:"entries"`
 }
 
+// LogStore groups the task logs of one run in a directory of their own.
+// Each task execution logs through its own TaskHandle, so tasks running in
+// parallel never write to each other's logs.
 type LogStore struct {
-	mu      sync.RWMutex
-	dir     string
-	tasks   map[string]*TaskLog
-	current string
+	mu     sync.Mutex
+	dir    string
+	runID  string
+	masker *secrets.Masker
+	files  map[string]int
+	tasks  []*TaskHandle
 }
 
+// NewLogStore starts a new run, creates its directory below dir and prunes
+// the runs that are past the retention limits set with SetRetention.
 func NewLogStore(dir string) (*LogStore, error) {
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	runID, err := newRunID()
+	if err != nil {
 		return nil, err
 	}
+	if err := os.MkdirAll(filepath.Join(dir, runID), 0755); err != nil {
+		return nil, err
+	}
+	// Pruning is best effort; it must not keep tasks from running.
+	_, _ = prune(dir, retainRuns, retainMaxAge, runID)
 	return &LogStore{
-		dir:   dir,
-		tasks: make(map[string]*TaskLog),
+		dir:   filepath.Join(dir, runID),
+		runID: runID,
+		files: make(map[string]int),
 	}, nil
 }
 
-func (s *LogStore) StartTask(name string) {
+const runIDLayout = "20060102-150405"
+
+// newRunID returns an ID that sorts by start time and stays unique across
+// invocations started within the same second.
+func newRunID() (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return time.Now().Format(runIDLayout) + "-" + hex.EncodeToString(suffix), nil
+}
+
+// RunID returns the ID of the run, which is also the name of its directory.
+func (s *LogStore) RunID() string {
+	return s.runID
+}
+
+// Dir returns the directory of the run.
+func (s *LogStore) Dir() string {
+	return s.dir
+}
+
+// SetMasker redacts the secret values known to m from logged messages,
+// commands, output and errors.
+func (s *LogStore) SetMasker(m *secrets.Masker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.masker = m
+}
+
+// StartTask starts the log of one execution of a task and returns the
+// handle to log through. The log is written to <task>.jsonl in the run
+// directory as it goes. StartTask returns nil, which discards everything
+// logged to it, when s is nil or the file cannot be created.
+func (s *LogStore) StartTask(name string) *TaskHandle {
+	if s == nil {
+		return nil
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.current = name
-	s.tasks[name] = &TaskLog{
-		TaskName:  name,
-		StartTime: time.Now(),
-		Status:    "running",
-		Entries:   make([]LogEntry, 0),
+	base := strings.ReplaceAll(name, ":", "_")
+	s.files[base]++
+	if n := s.files[base]; n > 1 {
+		base = fmt.Sprintf("%s.%d", base, n)
 	}
+	file, err := os.OpenFile(filepath.Join(s.dir, base+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil
+	}
+
+	h := &TaskHandle{
+		file:   file,
+		masker: s.masker,
+		log: &TaskLog{
+			RunID:     s.runID,
+			TaskName:  name,
+			StartTime: time.Now(),
+			Status:    "running",
+			Entries:   make([]LogEntry, 0),
+		},
+	}
+	h.writeTask()
+	s.tasks = append(s.tasks, h)
+	return h
 }
 
-func (s *LogStore) Log(level, message string) {
+// GetAllTasks returns the logs of the tasks started in this run.
+func (s *LogStore) GetAllTasks() []*TaskLog {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.current == "" {
-		return
+	result := make([]*TaskLog, 0, len(s.tasks))
+	for _, h := range s.tasks {
+		result = append(result, h.snapshot())
 	}
+	return result
+}
 
-	task := s.tasks[s.current]
-	if task == nil {
+// TaskHandle logs one execution of a task. It is safe for concurrent use,
+// and all of its methods do nothing on a nil handle.
+type TaskHandle struct {
+	mu     sync.Mutex
+	file   *os.File
+	masker *secrets.Masker
+	log    *TaskLog
+}
+
+func (h *TaskHandle) Log(level, message string) {
+	if h == nil {
 		return
 	}
+	h.add(LogEntry{
+		Level:   level,
+		Message: h.masker.Mask(message),
+	})
+}
 
-	task.Entries = append(task.Entries, LogEntry{
-		Timestamp: time.Now(),
-		Level:     level,
-		Task:      s.current,
-		Message:   message,
+func (h *TaskHandle) LogCommand(command string, duration time.Duration) {
+	if h == nil {
+		return
+	}
+	h.add(LogEntry{
+		Level:    "cmd",
+		Command:  h.masker.Mask(command),
+		Duration: duration.Milliseconds(),
 	})
 }
 
-func (s *LogStore) LogCommand(command string, duration time.Duration) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (h *TaskHandle) LogCommandWithOutput(command string, duration time.Duration, exitCode int, output string) {
+	if h == nil {
+		return
+	}
+	h.add(LogEntry{
+		Level:    "cmd",
+		Command:  h.masker.Mask(command),
+		Duration: duration.Milliseconds(),
+		ExitCode: exitCode,
+		Output:   h.masker.Mask(output),
+	})
+}
 
-	if s.current == "" {
+func (h *TaskHandle) SetTaskInfo(workDir, profile string, depsCount int) {
+	if h == nil {
 		return
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.log.WorkDir = workDir
+	h.log.Profile = profile
+	h.log.DepsCount = depsCount
+}
 
-	task := s.tasks[s.current]
-	if task == nil {
+func (h *TaskHandle) SetCacheHit(hit bool) {
+	if h == nil {
 		return
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.log.CacheHit = hit
+}
 
-	task.Entries = append(task.Entries, LogEntry{
-		Timestamp: time.Now(),
-		Level:     "cmd",
-		Task:      s.current,
-		Command:   command,
-		Duration:  duration.Milliseconds(),
-	})
+// SetSchedule records the worker the task runs on and how long it waited
+// for its dependencies.
+func (h *TaskHandle) SetSchedule(worker int, wait time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.log.Worker = worker
+	h.log.WaitMs = wait.Milliseconds()
 }
 
-func (s *LogStore) EndTask(name string, success bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (h *TaskHandle) SetError(err string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.log.Error = h.masker.Mask(err)
+}
 
-	task := s.tasks[name]
-	if task == nil {
+// End records the outcome of the task and closes its log file. Entries
+// logged after End are dropped.
+func (h *TaskHandle) End(success bool) {
+	if h == nil {
 		return
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	task.EndTime = time.Now()
+	if h.file == nil {
+		return
+	}
+	h.log.EndTime = time.Now()
 	if success {
-		task.Status = "success"
+		h.log.Status = "success"
 	} else {
-		task.Status = "failed"
+		h.log.Status = "failed"
 	}
+	h.writeTask()
+	h.file.Close()
+	h.file = nil
 }
 
-func (s *LogStore) Save() error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	for name, task := range s.tasks {
-		data, err := json.MarshalIndent(task, "", "  ")
-		if err != nil {
-			return err
-		}
-
-		filename := fmt.Sprintf("%s_%s.json",
-			name,
-			task.StartTime.Format("20060102_150405"))
-		path := filepath.Join(s.dir, filename)
+func (h *TaskHandle) add(entry LogEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-		if err := os.WriteFile(path, data, 0644); err != nil {
-			return err
-		}
+	if h.file == nil {
+		return
 	}
-
-	return nil
+	entry.Timestamp = time.Now()
+	entry.Task = h.log.TaskName
+	h.log.Entries = append(h.log.Entries, entry)
+	h.write(Record{Entry: &entry})
 }
 
-func (s *LogStore) GetAllTasks() []*TaskLog {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// writeTask writes the task's details without its entries, which were
+// written as they were logged.
+func (h *TaskHandle) writeTask() {
+	task := *h.log
+	task.Entries = nil
+	h.write(Record{Task: &task})
+}
 
-	result := make([]*TaskLog, 0, len(s.tasks))
-	for _, task := range s.tasks {
-		result = append(result, task)
+func (h *TaskHandle) write(r Record) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
 	}
-	return result
+	_, _ = h.file.Write(append(data, '\n'))
+}
+
+func (h *TaskHandle) snapshot() *TaskLog {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	task := *h.log
+	task.Entries = append([]LogEntry{}, h.log.Entries...)
+	return &task
 }
 
+// LoadLogs reads the task logs of all runs in dir, along with logs saved as
+// single JSON files by earlier versions, ordered by start time.
 func LoadLogs(dir string) ([]*TaskLog, error) {
-	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.jsonl"))
 	if err != nil {
 		return nil, err
 	}
 
 	logs := make([]*TaskLog, 0, len(files))
 	for _, file := range files {
+		if log, err := loadTaskLog(file); err == nil {
+			logs = append(logs, log)
+		}
+	}
+
+	legacy, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range legacy {
 		data, err := os.ReadFile(file)
 		if err != nil {
 			continue
@@ -177,11 +332,59 @@ func LoadLogs(dir string) ([]*TaskLog, error) {
 		logs = append(logs, &log)
 	}
 
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].StartTime.Before(logs[j].StartTime)
+	})
 	return logs, nil
 }
 
+// loadTaskLog reads a task log file. A task that is still running, or was
+// killed, has only its first task record.
+func loadTaskLog(path string) (*TaskLog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var log *TaskLog
+	var entries []LogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// The last line may be partly written.
+			continue
+		}
+		if r.Task != nil {
+			log = r.Task
+		}
+		if r.Entry != nil {
+			entries = append(entries, *r.Entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if log == nil {
+		return nil, fmt.Errorf("%s: no task record", path)
+	}
+	log.Entries = append(make([]LogEntry, 0, len(entries)), entries...)
+	return log, nil
+}
+
+var logDir = filepath.Join(".flux", "logs")
+
+// SetLogDir changes the directory returned by GetLogDir.
+func SetLogDir(dir string) {
+	if dir != "" {
+		logDir = dir
+	}
+}
+
 func GetLogDir() string {
-	return filepath.Join(".flux", "logs")
+	return logDir
 }
 
 func ClearLogs() (int, error) {
@@ -198,79 +401,24 @@ func ClearLogs() (int, error) {
 		}
 	}
 
-	// Also remove HTML file
-	htmlPath := filepath.Join(dir, "logs.html")
-	os.Remove(htmlPath)
-
-	return count, nil
-}
-
-func (s *LogStore) SetTaskInfo(workDir, profile string, depsCount int) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.current == "" {
-		return
-	}
-
-	task := s.tasks[s.current]
-	if task == nil {
-		return
-	}
-
-	task.WorkDir = workDir
-	task.Profile = profile
-	task.DepsCount = depsCount
-}
-
-func (s *LogStore) SetCacheHit(hit bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.current == "" {
-		return
-	}
-
-	task := s.tasks[s.current]
-	if task != nil {
-		task.CacheHit = hit
-	}
-}
-
-func (s *LogStore) SetError(err string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.current == "" {
-		return
+	runFiles, err := filepath.Glob(filepath.Join(dir, "*", "*.jsonl"))
+	if err != nil {
+		return count, err
 	}
-
-	task := s.tasks[s.current]
-	if task != nil {
-		task.Error = err
+	runs := make(map[string]bool)
+	for _, file := range runFiles {
+		runs[filepath.Dir(file)] = true
+		count++
 	}
-}
-
-func (s *LogStore) LogCommandWithOutput(command string, duration time.Duration, exitCode int, output string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.current == "" {
-		return
+	for run := range runs {
+		if err := os.RemoveAll(run); err != nil {
+			return count, err
+		}
 	}
 
-	task := s.tasks[s.current]
-	if task == nil {
-		return
-	}
+	// Also remove HTML file
+	htmlPath := filepath.Join(dir, "logs.html")
+	os.Remove(htmlPath)
 
-	task.Entries = append(task.Entries, LogEntry{
-		Timestamp: time.Now(),
-		Level:     "cmd",
-		Task:      s.current,
-		Command:   command,
-		Duration:  duration.Milliseconds(),
-		ExitCode:  exitCode,
-		Output:    output,
-	})
+	return count, nil
 }
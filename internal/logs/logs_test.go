@@ -3,8 +3,12 @@ package logs
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/ashavijit/fluxfile/internal/secrets"
 )
 
 func TestLogStore(t *testing.T) {
@@ -14,14 +18,15 @@ func TestLogStore(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	store.StartTask("build")
-	store.Log("info", "Starting build")
-	store.LogCommand("go build ./...", 500*time.Millisecond)
-	store.EndTask("build", true)
+	log := store.StartTask("build")
+	log.Log("info", "Starting build")
+	log.LogCommand("go build ./...", 500*time.Millisecond)
+	log.End(true)
+	log.Log("info", "Dropped after End")
 
 	tasks := store.GetAllTasks()
 	if len(tasks) != 1 {
-		t.Errorf("Expected 1 task, got %d", len(tasks))
+		t.Fatalf("Expected 1 task, got %d", len(tasks))
 	}
 
 	task := tasks[0]
@@ -31,9 +36,15 @@ func TestLogStore(t *testing.T) {
 	if task.Status != "success" {
 		t.Errorf("Expected status 'success', got '%s'", task.Status)
 	}
+	if task.RunID != store.RunID() {
+		t.Errorf("Expected run ID %s, got %s", store.RunID(), task.RunID)
+	}
 	if len(task.Entries) != 2 {
 		t.Errorf("Expected 2 entries, got %d", len(task.Entries))
 	}
+
+	var nilStore *LogStore
+	nilStore.StartTask("build").Log("info", "discarded")
 }
 
 func TestSaveAndLoad(t *testing.T) {
@@ -43,28 +54,102 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	store.StartTask("test")
-	store.Log("info", "Running tests")
-	store.EndTask("test", true)
+	test := store.StartTask("test")
+	test.Log("info", "Running tests")
+
+	// Entries are on disk while the task runs.
+	logs, err := LoadLogs(dir)
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("Expected 1 loaded log, got %d, %v", len(logs), err)
+	}
+	if logs[0].Status != "running" || len(logs[0].Entries) != 1 {
+		t.Errorf("Expected a running task with 1 entry, got %s with %d", logs[0].Status, len(logs[0].Entries))
+	}
+
+	test.SetError("1 test failed")
+	test.End(false)
+	again := store.StartTask("test")
+	again.End(true)
 
-	if err := store.Save(); err != nil {
+	files, err := filepath.Glob(filepath.Join(dir, store.RunID(), "*.jsonl"))
+	if err != nil {
 		t.Fatal(err)
 	}
+	if len(files) != 2 {
+		t.Errorf("Expected 2 log files, got %d", len(files))
+	}
 
-	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	legacy := `{"task_name": "old", "status": "success", "entries": []}`
+	if err := os.WriteFile(filepath.Join(dir, "old_20240101_120000.json"), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err = LoadLogs(dir)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(files) != 1 {
-		t.Errorf("Expected 1 log file, got %d", len(files))
+	if len(logs) != 3 {
+		t.Fatalf("Expected 3 loaded logs, got %d", len(logs))
+	}
+	if logs[0].TaskName != "old" {
+		t.Errorf("Expected logs ordered by start time, got %s first", logs[0].TaskName)
+	}
+	failed := 0
+	for _, log := range logs {
+		if log.Status == "failed" && log.Error == "1 test failed" && !log.EndTime.IsZero() {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("Expected the failure to be loaded, got %+v", logs)
 	}
 
-	logs, err := LoadLogs(dir)
+	SetLogDir(dir)
+	defer SetLogDir(filepath.Join(".flux", "logs"))
+	count, err := ClearLogs()
+	if err != nil || count != 3 {
+		t.Errorf("Expected 3 logs cleared, got %d, %v", count, err)
+	}
+	if _, err := os.Stat(store.Dir()); !os.IsNotExist(err) {
+		t.Errorf("Expected the run directory to be removed, got %v", err)
+	}
+}
+
+func TestConcurrentTaskLogs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLogStore(dir)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(logs) != 1 {
-		t.Errorf("Expected 1 loaded log, got %d", len(logs))
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			log := store.StartTask(name)
+			for i := 0; i < 50; i++ {
+				log.Log("info", name)
+			}
+			log.End(true)
+		}(name)
+	}
+	wg.Wait()
+
+	logs, err := LoadLogs(dir)
+	if err != nil || len(logs) != 4 {
+		t.Fatalf("Expected 4 loaded logs, got %d, %v", len(logs), err)
+	}
+	for _, log := range logs {
+		if len(log.Entries) != 50 {
+			t.Errorf("Expected 50 entries for %s, got %d", log.TaskName, len(log.Entries))
+		}
+		for _, entry := range log.Entries {
+			if entry.Message != log.TaskName {
+				t.Errorf("Found %q in the log of %s", entry.Message, log.TaskName)
+				break
+			}
+		}
 	}
 }
 
@@ -96,3 +181,125 @@ func TestGenerateHTML(t *testing.T) {
 		t.Error("Expected non-empty path")
 	}
 }
+
+func TestTimeline(t *testing.T) {
+	SetLogDir(t.TempDir())
+	start := time.Now().Add(-time.Minute)
+	logs := []*TaskLog{
+		{RunID: "20240101-120000-aaaaaa", TaskName: "test", Status: "success", Worker: 1,
+			StartTime: start, EndTime: start.Add(2 * time.Second)},
+		{RunID: "20240101-120000-aaaaaa", TaskName: "build", Status: "failed", Worker: 1, WaitMs: 2000,
+			StartTime: start.Add(2 * time.Second), EndTime: start.Add(4 * time.Second)},
+		{TaskName: "legacy", Status: "success", StartTime: start, EndTime: start},
+	}
+
+	timelines := buildTimelines(logs)
+	if len(timelines) != 1 || len(timelines[0].Bars) != 2 {
+		t.Fatalf("Expected one timeline of two tasks, got %+v", timelines)
+	}
+	build := timelines[0].Bars[1]
+	if build.Task != "build" || build.Left != 0.5 || build.Width != 0.5 || build.Wait != 0.5 {
+		t.Errorf("Unexpected bar %+v", build)
+	}
+
+	path, err := GenerateHTML(logs)
+	if err != nil {
+		t.Fatalf("GenerateHTML() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"20240101-120000-aaaaaa", "bar bar-failed", "left: 50.00%; width: 50.00%"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected the HTML to contain %q", want)
+		}
+	}
+}
+
+func TestLogStoreMasksSecrets(t *testing.T) {
+	store, err := NewLogStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	masker := secrets.NewMasker()
+	masker.Add("hunter2")
+	store.SetMasker(masker)
+
+	log := store.StartTask("deploy")
+	log.Log("info", "password is hunter2")
+	log.LogCommandWithOutput("login -p hunter2", time.Second, 1, "bad password hunter2")
+	log.SetError("login hunter2 failed")
+
+	task := store.GetAllTasks()[0]
+	for _, s := range []string{task.Entries[0].Message, task.Entries[1].Command, task.Entries[1].Output, task.Error} {
+		if strings.Contains(s, "hunter2") {
+			t.Errorf("Expected secret to be masked in %q", s)
+		}
+	}
+}
+
+func TestRuns(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.StartTask("build").End(true)
+
+	second, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := second.StartTask("build")
+	test := second.StartTask("test")
+	build.End(true)
+
+	tail := NewTail(second.Dir())
+	records, err := tail.Read()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d, %v", len(records), err)
+	}
+	test.Log("error", "1 failed")
+	test.End(false)
+	records, _ = tail.Read()
+	if len(records) != 2 || records[0].Entry == nil || records[1].Task == nil || records[1].Task.Status != "failed" {
+		t.Errorf("Expected the new entry and end record, got %+v", records)
+	}
+
+	runs, err := ListRuns(dir)
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d, %v", len(runs), err)
+	}
+	if runs[0].ID != first.RunID() || runs[0].Status != "success" {
+		t.Errorf("Unexpected first run %+v", runs[0])
+	}
+	if runs[1].Status != "failed" || len(runs[1].Tasks) != 2 {
+		t.Errorf("Unexpected second run %+v", runs[1])
+	}
+
+	// Run IDs carry their start time, so an old run can be faked.
+	old := filepath.Join(dir, "20200101-000000-abcdef")
+	os.MkdirAll(old, 0755)
+	os.WriteFile(filepath.Join(old, "build.jsonl"), nil, 0644)
+
+	if count, err := PruneRuns(dir, 0, 24*time.Hour); err != nil || count != 1 {
+		t.Errorf("Expected the old run to be pruned, got %d, %v", count, err)
+	}
+	if count, err := PruneRuns(dir, 1, 0); err != nil || count != 1 {
+		t.Errorf("Expected 1 run to be pruned, got %d, %v", count, err)
+	}
+	if runs, _ := ListRuns(dir); len(runs) != 1 {
+		t.Errorf("Expected 1 run to be kept, got %d", len(runs))
+	}
+
+	SetRetention(1, 0)
+	defer SetRetention(50, 0)
+	third, err := NewLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs, _ := filepath.Glob(filepath.Join(dir, "*")); len(runs) != 1 {
+		t.Errorf("Expected a new run to prune the previous one, got %v", runs)
+	}
+	if _, err := os.Stat(third.Dir()); err != nil {
+		t.Errorf("Expected the new run to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Run is the set of task logs written by one invocation of flux.
+type Run struct {
+	ID        string     `json:"id"`
+	Dir       string     `json:"-"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   time.Time  `json:"end_time,omitempty"`
+	Status    string     `json:"status"`
+	Tasks     []*TaskLog `json:"tasks"`
+}
+
+// Duration returns how long the run took, or has taken so far.
+func (r *Run) Duration() time.Duration {
+	if r.Status == "running" || r.EndTime.IsZero() {
+		return time.Since(r.StartTime)
+	}
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// ListRuns returns the runs in dir, oldest first.
+func ListRuns(dir string) ([]*Run, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*Run
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		run, err := LoadRun(dir, entry.Name())
+		if err != nil || len(run.Tasks) == 0 {
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartTime.Before(runs[j].StartTime)
+	})
+	return runs, nil
+}
+
+// LoadRun reads the run with the given ID. A run is running while one of
+// its tasks is, and failed when one of its tasks failed.
+func LoadRun(dir, id string) (*Run, error) {
+	runDir := filepath.Join(dir, id)
+	files, err := filepath.Glob(filepath.Join(runDir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("run %s not found", id)
+	}
+
+	run := &Run{ID: id, Dir: runDir, Status: "success"}
+	for _, file := range files {
+		task, err := loadTaskLog(file)
+		if err != nil {
+			continue
+		}
+		run.Tasks = append(run.Tasks, task)
+
+		if run.StartTime.IsZero() || task.StartTime.Before(run.StartTime) {
+			run.StartTime = task.StartTime
+		}
+		if task.EndTime.After(run.EndTime) {
+			run.EndTime = task.EndTime
+		}
+		switch {
+		case task.Status == "running":
+			run.Status = "running"
+		case task.Status == "failed" && run.Status != "running":
+			run.Status = "failed"
+		}
+	}
+
+	sort.SliceStable(run.Tasks, func(i, j int) bool {
+		return run.Tasks[i].StartTime.Before(run.Tasks[j].StartTime)
+	})
+	return run, nil
+}
+
+var (
+	retainRuns   = 50
+	retainMaxAge time.Duration
+)
+
+// SetRetention sets how many runs are kept and how old they may get before
+// each new run prunes them. 0 disables either limit.
+func SetRetention(keep int, maxAge time.Duration) {
+	retainRuns = keep
+	retainMaxAge = maxAge
+}
+
+// PruneRuns removes all but the newest keep runs in dir, and the runs that
+// started more than maxAge ago. 0 disables either limit. It returns the
+// number of runs removed.
+func PruneRuns(dir string, keep int, maxAge time.Duration) (int, error) {
+	return prune(dir, keep, maxAge, "")
+}
+
+// prune is PruneRuns that treats the run current as the newest and never
+// removes it.
+func prune(dir string, keep int, maxAge time.Duration, current string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Run IDs start with the time the run started, so they sort by age.
+	type run struct {
+		id    string
+		start time.Time
+	}
+	var runs []run
+	for _, entry := range entries {
+		if start, ok := runStart(entry.Name()); ok && entry.IsDir() {
+			runs = append(runs, run{entry.Name(), start})
+		}
+	}
+	// Runs started within the same second sort in no particular order.
+	sort.Slice(runs, func(i, j int) bool {
+		if (runs[i].id == current) != (runs[j].id == current) {
+			return runs[j].id == current
+		}
+		return runs[i].id < runs[j].id
+	})
+
+	count := 0
+	for i, run := range runs {
+		old := keep > 0 && i < len(runs)-keep
+		expired := maxAge > 0 && time.Since(run.start) > maxAge
+		if run.id == current || (!old && !expired) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, run.id)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// runStart returns the start time encoded in a run ID.
+func runStart(id string) (time.Time, bool) {
+	if len(id) < len(runIDLayout) {
+		return time.Time{}, false
+	}
+	start, err := time.ParseInLocation(runIDLayout, id[:len(runIDLayout)], time.Local)
+	return start, err == nil
+}
+
+// Record is one line of a task log file. Task records hold the task's
+// details; the first is written when the task starts and the last when it
+// ends. Entry records hold the entries logged in between.
+type Record struct {
+	Task  *TaskLog  `json:"task,omitempty"`
+	Entry *LogEntry `json:"entry,omitempty"`
+}
+
+// Tail reads the records appended to the task logs of a run, including
+// the logs of tasks that start later.
+type Tail struct {
+	dir     string
+	offsets map[string]int64
+}
+
+func NewTail(runDir string) *Tail {
+	return &Tail{dir: runDir, offsets: make(map[string]int64)}
+}
+
+// Read returns the records written since the previous call, ordered by
+// time. Partly written lines are left for the next call.
+func (t *Tail) Read() ([]Record, error) {
+	files, err := filepath.Glob(filepath.Join(t.dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, path := range files {
+		read, err := t.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, read...)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].time().Before(records[j].time())
+	})
+	return records, nil
+}
+
+func (t *Tail) readFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(t.offsets[path], io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	t.offsets[path] += int64(end + 1)
+
+	var records []Record
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err == nil {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (r Record) time() time.Time {
+	switch {
+	case r.Entry != nil:
+		return r.Entry.Timestamp
+	case r.Task != nil && !r.Task.EndTime.IsZero():
+		return r.Task.EndTime
+	case r.Task != nil:
+		return r.Task.StartTime
+	}
+	return time.Time{}
+}
@@ -0,0 +1,513 @@
+package lsp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/lexer"
+	"github.com/ashavijit/fluxfile/internal/parser"
+)
+
+var varRefPattern = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_-]*)(?:\}|:[-?])`)
+
+type symbol struct {
+	Name    string
+	Kind    string
+	URI     string
+	Line    int
+	Col     int
+	EndLine int
+}
+
+type reference struct {
+	Name string
+	Kind string
+	Line int
+	Col  int
+}
+
+type include struct {
+	Path      string
+	Namespace string
+	Line      int
+	Col       int
+}
+
+type analysis struct {
+	uri       string
+	path      string
+	namespace string
+	lines     []string
+	file      *ast.FluxFile
+	errors    []parser.Error
+	symbols   []symbol
+	refs      []reference
+	includes  []include
+	included  []*analysis
+}
+
+func analyze(uri, path, text string) *analysis {
+	return analyzeWithVisited(uri, path, text, map[string]bool{})
+}
+
+func analyzeWithVisited(uri, path, text string, visited map[string]bool) *analysis {
+	a := &analysis{
+		uri:   uri,
+		path:  path,
+		lines: strings.Split(text, "\n"),
+	}
+
+	p := parser.New(lexer.New(text))
+	a.file, _ = p.Parse()
+	a.errors = p.Errors()
+
+	a.scanTokens(lexer.New(text).Tokenize())
+	a.scanVarRefs()
+
+	if path != "" {
+		visited[path] = true
+		for _, inc := range a.includes {
+			incPath := filepath.Join(filepath.Dir(path), inc.Path)
+			if visited[incPath] {
+				continue
+			}
+			data, err := os.ReadFile(incPath)
+			if err != nil {
+				continue
+			}
+			included := analyzeWithVisited(pathToURI(incPath), incPath, string(data), visited)
+			included.namespace = inc.Namespace
+			a.included = append(a.included, included)
+		}
+	}
+
+	return a
+}
+
+func (a *analysis) scanTokens(tokens []lexer.Token) {
+	block := ""
+	for i, tok := range tokens {
+		next := func(offset int) lexer.Token {
+			if i+offset < len(tokens) {
+				return tokens[i+offset]
+			}
+			return lexer.Token{Type: lexer.EOF}
+		}
+
+		// Contextual keywords such as template only start declarations
+		// and directives.
+		typ := tok.Type
+		if i == 0 || tokens[i-1].Type == lexer.NEWLINE || tokens[i-1].Type == lexer.INDENT || tokens[i-1].Type == lexer.DEDENT {
+			typ = lexer.LookupDirective(tok)
+		}
+
+		switch typ {
+		case lexer.TASK, lexer.TEMPLATE, lexer.PROFILE, lexer.VAR:
+			if next(1).Type != lexer.IDENT {
+				continue
+			}
+			name := next(1)
+			if tok.Column != 1 {
+				// task overrides inside a profile refer to existing tasks
+				if typ == lexer.TASK && block == "profile" {
+					a.refs = append(a.refs, reference{
+						Name: name.Literal,
+						Kind: "task",
+						Line: name.Line - 1,
+						Col:  name.Column - 1,
+					})
+				}
+				continue
+			}
+			kind := map[lexer.TokenType]string{
+				lexer.TASK:     "task",
+				lexer.TEMPLATE: "template",
+				lexer.PROFILE:  "profile",
+				lexer.VAR:      "var",
+			}[typ]
+			block = kind
+			a.symbols = append(a.symbols, symbol{
+				Name: name.Literal,
+				Kind: kind,
+				URI:  a.uri,
+				Line: name.Line - 1,
+				Col:  name.Column - 1,
+			})
+		case lexer.INCLUDE:
+			if tok.Column != 1 || next(1).Type != lexer.STRING {
+				continue
+			}
+			inc := include{
+				Path: next(1).Literal,
+				Line: next(1).Line - 1,
+				Col:  next(1).Column - 1,
+			}
+			if next(2).Type == lexer.IDENT && next(2).Literal == "as" && next(3).Type == lexer.IDENT {
+				inc.Namespace = next(3).Literal
+			}
+			a.includes = append(a.includes, inc)
+		case lexer.DEPS, lexer.EXTENDS:
+			if next(1).Type != lexer.COLON {
+				continue
+			}
+			kind := "task"
+			if typ == lexer.EXTENDS {
+				kind = "extends"
+				if block == "profile" {
+					kind = "profile"
+				}
+			}
+			for j := i + 2; j < len(tokens) && tokens[j].Line == tok.Line; j++ {
+				if tokens[j].Type != lexer.IDENT {
+					continue
+				}
+				ref := reference{
+					Name: tokens[j].Literal,
+					Kind: kind,
+					Line: tokens[j].Line - 1,
+					Col:  tokens[j].Column - 1,
+				}
+				for j+2 < len(tokens) && tokens[j+1].Type == lexer.COLON && tokens[j+2].Type == lexer.IDENT &&
+					tokens[j+2].Line == tok.Line {
+					ref.Name += ":" + tokens[j+2].Literal
+					j += 2
+				}
+				a.refs = append(a.refs, ref)
+			}
+		case lexer.PROFILE_TASK:
+			val := next(2)
+			if next(1).Type != lexer.COLON || (val.Type != lexer.IDENT && val.Type != lexer.STRING) {
+				continue
+			}
+			col := val.Column - 1
+			if val.Type == lexer.STRING {
+				col++
+			}
+			a.refs = append(a.refs, reference{
+				Name: val.Literal,
+				Kind: "profile",
+				Line: val.Line - 1,
+				Col:  col,
+			})
+		}
+	}
+
+	sort.SliceStable(a.symbols, func(i, j int) bool {
+		return a.symbols[i].Line < a.symbols[j].Line
+	})
+	for i := range a.symbols {
+		end := len(a.lines) - 1
+		if i+1 < len(a.symbols) {
+			end = a.symbols[i+1].Line - 1
+		}
+		for end > a.symbols[i].Line && strings.TrimSpace(a.lines[end]) == "" {
+			end--
+		}
+		a.symbols[i].EndLine = end
+	}
+}
+
+func (a *analysis) scanVarRefs() {
+	for line, text := range a.lines {
+		for _, m := range varRefPattern.FindAllStringSubmatchIndex(text, -1) {
+			a.refs = append(a.refs, reference{
+				Name: text[m[2]:m[3]],
+				Kind: "var",
+				Line: line,
+				Col:  m[2],
+			})
+		}
+	}
+}
+
+// allSymbols returns the symbols of the document followed by those of its
+// includes, so lookups prefer local definitions. Tasks of namespaced
+// includes are returned under their qualified names.
+func (a *analysis) allSymbols() []symbol {
+	result := append([]symbol{}, a.symbols...)
+	for _, inc := range a.included {
+		for _, sym := range inc.allSymbols() {
+			if inc.namespace != "" && (sym.Kind == "task" || sym.Kind == "template") {
+				sym.Name = inc.namespace + ":" + sym.Name
+			}
+			result = append(result, sym)
+		}
+	}
+	return result
+}
+
+func (a *analysis) lookup(kind, name string) (symbol, bool) {
+	if kind == "extends" {
+		if sym, ok := a.lookup("template", name); ok {
+			return sym, true
+		}
+		return a.lookup("task", name)
+	}
+
+	for _, sym := range a.allSymbols() {
+		if sym.Kind == kind && sym.Name == name {
+			return sym, true
+		}
+	}
+	return symbol{}, false
+}
+
+func (a *analysis) names(kind string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, sym := range a.allSymbols() {
+		if sym.Kind == kind && !seen[sym.Name] {
+			seen[sym.Name] = true
+			result = append(result, sym.Name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// findTask returns the task or template with the given name.
+func (a *analysis) findTask(name string) *ast.Task {
+	if a.file != nil {
+		for i := range a.file.Templates {
+			if a.file.Templates[i].Name == name {
+				return &a.file.Templates[i]
+			}
+		}
+		for i := range a.file.Tasks {
+			if a.file.Tasks[i].Name == name {
+				return &a.file.Tasks[i]
+			}
+		}
+	}
+	for _, inc := range a.included {
+		local := name
+		if inc.namespace != "" {
+			if !strings.HasPrefix(name, inc.namespace+":") {
+				continue
+			}
+			local = strings.TrimPrefix(name, inc.namespace+":")
+		}
+		if task := inc.findTask(local); task != nil {
+			return task
+		}
+	}
+	return nil
+}
+
+func (a *analysis) diagnostics() []Diagnostic {
+	diags := []Diagnostic{}
+
+	for _, e := range a.errors {
+		line := e.Line - 1
+		if line < 0 {
+			line = 0
+		}
+		col := e.Column - 1
+		if col < 0 {
+			col = 0
+		}
+		diags = append(diags, Diagnostic{
+			Range:    Range{Start: Position{line, col}, End: Position{line, a.lineLength(line)}},
+			Severity: severityError,
+			Source:   "flux",
+			Message:  e.Message,
+		})
+	}
+
+	for _, inc := range a.includes {
+		incPath := filepath.Join(filepath.Dir(a.path), inc.Path)
+		if _, err := os.Stat(incPath); a.path != "" && err != nil {
+			diags = append(diags, a.refDiagnostic(inc.Line, inc.Col, len(inc.Path)+2, severityError,
+				fmt.Sprintf("included file %s not found", inc.Path)))
+		}
+	}
+
+	for _, ref := range a.refs {
+		switch ref.Kind {
+		case "task":
+			if _, ok := a.lookup("task", ref.Name); !ok {
+				diags = append(diags, a.refDiagnostic(ref.Line, ref.Col, len(ref.Name), severityError,
+					fmt.Sprintf("undefined task %s", ref.Name)))
+			}
+		case "extends":
+			if _, ok := a.lookup("extends", ref.Name); !ok {
+				diags = append(diags, a.refDiagnostic(ref.Line, ref.Col, len(ref.Name), severityError,
+					fmt.Sprintf("undefined task or template %s", ref.Name)))
+			}
+		case "profile":
+			if _, ok := a.lookup("profile", ref.Name); !ok {
+				diags = append(diags, a.refDiagnostic(ref.Line, ref.Col, len(ref.Name), severityWarning,
+					fmt.Sprintf("undefined profile %s", ref.Name)))
+			}
+		}
+	}
+
+	seen := make(map[string]symbol)
+	for _, sym := range a.allSymbols() {
+		if sym.Kind != "task" {
+			continue
+		}
+		first, ok := seen[sym.Name]
+		if !ok {
+			seen[sym.Name] = sym
+			continue
+		}
+		switch {
+		case sym.URI == a.uri:
+			diags = append(diags, a.refDiagnostic(sym.Line, sym.Col, len(sym.Name), severityError,
+				fmt.Sprintf("duplicate task %s: also defined at %s:%d", sym.Name, uriToPath(first.URI), first.Line+1)))
+		case first.URI == a.uri:
+			diags = append(diags, a.refDiagnostic(first.Line, first.Col, len(first.Name), severityError,
+				fmt.Sprintf("duplicate task %s: also defined at %s:%d", sym.Name, uriToPath(sym.URI), sym.Line+1)))
+		}
+	}
+
+	if cycle := a.findCycle(); len(cycle) > 0 {
+		if sym, ok := a.lookup("task", cycle[0]); ok && sym.URI == a.uri {
+			diags = append(diags, a.refDiagnostic(sym.Line, sym.Col, len(sym.Name), severityError,
+				fmt.Sprintf("circular dependency detected: %s", strings.Join(cycle, " -> "))))
+		}
+	}
+
+	return diags
+}
+
+func (a *analysis) refDiagnostic(line, col, length, severity int, msg string) Diagnostic {
+	return Diagnostic{
+		Range:    Range{Start: Position{line, col}, End: Position{line, col + length}},
+		Severity: severity,
+		Source:   "flux",
+		Message:  msg,
+	}
+}
+
+func (a *analysis) findCycle() []string {
+	deps := make(map[string][]string)
+	var owner string
+	for _, sym := range a.symbols {
+		if sym.Kind == "task" {
+			deps[sym.Name] = nil
+		}
+	}
+	for _, ref := range a.refs {
+		if ref.Kind != "task" {
+			continue
+		}
+		owner = ""
+		for _, sym := range a.symbols {
+			if sym.Kind == "task" && sym.Line <= ref.Line && ref.Line <= sym.EndLine {
+				owner = sym.Name
+			}
+		}
+		if owner != "" {
+			deps[owner] = append(deps[owner], ref.Name)
+		}
+	}
+
+	state := make(map[string]int)
+	var stack []string
+	var cycle []string
+
+	var visit func(string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case 1:
+			for i, n := range stack {
+				if n == name {
+					cycle = append(append([]string{}, stack[i:]...), name)
+				}
+			}
+			return true
+		case 2:
+			return false
+		}
+		state[name] = 1
+		stack = append(stack, name)
+		for _, dep := range deps[name] {
+			if visit(dep) {
+				return true
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = 2
+		return false
+	}
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if visit(name) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func (a *analysis) lineLength(line int) int {
+	if line < 0 || line >= len(a.lines) {
+		return 0
+	}
+	return len(strings.TrimRight(a.lines[line], "\r"))
+}
+
+func (a *analysis) symbolAt(pos Position) (symbol, bool) {
+	for _, sym := range a.symbols {
+		if sym.Line == pos.Line && sym.Col <= pos.Character && pos.Character <= sym.Col+len(sym.Name) {
+			return sym, true
+		}
+	}
+	return symbol{}, false
+}
+
+func (a *analysis) refAt(pos Position) (reference, bool) {
+	for _, ref := range a.refs {
+		if ref.Line == pos.Line && ref.Col <= pos.Character && pos.Character <= ref.Col+len(ref.Name) {
+			return ref, true
+		}
+	}
+	return reference{}, false
+}
+
+func (a *analysis) includeAt(pos Position) (include, bool) {
+	for _, inc := range a.includes {
+		if inc.Line == pos.Line && inc.Col <= pos.Character && pos.Character <= inc.Col+len(inc.Path)+2 {
+			return inc, true
+		}
+	}
+	return include{}, false
+}
+
+// enclosingBlock returns the top-level keyword ("task", "template",
+// "profile" or "")
+// of the block containing the given line, and the indentation of the
+// directives inside that block.
+func (a *analysis) enclosingBlock(line int) (string, int) {
+	for i := line; i >= 0 && i < len(a.lines); i-- {
+		text := a.lines[i]
+		if strings.TrimSpace(text) == "" || lexer.CountIndent(text) > 0 {
+			continue
+		}
+		if i == line {
+			return "", 0
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 || (fields[0] != "task" && fields[0] != "template" && fields[0] != "profile") {
+			return "", 0
+		}
+		for j := i + 1; j <= line; j++ {
+			if strings.TrimSpace(a.lines[j]) != "" || j == line {
+				return fields[0], lexer.CountIndent(a.lines[j])
+			}
+		}
+		return fields[0], 0
+	}
+	return "", 0
+}
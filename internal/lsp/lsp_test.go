@@ -0,0 +1,297 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFluxFile = `var PROJECT = flux
+
+task build:
+    desc: Build the binary
+    run:
+        go build -o bin/${PROJECT}
+
+task test:
+    deps: build
+    profile_task: dev
+    run:
+        go test ./...
+
+profile dev:
+    env:
+        MODE = development
+`
+
+func TestDocumentSymbols(t *testing.T) {
+	a := analyze("file:///FluxFile", "", testFluxFile)
+	doc := &document{analysis: a}
+
+	symbols := doc.documentSymbols()
+	if len(symbols) != 4 {
+		t.Fatalf("Expected 4 symbols, got %d", len(symbols))
+	}
+
+	if symbols[1].Name != "build" || symbols[1].Kind != symbolKindFunction {
+		t.Errorf("Expected task build, got %+v", symbols[1])
+	}
+	if symbols[1].Detail != "Build the binary" {
+		t.Errorf("Expected desc as detail, got %q", symbols[1].Detail)
+	}
+	if symbols[1].Range.Start.Line != 2 || symbols[1].Range.End.Line != 5 {
+		t.Errorf("Unexpected range for build: %+v", symbols[1].Range)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	input := `task build:
+    deps: missing
+    run:
+        echo build
+
+task a:
+    deps: b
+
+task b:
+    deps: a
+`
+	a := analyze("file:///FluxFile", "", input)
+	diags := a.diagnostics()
+
+	var messages []string
+	for _, d := range diags {
+		messages = append(messages, d.Message)
+	}
+	joined := strings.Join(messages, "\n")
+
+	if !strings.Contains(joined, "undefined task missing") {
+		t.Errorf("Expected undefined task diagnostic, got %v", messages)
+	}
+	if !strings.Contains(joined, "circular dependency detected: a -> b -> a") {
+		t.Errorf("Expected cycle diagnostic, got %v", messages)
+	}
+
+	for _, d := range diags {
+		if d.Message == "undefined task missing" {
+			if d.Range.Start.Line != 1 || d.Range.Start.Character != 10 {
+				t.Errorf("Unexpected range %+v", d.Range)
+			}
+		}
+	}
+}
+
+func TestParseErrorDiagnostic(t *testing.T) {
+	a := analyze("file:///FluxFile", "", "var = oops\n")
+	diags := a.diagnostics()
+	if len(diags) == 0 {
+		t.Fatal("Expected parse error diagnostic")
+	}
+	if diags[0].Severity != severityError || diags[0].Range.Start.Line != 0 {
+		t.Errorf("Unexpected diagnostic %+v", diags[0])
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		pos      Position
+		contains string
+		excludes string
+	}{
+		{
+			name:     "deps completes task names",
+			input:    testFluxFile,
+			pos:      Position{8, 10},
+			contains: "build",
+			excludes: "test",
+		},
+		{
+			name:     "var reference",
+			input:    testFluxFile + "task x:\n    run:\n        echo ${\n",
+			pos:      Position{18, 15},
+			contains: "PROJECT",
+		},
+		{
+			name:     "profile names",
+			input:    testFluxFile,
+			pos:      Position{9, 18},
+			contains: "dev",
+		},
+		{
+			name:     "task directives",
+			input:    "task x:\n    re\n",
+			pos:      Position{1, 6},
+			contains: "retries",
+			excludes: "include",
+		},
+		{
+			name:     "top level keywords",
+			input:    "ta\n",
+			pos:      Position{0, 2},
+			contains: "task",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{analysis: analyze("file:///FluxFile", "", tt.input)}
+			labels := make(map[string]bool)
+			for _, item := range doc.completion(tt.pos) {
+				labels[item.Label] = true
+			}
+			if !labels[tt.contains] {
+				t.Errorf("Expected %q in completion, got %v", tt.contains, labels)
+			}
+			if tt.excludes != "" && labels[tt.excludes] {
+				t.Errorf("Did not expect %q in completion", tt.excludes)
+			}
+		})
+	}
+}
+
+func TestDefinitionAcrossInclude(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ci.flux"), []byte("task lint:\n    run:\n        echo lint\n"), 0644)
+
+	input := "include \"ci.flux\"\n\ntask build:\n    deps: lint\n"
+	path := filepath.Join(dir, "FluxFile")
+	doc := &document{path: path, analysis: analyze(pathToURI(path), path, input)}
+
+	locs := doc.definition(Position{3, 11})
+	if len(locs) != 1 {
+		t.Fatalf("Expected 1 location, got %d", len(locs))
+	}
+	if locs[0].URI != pathToURI(filepath.Join(dir, "ci.flux")) {
+		t.Errorf("Unexpected URI %s", locs[0].URI)
+	}
+	if locs[0].Range.Start.Line != 0 || locs[0].Range.Start.Character != 5 {
+		t.Errorf("Unexpected range %+v", locs[0].Range)
+	}
+
+	locs = doc.definition(Position{0, 10})
+	if len(locs) != 1 || locs[0].URI != pathToURI(filepath.Join(dir, "ci.flux")) {
+		t.Errorf("Expected include definition, got %+v", locs)
+	}
+
+	if diags := doc.analysis.diagnostics(); len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %+v", diags)
+	}
+}
+
+func TestHover(t *testing.T) {
+	doc := &document{analysis: analyze("file:///FluxFile", "", testFluxFile)}
+
+	hover := doc.hover(Position{8, 11})
+	if hover == nil {
+		t.Fatal("Expected hover for dep")
+	}
+	if !strings.Contains(hover.Contents.Value, "Build the binary") {
+		t.Errorf("Expected desc in hover, got %q", hover.Contents.Value)
+	}
+	if !strings.Contains(hover.Contents.Value, "go build -o bin/flux") {
+		t.Errorf("Expected resolved command in hover, got %q", hover.Contents.Value)
+	}
+
+	hover = doc.hover(Position{5, 27})
+	if hover == nil || !strings.Contains(hover.Contents.Value, "var PROJECT") {
+		t.Errorf("Expected var hover, got %+v", hover)
+	}
+}
+
+func TestServerSession(t *testing.T) {
+	var in bytes.Buffer
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if id > 0 {
+			msg["id"] = id
+		}
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	uri := "file:///tmp/FluxFile"
+	send(1, "initialize", map[string]interface{}{})
+	send(0, "initialized", map[string]interface{}{})
+	send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "text": "task a:\n    deps: nope\n"},
+	})
+	send(2, "textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+	})
+	send(3, "unknown/method", map[string]interface{}{})
+	send(4, "shutdown", nil)
+	send(0, "exit", nil)
+
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	r := bufio.NewReader(&out)
+	var messages []map[string]interface{}
+	for {
+		req, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		raw, _ := json.Marshal(req)
+		var generic map[string]interface{}
+		json.Unmarshal(raw, &generic)
+		messages = append(messages, generic)
+	}
+
+	if len(messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(messages))
+	}
+	if messages[1]["method"] != "textDocument/publishDiagnostics" {
+		t.Errorf("Expected diagnostics notification, got %v", messages[1])
+	}
+}
+
+func TestNamespacedIncludeSymbols(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ci.flux"), []byte("task lint:\n    run:\n        echo lint\n"), 0644)
+
+	input := "include \"ci.flux\" as ci\n\ntask build:\n    deps: ci:lint\n"
+	path := filepath.Join(dir, "FluxFile")
+	doc := &document{path: path, analysis: analyze(pathToURI(path), path, input)}
+
+	if diags := doc.analysis.diagnostics(); len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %+v", diags)
+	}
+
+	locs := doc.definition(Position{3, 14})
+	if len(locs) != 1 || locs[0].URI != pathToURI(filepath.Join(dir, "ci.flux")) {
+		t.Errorf("Expected definition in ci.flux, got %+v", locs)
+	}
+}
+
+func TestKeywordsAsNames(t *testing.T) {
+	input := `template base:
+    run:
+        echo base
+
+task template:
+    extends: base
+
+task all:
+    deps: template
+`
+	a := analyze("file:///FluxFile", "", input)
+	if diags := a.diagnostics(); len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %+v", diags)
+	}
+	if sym, ok := a.lookup("task", "template"); !ok || sym.Line != 4 {
+		t.Errorf("Expected task template on line 4, got %+v", sym)
+	}
+	if _, ok := a.lookup("template", "base"); !ok {
+		t.Error("Expected template base")
+	}
+}
@@ -0,0 +1,501 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/ashavijit/fluxfile/internal/ast"
+	"github.com/ashavijit/fluxfile/internal/lexer"
+	"github.com/ashavijit/fluxfile/internal/vars"
+)
+
+var taskDirectives = map[string]string{
+	"desc":         "Task description",
+	"deps":         "Tasks to run before this task",
+	"parallel":     "Run dependencies in parallel",
+	"if":           "Run only when the condition holds",
+	"run":          "Commands to execute",
+	"env":          "Environment variables for the task",
+	"watch":        "Glob pattern to watch in watch mode",
+	"ignore":       "Patterns to ignore in watch mode",
+	"matrix":       "Matrix of variable combinations",
+	"cache":        "Cache results based on inputs",
+	"inputs":       "Files that invalidate the cache",
+	"outputs":      "Files produced by the task",
+	"docker":       "Run the task in Docker",
+	"remote":       "Run the task over SSH",
+	"profile_task": "Profile applied when the task runs",
+	"secrets":      "Secrets read from the environment, .env or a provider (NAME = pass:path); masked in output",
+	"pre":          "Preconditions checked before running",
+	"retries":      "Number of attempts on failure",
+	"retry_delay":  "Delay between retries",
+	"timeout":      "Maximum task duration",
+	"prompt":       "Confirmation prompt before running",
+	"notify":       "Desktop notification on success or failure",
+	"extends":      "Inherit directives from a template or task",
+	"shell":        "Interpreter for run commands (bash, zsh, python, ...)",
+	"dotenv":       "Dotenv files loaded for the task",
+	"restart":      "In watch mode, stop the running process before re-running",
+}
+
+var profileDirectives = map[string]string{
+	"env":     "Environment variables applied by the profile",
+	"var":     "Variable set by the profile",
+	"extends": "Apply another profile first",
+	"dotenv":  "Dotenv files loaded while the profile is active",
+	"task":    "Override directives of a task while the profile is active",
+}
+
+var topLevelKeywords = map[string]string{
+	"task":     "Declare a task",
+	"template": "Declare a reusable task template",
+	"var":      "Declare a variable",
+	"profile":  "Declare a profile",
+	"include":  "Include another FluxFile",
+	"dotenv":   "Dotenv files loaded for every task",
+}
+
+type document struct {
+	uri      string
+	path     string
+	analysis *analysis
+	lastFile *ast.FluxFile
+}
+
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+func (s *Server) Run() error {
+	for {
+		req, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if rerr, ok := err.(*responseError); ok {
+			if werr := s.reply(nil, nil, rerr); werr != nil {
+				return werr
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit received before shutdown")
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(req)
+		if req.ID == nil {
+			continue
+		}
+		if err := s.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": 1,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{" ", ",", "{", ":"},
+				},
+				"definitionProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "flux"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		_ = s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []Diagnostic{},
+		})
+		return nil, nil
+	case "textDocument/completion", "textDocument/definition", "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return nil, nil
+		}
+		switch req.Method {
+		case "textDocument/completion":
+			return doc.completion(params.Position), nil
+		case "textDocument/definition":
+			return doc.definition(params.Position), nil
+		default:
+			return doc.hover(params.Position), nil
+		}
+	case "textDocument/documentSymbol":
+		var params textDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return []DocumentSymbol{}, nil
+		}
+		return doc.documentSymbols(), nil
+	}
+
+	if req.ID == nil {
+		return nil, nil
+	}
+	return nil, &responseError{Code: errMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
+}
+
+func (s *Server) update(uri, text string) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		doc = &document{uri: uri, path: uriToPath(uri)}
+		s.docs[uri] = doc
+	}
+
+	doc.analysis = analyze(uri, doc.path, text)
+	if doc.analysis.file != nil {
+		doc.lastFile = doc.analysis.file
+	} else {
+		doc.analysis.file = doc.lastFile
+	}
+
+	_ = s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.analysis.diagnostics(),
+	})
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	resp := response{JSONRPC: "2.0", ID: id, Result: result}
+	if rerr != nil {
+		resp.Result = nil
+		resp.Error = rerr
+	}
+	return writeMessage(s.out, resp)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: errInvalidParams, Message: err.Error()}
+}
+
+func (d *document) completion(pos Position) []CompletionItem {
+	a := d.analysis
+	items := []CompletionItem{}
+	if pos.Line < 0 || pos.Line >= len(a.lines) {
+		return items
+	}
+
+	text := a.lines[pos.Line]
+	if pos.Character < len(text) {
+		text = text[:pos.Character]
+	}
+	trimmed := strings.TrimSpace(text)
+
+	if i := strings.LastIndex(text, "${"); i >= 0 && !strings.Contains(text[i:], "}") {
+		for _, name := range a.names("var") {
+			items = append(items, CompletionItem{Label: name, Kind: completionKindVariable, Detail: "var"})
+		}
+		return items
+	}
+
+	switch {
+	case strings.HasPrefix(trimmed, "deps:"):
+		current := ""
+		for _, sym := range a.symbols {
+			if sym.Kind == "task" && sym.Line <= pos.Line && pos.Line <= sym.EndLine {
+				current = sym.Name
+			}
+		}
+		for _, name := range a.names("task") {
+			if name == current {
+				continue
+			}
+			item := CompletionItem{Label: name, Kind: completionKindFunction, Detail: "task"}
+			if task := a.findTask(name); task != nil && task.Desc != "" {
+				item.Detail = task.Desc
+			}
+			items = append(items, item)
+		}
+		return items
+	case strings.HasPrefix(trimmed, "profile_task:"):
+		for _, name := range a.names("profile") {
+			items = append(items, CompletionItem{Label: name, Kind: completionKindModule, Detail: "profile"})
+		}
+		return items
+	case strings.Contains(trimmed, ":") || strings.Contains(trimmed, " "):
+		return items
+	}
+
+	var keywords map[string]string
+	if lexer.CountIndent(text) == 0 {
+		keywords = topLevelKeywords
+	} else {
+		block, indent := a.enclosingBlock(pos.Line)
+		if lexer.CountIndent(text) > indent {
+			return items
+		}
+		switch block {
+		case "task", "template":
+			keywords = taskDirectives
+		case "profile":
+			keywords = profileDirectives
+		}
+	}
+
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		items = append(items, CompletionItem{Label: name, Kind: completionKindKeyword, Detail: keywords[name]})
+	}
+	return items
+}
+
+func (d *document) definition(pos Position) []Location {
+	a := d.analysis
+
+	if inc, ok := a.includeAt(pos); ok {
+		path := filepath.Join(filepath.Dir(d.path), inc.Path)
+		return []Location{{URI: pathToURI(path), Range: Range{}}}
+	}
+
+	ref, ok := a.refAt(pos)
+	if !ok {
+		return []Location{}
+	}
+	sym, ok := a.lookup(ref.Kind, ref.Name)
+	if !ok {
+		return []Location{}
+	}
+	return []Location{symbolLocation(sym)}
+}
+
+func (d *document) hover(pos Position) *Hover {
+	a := d.analysis
+
+	name, kind := "", ""
+	if ref, ok := a.refAt(pos); ok {
+		name, kind = ref.Name, ref.Kind
+	} else if sym, ok := a.symbolAt(pos); ok {
+		name, kind = sym.Name, sym.Kind
+	} else {
+		return nil
+	}
+
+	var sb strings.Builder
+	switch kind {
+	case "task", "template", "extends":
+		task := a.findTask(name)
+		if task == nil {
+			return nil
+		}
+		header := "task"
+		if _, ok := a.lookup("template", name); ok {
+			header = "template"
+		}
+		fmt.Fprintf(&sb, "**%s %s**\n", header, task.Name)
+		if task.Desc != "" {
+			fmt.Fprintf(&sb, "\n%s\n", task.Desc)
+		}
+		if len(task.Deps) > 0 {
+			fmt.Fprintf(&sb, "\ndeps: %s\n", strings.Join(task.Deps, ", "))
+		}
+		if len(task.Run) > 0 {
+			env := make(map[string]string)
+			if a.file != nil {
+				for k, v := range a.file.Vars {
+					env[k] = v
+				}
+			}
+			for k, v := range task.Env {
+				env[k] = v
+			}
+			sb.WriteString("\n```sh\n")
+			for _, cmd := range task.Run {
+				sb.WriteString(resolveStatic(cmd, env))
+				sb.WriteString("\n")
+			}
+			sb.WriteString("```\n")
+		}
+	case "var":
+		if a.file == nil {
+			return nil
+		}
+		value, ok := a.file.Vars[name]
+		if !ok {
+			return nil
+		}
+		fmt.Fprintf(&sb, "**var %s** = `%s`", name, value)
+	case "profile":
+		if a.file == nil {
+			return nil
+		}
+		for _, profile := range a.file.Profiles {
+			if profile.Name != name {
+				continue
+			}
+			fmt.Fprintf(&sb, "**profile %s**\n", name)
+			if profile.Extends != "" {
+				fmt.Fprintf(&sb, "\nextends `%s`\n", profile.Extends)
+			}
+			values := vars.MergeVars(profile.Vars, profile.Env)
+			keys := make([]string, 0, len(values))
+			for k := range values {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(&sb, "\n- `%s = %s`", k, values[k])
+			}
+		}
+	}
+
+	if sb.Len() == 0 {
+		return nil
+	}
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: sb.String()}}
+}
+
+func (d *document) documentSymbols() []DocumentSymbol {
+	kinds := map[string]int{
+		"task":     symbolKindFunction,
+		"template": symbolKindClass,
+		"profile":  symbolKindNamespace,
+		"var":      symbolKindVariable,
+	}
+
+	symbols := []DocumentSymbol{}
+	for _, sym := range d.analysis.symbols {
+		ds := DocumentSymbol{
+			Name: sym.Name,
+			Kind: kinds[sym.Kind],
+			Range: Range{
+				Start: Position{sym.Line, 0},
+				End:   Position{sym.EndLine, d.analysis.lineLength(sym.EndLine)},
+			},
+			SelectionRange: Range{
+				Start: Position{sym.Line, sym.Col},
+				End:   Position{sym.Line, sym.Col + len(sym.Name)},
+			},
+		}
+		if sym.Kind == "task" || sym.Kind == "template" {
+			if task := d.analysis.findTask(sym.Name); task != nil {
+				ds.Detail = task.Desc
+			}
+		}
+		symbols = append(symbols, ds)
+	}
+	return symbols
+}
+
+func symbolLocation(sym symbol) Location {
+	return Location{
+		URI: sym.URI,
+		Range: Range{
+			Start: Position{sym.Line, sym.Col},
+			End:   Position{sym.Line, sym.Col + len(sym.Name)},
+		},
+	}
+}
+
+// resolveStatic expands ${VAR} references using plain values only, so
+// hovering never runs $(shell ...) expressions.
+func resolveStatic(s string, vars map[string]string) string {
+	for i := 0; i < 10; i++ {
+		expanded := varRefPattern.ReplaceAllStringFunc(s, func(match string) string {
+			name := varRefPattern.FindStringSubmatch(match)[1]
+			if val, ok := vars[name]; ok && !strings.Contains(val, "$(shell") {
+				return val
+			}
+			return match
+		})
+		if expanded == s {
+			break
+		}
+		s = expanded
+	}
+	return s
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
@@ -12,13 +13,23 @@ type Parser struct {
 	l            *lexer.Lexer
 	currentToken lexer.Token
 	peekToken    lexer.Token
-	errors       []string
+	errors       []Error
+}
+
+type Error struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%s at line %d", e.Message, e.Line)
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []Error{},
 	}
 	p.nextToken()
 	p.nextToken()
@@ -37,7 +48,15 @@ func (p *Parser) skipNewlines() {
 }
 
 func (p *Parser) addError(msg string) {
-	p.errors = append(p.errors, fmt.Sprintf("%s at line %d", msg, p.currentToken.Line))
+	p.errors = append(p.errors, Error{
+		Message: msg,
+		Line:    p.currentToken.Line,
+		Column:  p.currentToken.Column,
+	})
+}
+
+func (p *Parser) Errors() []Error {
+	return p.errors
 }
 
 func (p *Parser) Parse() (*ast.FluxFile, error) {
@@ -51,7 +70,7 @@ func (p *Parser) Parse() (*ast.FluxFile, error) {
 			continue
 		}
 
-		switch p.currentToken.Type {
+		switch p.directive() {
 		case lexer.VAR:
 			name, value := p.parseVarDecl()
 			if name != "" {
@@ -62,30 +81,47 @@ func (p *Parser) Parse() (*ast.FluxFile, error) {
 			if task.Name != "" {
 				fluxFile.Tasks = append(fluxFile.Tasks, task)
 			}
+		case lexer.TEMPLATE:
+			template := p.parseTask()
+			if template.Name != "" {
+				fluxFile.Templates = append(fluxFile.Templates, template)
+			}
 		case lexer.PROFILE:
 			profile := p.parseProfile()
 			if profile.Name != "" {
 				fluxFile.Profiles = append(fluxFile.Profiles, profile)
 			}
+		case lexer.DOTENV:
+			fluxFile.Dotenv = append(fluxFile.Dotenv, p.parseDotenv()...)
 		case lexer.INCLUDE:
 			include := p.parseInclude()
-			if include != "" {
+			if include.Path != "" {
 				fluxFile.Includes = append(fluxFile.Includes, include)
 			}
 		case lexer.EOF:
-			return fluxFile, nil
+			continue
 		default:
 			p.nextToken()
 		}
 	}
 
 	if len(p.errors) > 0 {
-		return nil, fmt.Errorf("parse errors: %s", strings.Join(p.errors, "; "))
+		msgs := make([]string, len(p.errors))
+		for i, e := range p.errors {
+			msgs[i] = e.String()
+		}
+		return nil, fmt.Errorf("parse errors: %s", strings.Join(msgs, "; "))
 	}
 
 	return fluxFile, nil
 }
 
+// directive returns the type of the current token where a declaration or
+// directive starts, recognizing contextual keywords.
+func (p *Parser) directive() lexer.TokenType {
+	return lexer.LookupDirective(p.currentToken)
+}
+
 func (p *Parser) parseVarDecl() (string, string) {
 	p.nextToken()
 
@@ -103,11 +139,45 @@ func (p *Parser) parseVarDecl() (string, string) {
 	}
 
 	p.nextToken()
+
+	if p.currentToken.Type == lexer.ILLEGAL && p.currentToken.Literal == "[" {
+		return name, p.parseList()
+	}
+
 	value := p.parseExpr()
 
 	return name, value
 }
 
+// parseList reads a [a, b, c] list from the source line. Lists are stored
+// as whitespace-separated items, which is what "each" iterates over.
+func (p *Parser) parseList() string {
+	line := p.currentToken.Line
+	raw := p.l.Line(line)
+	text := strings.TrimSpace(raw[p.currentToken.Column-1:])
+	p.skipToLine(line + 1)
+
+	if !strings.HasSuffix(text, "]") {
+		p.addError("expected ] at end of list")
+		return ""
+	}
+
+	var items []string
+	for _, item := range strings.Split(text[1:len(text)-1], ",") {
+		item = strings.Trim(strings.TrimSpace(item), `"'`)
+		if item == "" {
+			continue
+		}
+		if strings.ContainsAny(item, " \t") {
+			p.addError(fmt.Sprintf("list item %q must not contain whitespace", item))
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return strings.Join(items, " ")
+}
+
 func (p *Parser) parseExpr() string {
 	switch p.currentToken.Type {
 	case lexer.STRING:
@@ -182,8 +252,15 @@ func (p *Parser) parseShellExpr() string {
 		return ""
 	}
 
+	line := p.currentToken.Line
 	p.nextToken()
 
+	// A trailing ? makes the value optional: a failing command yields "".
+	if p.currentToken.Type == lexer.ILLEGAL && p.currentToken.Literal == "?" && p.currentToken.Line == line {
+		p.nextToken()
+		return fmt.Sprintf("$(shell %q)?", command)
+	}
+
 	return fmt.Sprintf("$(shell %q)", command)
 }
 
@@ -196,6 +273,7 @@ func (p *Parser) parseTask() ast.Task {
 	}
 
 	task := ast.NewTask(p.currentToken.Literal)
+	task.Line = p.currentToken.Line
 	p.nextToken()
 
 	if p.currentToken.Type != lexer.COLON {
@@ -228,11 +306,15 @@ func (p *Parser) parseTaskBody(task *ast.Task) {
 		}
 
 		if p.currentToken.Type == lexer.TASK || p.currentToken.Type == lexer.PROFILE ||
-			p.currentToken.Type == lexer.VAR || p.currentToken.Type == lexer.INCLUDE {
+			p.currentToken.Type == lexer.VAR || p.currentToken.Type == lexer.INCLUDE ||
+			p.directive() == lexer.TEMPLATE {
 			break
 		}
 
-		switch p.currentToken.Type {
+		directive := p.currentToken.Literal
+		declared := true
+
+		switch p.directive() {
 		case lexer.DESC:
 			task.Desc = p.parseDesc()
 		case lexer.DEPS:
@@ -277,10 +359,22 @@ func (p *Parser) parseTaskBody(task *ast.Task) {
 			task.Prompt = p.parsePrompt()
 		case lexer.NOTIFY:
 			task.Notify = p.parseNotify()
+		case lexer.EXTENDS:
+			task.Extends = p.parseExtends()
+		case lexer.SHELL:
+			task.Shell = p.parseShell()
+		case lexer.DOTENV:
+			task.Dotenv = p.parseDotenv()
+		case lexer.RESTART:
+			task.Restart = p.parseRestart()
 		default:
+			declared = false
 			p.nextToken()
 		}
 
+		if declared {
+			task.Declared[directive] = true
+		}
 	}
 }
 
@@ -301,8 +395,7 @@ func (p *Parser) parseDeps() []string {
 			break
 		}
 
-		deps = append(deps, p.currentToken.Literal)
-		p.nextToken()
+		deps = append(deps, p.parseTaskRef())
 
 		if p.currentToken.Type == lexer.COMMA {
 			p.nextToken()
@@ -314,6 +407,39 @@ func (p *Parser) parseDeps() []string {
 	return deps
 }
 
+// parseTaskRef reads a task name that may be qualified with the namespace
+// of an include, such as ci:build.
+func (p *Parser) parseTaskRef() string {
+	ref := p.currentToken.Literal
+	p.nextToken()
+
+	for p.currentToken.Type == lexer.COLON && p.peekToken.Type == lexer.IDENT {
+		p.nextToken()
+		ref += ":" + p.currentToken.Literal
+		p.nextToken()
+	}
+
+	return ref
+}
+
+func (p *Parser) parseExtends() string {
+	p.nextToken()
+
+	if p.currentToken.Type != lexer.COLON {
+		p.addError("expected : after extends")
+		return ""
+	}
+
+	p.nextToken()
+
+	if p.currentToken.Type != lexer.IDENT {
+		p.addError(fmt.Sprintf("expected task or template name after extends, got %s", p.currentToken.Type))
+		return ""
+	}
+
+	return p.parseTaskRef()
+}
+
 func (p *Parser) parseRun() []string {
 	p.nextToken()
 
@@ -323,9 +449,28 @@ func (p *Parser) parseRun() []string {
 	}
 
 	p.nextToken()
+
+	if p.currentToken.Type == lexer.ILLEGAL && p.currentToken.Literal == "|" {
+		script := p.parseBlockScalar()
+		if script == "" {
+			return []string{}
+		}
+		return []string{script}
+	}
+
+	// An inline run: holds one command. The DEDENT after it closes the
+	// enclosing task, not the run.
+	if p.currentToken.Type != lexer.NEWLINE && p.currentToken.Type != lexer.EOF {
+		if command := p.parseCommand(); command != "" {
+			return []string{command}
+		}
+		return []string{}
+	}
+
 	p.skipNewlines()
 
-	if p.currentToken.Type == lexer.INDENT {
+	indented := p.currentToken.Type == lexer.INDENT
+	if indented {
 		p.nextToken()
 	}
 
@@ -344,41 +489,152 @@ func (p *Parser) parseRun() []string {
 		}
 	}
 
-	if p.currentToken.Type == lexer.DEDENT {
+	if indented && p.currentToken.Type == lexer.DEDENT {
 		p.nextToken()
 	}
 
 	return commands
 }
 
+// parseCommand reads one command from the raw source line of the current
+// token. Lines ending in a backslash are joined with the next one, and a
+// heredoc keeps its body and terminator lines.
 func (p *Parser) parseCommand() string {
-	var sb strings.Builder
+	line := p.currentToken.Line
+	raw := p.l.Line(line)
+
+	start := p.currentToken.Column - 1
+	if start < 0 || start > len(raw) {
+		start = 0
+	}
+	command := strings.TrimSpace(raw[start:])
+
+	if strings.HasPrefix(command, "#") {
+		p.skipToLine(line + 1)
+		return ""
+	}
+
+	for strings.HasSuffix(command, "\\") && line < p.l.LineCount() {
+		line++
+		command = strings.TrimSpace(strings.TrimSuffix(command, "\\")) + " " + strings.TrimSpace(p.l.Line(line))
+	}
+
+	if delimiter := heredocDelimiter(command); delimiter != "" {
+		indent := lexer.CountIndent(raw)
+		lines := []string{command}
+		for line < p.l.LineCount() {
+			line++
+			text := trimIndent(p.l.Line(line), indent)
+			lines = append(lines, text)
+			if strings.TrimSpace(text) == delimiter {
+				break
+			}
+		}
+		command = strings.Join(lines, "\n")
+	}
+
+	p.skipToLine(line + 1)
+	return command
+}
+
+// parseBlockScalar reads a "run: |" block: every following line that is
+// blank or indented deeper than the run directive, with the common
+// indentation removed.
+func (p *Parser) parseBlockScalar() string {
+	line := p.currentToken.Line
 
-	for p.currentToken.Type != lexer.NEWLINE && p.currentToken.Type != lexer.EOF && p.currentToken.Type != lexer.DEDENT {
-		text := p.currentToken.Literal
-		length := len(text)
+	if p.peekToken.Type != lexer.NEWLINE && p.peekToken.Type != lexer.EOF {
+		p.addError("expected newline after run: |")
+	}
 
-		if p.currentToken.Type == lexer.STRING {
-			text = "\"" + text + "\""
-			length += 2 // Account for quotes
+	indent := lexer.CountIndent(p.l.Line(line))
+	last := line
+	var lines []string
+	for n := line + 1; n <= p.l.LineCount(); n++ {
+		text := p.l.Line(n)
+		if strings.TrimSpace(text) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if lexer.CountIndent(text) <= indent {
+			break
 		}
+		lines = append(lines, text)
+		last = n
+	}
 
-		sb.WriteString(text)
+	lines = lines[:last-line]
+	p.skipToLine(last + 1)
 
-		// Check if we need to add a space before the next token
-		// We use the column information from the lexer to determine if there was a space in the source
-		currentEnd := p.currentToken.Column + length
-		nextStart := p.peekToken.Column
+	return lexer.StripIndent(strings.Join(lines, "\n"))
+}
 
-		// Only add space if the next token is on the same line and there is a gap
-		if p.peekToken.Line == p.currentToken.Line && nextStart > currentEnd {
-			sb.WriteString(" ")
+// rawDirective reads the value of a directive from the source, for values
+// the lexer cannot tokenize, such as glob patterns. The current token must
+// be the directive's colon. It returns the text after the colon or, when
+// there is none, the trimmed lines of the indented block below it, without
+// blank lines and # comments.
+func (p *Parser) rawDirective() (string, []string) {
+	line := p.currentToken.Line
+	raw := p.l.Line(line)
+	start := p.currentToken.Column
+	if start > len(raw) {
+		start = len(raw)
+	}
+
+	if text := strings.TrimSpace(raw[start:]); text != "" && !strings.HasPrefix(text, "#") {
+		p.skipToLine(line + 1)
+		return text, nil
+	}
+
+	indent := lexer.CountIndent(raw)
+	last := line
+	var lines []string
+	for n := line + 1; n <= p.l.LineCount(); n++ {
+		text := p.l.Line(n)
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if lexer.CountIndent(text) <= indent {
+			break
 		}
+		lines = append(lines, trimmed)
+		last = n
+	}
+	p.skipToLine(last + 1)
+
+	return "", lines
+}
 
+// skipToLine discards the rest of the tokens before line n and resumes
+// parsing at its start.
+func (p *Parser) skipToLine(n int) {
+	if p.peekToken.Type == lexer.DEDENT || p.peekToken.Type == lexer.EOF {
 		p.nextToken()
+		return
 	}
 
-	return strings.TrimSpace(sb.String())
+	p.l.SkipToLine(n)
+	p.currentToken = lexer.Token{Type: lexer.NEWLINE, Literal: "\n", Line: n - 1}
+	p.peekToken = p.l.NextToken()
+}
+
+var heredocPattern = regexp.MustCompile(`(?:^|[^<])<<-?\s*['"]?([A-Za-z_][A-Za-z0-9_]*)['"]?`)
+
+func heredocDelimiter(command string) string {
+	if match := heredocPattern.FindStringSubmatch(command); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+func trimIndent(line string, indent int) string {
+	i := 0
+	for i < indent && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return line[i:]
 }
 
 func (p *Parser) parseEnv() map[string]string {
@@ -445,17 +701,7 @@ func (p *Parser) parseWatch() []string {
 		return []string{}
 	}
 
-	p.nextToken()
-
-	var patterns []string
-
-	switch p.currentToken.Type {
-	case lexer.STRING, lexer.IDENT:
-		patterns = append(patterns, p.currentToken.Literal)
-		p.nextToken()
-	}
-
-	return patterns
+	return p.parsePatterns()
 }
 
 func (p *Parser) parseMatrix() *ast.Matrix {
@@ -578,6 +824,7 @@ func (p *Parser) parseProfile() ast.Profile {
 	}
 
 	profile := ast.NewProfile(p.currentToken.Literal)
+	profile.Line = p.currentToken.Line
 	p.nextToken()
 
 	if p.currentToken.Type != lexer.COLON {
@@ -599,9 +846,24 @@ func (p *Parser) parseProfile() ast.Profile {
 			break
 		}
 
-		if p.currentToken.Type == lexer.ENV {
+		switch p.directive() {
+		case lexer.ENV:
 			profile.Env = p.parseEnv()
-		} else {
+		case lexer.VAR:
+			name, value := p.parseVarDecl()
+			if name != "" {
+				profile.Vars[name] = value
+			}
+		case lexer.EXTENDS:
+			profile.Extends = p.parseExtends()
+		case lexer.DOTENV:
+			profile.Dotenv = p.parseDotenv()
+		case lexer.TASK:
+			task := p.parseTask()
+			if task.Name != "" {
+				profile.Tasks = append(profile.Tasks, task)
+			}
+		default:
 			p.nextToken()
 		}
 	}
@@ -613,17 +875,29 @@ func (p *Parser) parseProfile() ast.Profile {
 	return profile
 }
 
-func (p *Parser) parseInclude() string {
+func (p *Parser) parseInclude() ast.Include {
 	p.nextToken()
 
 	if p.currentToken.Type != lexer.STRING {
 		p.addError(fmt.Sprintf("expected string after include, got %s", p.currentToken.Type))
-		return ""
+		return ast.Include{}
 	}
 
-	include := p.currentToken.Literal
+	include := ast.Include{Path: p.currentToken.Literal}
 	p.nextToken()
 
+	if p.currentToken.Type == lexer.IDENT && p.currentToken.Literal == "as" {
+		p.nextToken()
+
+		if p.currentToken.Type != lexer.IDENT {
+			p.addError(fmt.Sprintf("expected namespace after as, got %s", p.currentToken.Type))
+			return ast.Include{}
+		}
+
+		include.Namespace = p.currentToken.Literal
+		p.nextToken()
+	}
+
 	return include
 }
 
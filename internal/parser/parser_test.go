@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/ashavijit/fluxfile/internal/ast"
 	"github.com/ashavijit/fluxfile/internal/lexer"
 )
 
@@ -107,3 +109,483 @@ func TestParseRetries(t *testing.T) {
 		t.Errorf("Expected retries 3, got %d", fluxFile.Tasks[0].Retries)
 	}
 }
+
+func TestParseErrorsHaveLocation(t *testing.T) {
+	input := `var PROJECT = flux
+var = broken
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	_, err := p.Parse()
+
+	if err == nil {
+		t.Fatal("Expected parse error")
+	}
+
+	errs := p.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %d", len(errs))
+	}
+
+	if errs[0].Line != 2 {
+		t.Errorf("Expected error on line 2, got %d", errs[0].Line)
+	}
+}
+
+func TestParseNamespacedInclude(t *testing.T) {
+	input := `include "ci/FluxFile" as ci
+
+task release:
+    deps: ci:build, test
+    run:
+        ./release.sh
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(fluxFile.Includes) != 1 || fluxFile.Includes[0].Namespace != "ci" {
+		t.Fatalf("Expected include with namespace ci, got %+v", fluxFile.Includes)
+	}
+
+	deps := fluxFile.Tasks[0].Deps
+	if len(deps) != 2 || deps[0] != "ci:build" || deps[1] != "test" {
+		t.Errorf("Expected deps [ci:build test], got %v", deps)
+	}
+
+	if fluxFile.Tasks[0].Line != 3 {
+		t.Errorf("Expected task on line 3, got %d", fluxFile.Tasks[0].Line)
+	}
+}
+
+func TestParseTemplateExtends(t *testing.T) {
+	input := `template go-base:
+    env:
+        CGO_ENABLED = 0
+    cache: true
+
+task build:
+    extends: go-base
+    cache: false
+    run:
+        go build
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(fluxFile.Templates) != 1 || fluxFile.Templates[0].Name != "go-base" {
+		t.Fatalf("Expected template go-base, got %+v", fluxFile.Templates)
+	}
+
+	if len(fluxFile.Tasks) != 1 {
+		t.Fatalf("Expected 1 task, got %d", len(fluxFile.Tasks))
+	}
+
+	task := fluxFile.Tasks[0]
+	if task.Extends != "go-base" {
+		t.Errorf("Expected extends go-base, got %q", task.Extends)
+	}
+	if !task.Declared["cache"] || task.Declared["env"] {
+		t.Errorf("Unexpected declared directives %v", task.Declared)
+	}
+}
+
+func TestParseRunVerbatim(t *testing.T) {
+	input := `task build:
+    run:
+        echo "a: b" # keep
+        # comment line
+        go build \
+            -o bin/flux
+        cat <<EOF > config.yml
+        key: value
+        EOF
+        echo done
+    cache: true
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	expected := []string{
+		`echo "a: b" # keep`,
+		"go build -o bin/flux",
+		"cat <<EOF > config.yml\nkey: value\nEOF",
+		"echo done",
+	}
+
+	task := fluxFile.Tasks[0]
+	if len(task.Run) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d: %q", len(expected), len(task.Run), task.Run)
+	}
+	for i, cmd := range expected {
+		if task.Run[i] != cmd {
+			t.Errorf("Command %d: expected %q, got %q", i, cmd, task.Run[i])
+		}
+	}
+	if !task.Cache {
+		t.Error("Expected directive after run block to be parsed")
+	}
+}
+
+func TestParseInlineRun(t *testing.T) {
+	input := `profile prod:
+    task deploy:
+        run: echo prod-deploy
+
+task a:
+    run: echo a
+    deps: deploy
+
+task deploy:
+    run: echo deploy
+`
+
+	fluxFile, err := New(lexer.New(input)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(fluxFile.Tasks) != 2 || fluxFile.Tasks[0].Name != "a" || fluxFile.Tasks[1].Name != "deploy" {
+		t.Fatalf("Expected tasks a and deploy, got %v", fluxFile.Tasks)
+	}
+	if a := fluxFile.Tasks[0]; strings.Join(a.Run, ";") != "echo a" || strings.Join(a.Deps, ",") != "deploy" {
+		t.Errorf("Expected a to run echo a after deploy, got %q, deps %v", a.Run, a.Deps)
+	}
+	if len(fluxFile.Profiles) != 1 || len(fluxFile.Profiles[0].Tasks) != 1 {
+		t.Fatalf("Expected 1 profile with 1 task override, got %v", fluxFile.Profiles)
+	}
+	if deploy := fluxFile.Profiles[0].Tasks[0]; deploy.Name != "deploy" || strings.Join(deploy.Run, ";") != "echo prod-deploy" {
+		t.Errorf("Expected the override of deploy, got %s %q", deploy.Name, deploy.Run)
+	}
+}
+
+func TestParseRunBlockScalar(t *testing.T) {
+	input := `task script:
+    shell: python3
+    run: |
+        import sys
+
+        if len(sys.argv) > 0:
+            print("it's: fine")
+    desc: "Script"
+
+task next:
+    run:
+        echo next
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(fluxFile.Tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(fluxFile.Tasks))
+	}
+
+	task := fluxFile.Tasks[0]
+	if task.Shell != "python3" {
+		t.Errorf("Expected shell python3, got %q", task.Shell)
+	}
+
+	script := "import sys\n\nif len(sys.argv) > 0:\n    print(\"it's: fine\")"
+	if len(task.Run) != 1 || task.Run[0] != script {
+		t.Errorf("Expected verbatim script, got %q", task.Run)
+	}
+	if task.Desc != "Script" {
+		t.Errorf("Expected desc after block, got %q", task.Desc)
+	}
+	if len(fluxFile.Tasks[1].Run) != 1 || fluxFile.Tasks[1].Run[0] != "echo next" {
+		t.Errorf("Unexpected commands for next: %q", fluxFile.Tasks[1].Run)
+	}
+}
+
+func TestParseIfCondition(t *testing.T) {
+	input := `task deploy:
+    if: MODE == "prod" && (os() == linux || env("CI") != "")
+    run:
+        ./deploy.sh
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	expected := `MODE == "prod" && (os() == linux || env("CI") != "")`
+	if fluxFile.Tasks[0].If != expected {
+		t.Errorf("Expected condition %q, got %q", expected, fluxFile.Tasks[0].If)
+	}
+	if len(fluxFile.Tasks[0].Run) != 1 {
+		t.Errorf("Expected run after if, got %q", fluxFile.Tasks[0].Run)
+	}
+}
+
+func TestParseInvalidIfCondition(t *testing.T) {
+	input := `task deploy:
+    run:
+        ./deploy.sh
+    if: MODE == && x
+`
+
+	p := New(lexer.New(input))
+	if _, err := p.Parse(); err == nil {
+		t.Fatal("Expected parse error")
+	}
+
+	errs := p.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	if errs[0].Line != 4 || !strings.Contains(errs[0].Message, "invalid if condition") {
+		t.Errorf("Unexpected error %+v", errs[0])
+	}
+}
+
+func TestParseListVar(t *testing.T) {
+	input := `var TARGETS = [linux/amd64, "darwin/arm64", windows/amd64]
+var NAME = flux
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if fluxFile.Vars["TARGETS"] != "linux/amd64 darwin/arm64 windows/amd64" {
+		t.Errorf("Unexpected list value %q", fluxFile.Vars["TARGETS"])
+	}
+	if fluxFile.Vars["NAME"] != "flux" {
+		t.Errorf("Expected var after list to be parsed, got %q", fluxFile.Vars["NAME"])
+	}
+}
+
+func TestParseOptionalShellVar(t *testing.T) {
+	input := `var SHA = $(shell "git rev-parse HEAD")?
+var DATE = $(shell "date")
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if fluxFile.Vars["SHA"] != `$(shell "git rev-parse HEAD")?` {
+		t.Errorf("Unexpected optional shell value %q", fluxFile.Vars["SHA"])
+	}
+	if fluxFile.Vars["DATE"] != `$(shell "date")` {
+		t.Errorf("Unexpected shell value %q", fluxFile.Vars["DATE"])
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	input := `dotenv: [.env, ".env.${MODE}"]
+
+profile prod:
+    dotenv: .env.prod
+
+task deploy:
+    dotenv: [deploy/.env]
+    run:
+        ./deploy.sh
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if strings.Join(fluxFile.Dotenv, " ") != ".env .env.${MODE}" {
+		t.Errorf("Unexpected file dotenv %v", fluxFile.Dotenv)
+	}
+	if len(fluxFile.Profiles) != 1 || strings.Join(fluxFile.Profiles[0].Dotenv, " ") != ".env.prod" {
+		t.Errorf("Unexpected profile dotenv %v", fluxFile.Profiles)
+	}
+	if len(fluxFile.Tasks) != 1 || strings.Join(fluxFile.Tasks[0].Dotenv, " ") != "deploy/.env" {
+		t.Fatalf("Unexpected task dotenv %v", fluxFile.Tasks)
+	}
+	if len(fluxFile.Tasks[0].Run) != 1 {
+		t.Errorf("Expected run after dotenv to be parsed, got %v", fluxFile.Tasks[0].Run)
+	}
+}
+
+func TestParseSecrets(t *testing.T) {
+	input := `task deploy:
+    secrets:
+        API_KEY
+        # a comment
+        DB_PASS = pass:prod/db
+        TOKEN = exec:"vault kv get -field=token secret/ci"
+    run:
+        ./deploy.sh
+
+task publish:
+    secrets: NPM_TOKEN, GH_TOKEN
+    run:
+        npm publish
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(fluxFile.Tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(fluxFile.Tasks))
+	}
+
+	expected := []ast.Secret{
+		{Name: "API_KEY"},
+		{Name: "DB_PASS", Source: "pass:prod/db"},
+		{Name: "TOKEN", Source: `exec:"vault kv get -field=token secret/ci"`},
+	}
+	deploy := fluxFile.Tasks[0]
+	if len(deploy.Secrets) != len(expected) {
+		t.Fatalf("Expected %d secrets, got %v", len(expected), deploy.Secrets)
+	}
+	for i, secret := range expected {
+		if deploy.Secrets[i] != secret {
+			t.Errorf("Expected secret %v, got %v", secret, deploy.Secrets[i])
+		}
+	}
+	if len(deploy.Run) != 1 {
+		t.Errorf("Expected run after secrets to be parsed, got %v", deploy.Run)
+	}
+
+	publish := fluxFile.Tasks[1]
+	if len(publish.Secrets) != 2 || publish.Secrets[1].Name != "GH_TOKEN" || len(publish.Run) != 1 {
+		t.Errorf("Unexpected inline secrets %v, run %v", publish.Secrets, publish.Run)
+	}
+}
+
+func TestParseWatchPatterns(t *testing.T) {
+	input := `task dev:
+    watch: src/**/*.go, "{go.mod,go.sum}"  # sources
+    ignore:
+        vendor/**
+        **/*_test.go
+    restart: true
+    inputs: src/**/*.go, go.mod
+    outputs:
+        dist/*.tar.gz
+    run:
+        go run .
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	fluxFile, err := p.Parse()
+
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	task := fluxFile.Tasks[0]
+	if strings.Join(task.Watch, " ") != "src/**/*.go {go.mod,go.sum}" {
+		t.Errorf("Unexpected watch patterns %q", task.Watch)
+	}
+	if strings.Join(task.WatchIgnore, " ") != "vendor/** **/*_test.go" {
+		t.Errorf("Unexpected ignore patterns %q", task.WatchIgnore)
+	}
+	if !task.Restart {
+		t.Error("Expected restart to be true")
+	}
+	if strings.Join(task.Inputs, " ") != "src/**/*.go go.mod" || strings.Join(task.Outputs, " ") != "dist/*.tar.gz" {
+		t.Errorf("Unexpected inputs %q and outputs %q", task.Inputs, task.Outputs)
+	}
+	if len(task.Run) != 1 {
+		t.Errorf("Expected run after ignore to be parsed, got %v", task.Run)
+	}
+}
+
+func TestParseKeywordsAsNames(t *testing.T) {
+	input := `template base:
+    run:
+        echo base
+
+task template:
+    extends: base
+    run:
+        echo template
+
+task extends:
+    run:
+        echo extends
+
+task dotenv:
+    dotenv: .env
+    run:
+        echo dotenv
+
+task restart:
+    restart: true
+    run:
+        echo restart
+
+task all:
+    deps: template, extends, dotenv, restart
+`
+
+	fluxFile, err := New(lexer.New(input)).Parse()
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(fluxFile.Templates) != 1 || fluxFile.Templates[0].Name != "base" {
+		t.Errorf("Expected template base, got %v", fluxFile.Templates)
+	}
+	tasks := make(map[string]ast.Task)
+	for _, task := range fluxFile.Tasks {
+		tasks[task.Name] = task
+	}
+	if len(tasks) != 5 {
+		t.Fatalf("Expected 3 tasks, got %v", fluxFile.Tasks)
+	}
+	if task := tasks["template"]; task.Extends != "base" || strings.Join(task.Run, ";") != "echo template" {
+		t.Errorf("Expected task template to extend base, got %+v", task)
+	}
+	if task := tasks["dotenv"]; strings.Join(task.Dotenv, ",") != ".env" {
+		t.Errorf("Expected task dotenv to load .env, got %v", task.Dotenv)
+	}
+	if task := tasks["restart"]; !task.Restart {
+		t.Errorf("Expected task restart to restart, got %+v", task)
+	}
+	if deps := strings.Join(tasks["all"].Deps, ","); deps != "template,extends,dotenv,restart" {
+		t.Errorf("Expected all to depend on template, extends, dotenv and restart, got %s", deps)
+	}
+}
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/ashavijit/fluxfile/internal/ast"
 	"github.com/ashavijit/fluxfile/internal/lexer"
@@ -27,52 +30,57 @@ func (p *Parser) parseProfileTask() string {
 	return ""
 }
 
-func (p *Parser) parseSecrets() []string {
+// parseSecrets reads secret names, either listed on the directive's line or
+// one per line in an indented block. A name may be followed by = and the
+// provider to read it from, as in TOKEN = pass:ci/token.
+func (p *Parser) parseSecrets() []ast.Secret {
 	p.nextToken()
 
 	if p.currentToken.Type != lexer.COLON {
 		p.addError("expected : after secrets")
-		return []string{}
-	}
-
-	p.nextToken()
-	p.skipNewlines()
-
-	if p.currentToken.Type == lexer.INDENT {
-		p.nextToken()
+		return []ast.Secret{}
 	}
 
-	var secrets []string
-
-	for p.currentToken.Type != lexer.DEDENT && p.currentToken.Type != lexer.EOF {
-		p.skipNewlines()
-
-		if p.currentToken.Type == lexer.DEDENT || p.currentToken.Type == lexer.EOF {
-			break
+	secrets := []ast.Secret{}
+	add := func(text string) {
+		name, source, hasSource := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !hasSource && len(strings.Fields(name)) > 1 {
+			for _, field := range strings.Fields(name) {
+				secrets = append(secrets, ast.Secret{Name: field})
+			}
+			return
 		}
-
-		if p.currentToken.Type == lexer.DEPS || p.currentToken.Type == lexer.RUN ||
-			p.currentToken.Type == lexer.ENV || p.currentToken.Type == lexer.WATCH ||
-			p.currentToken.Type == lexer.MATRIX || p.currentToken.Type == lexer.DOCKER ||
-			p.currentToken.Type == lexer.REMOTE {
-			break
+		if !secretNamePattern.MatchString(name) {
+			p.addError(fmt.Sprintf("invalid secret name %q", name))
+			return
 		}
-
-		if p.currentToken.Type == lexer.STRING || p.currentToken.Type == lexer.IDENT {
-			secrets = append(secrets, p.currentToken.Literal)
-			p.nextToken()
-		} else {
-			p.nextToken()
+		source = strings.TrimSpace(source)
+		if hasSource && source == "" {
+			p.addError(fmt.Sprintf("expected provider after %s =", name))
+			return
 		}
+		secrets = append(secrets, ast.Secret{Name: name, Source: source})
 	}
 
-	if p.currentToken.Type == lexer.DEDENT {
-		p.nextToken()
+	text, lines := p.rawDirective()
+	if text != "" {
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				add(item)
+			}
+		}
+	}
+	for _, line := range lines {
+		add(line)
 	}
 
 	return secrets
 }
 
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 func (p *Parser) parsePre() []ast.Precondition {
 	p.nextToken()
 
@@ -196,3 +204,66 @@ func (p *Parser) parseTimeout() string {
 
 	return ""
 }
+
+func (p *Parser) parseShell() string {
+	p.nextToken()
+
+	if p.currentToken.Type != lexer.COLON {
+		p.addError("expected : after shell")
+		return ""
+	}
+
+	p.nextToken()
+
+	switch p.currentToken.Type {
+	case lexer.STRING, lexer.IDENT:
+		val := p.currentToken.Literal
+		p.nextToken()
+		return val
+	}
+
+	p.addError(fmt.Sprintf("expected shell name, got %s", p.currentToken.Type))
+	return ""
+}
+
+// parseDotenv reads a list of dotenv files from the source line, written
+// either as [.env, .env.local] or without brackets.
+func (p *Parser) parseDotenv() []string {
+	p.nextToken()
+
+	if p.currentToken.Type != lexer.COLON {
+		p.addError("expected : after dotenv")
+		return []string{}
+	}
+
+	line := p.currentToken.Line
+	raw := p.l.Line(line)
+	start := p.currentToken.Column
+	if start > len(raw) {
+		start = len(raw)
+	}
+	text := strings.TrimSpace(raw[start:])
+	if i := strings.Index(text, " #"); i >= 0 {
+		text = strings.TrimSpace(text[:i])
+	}
+	p.skipToLine(line + 1)
+
+	if strings.HasPrefix(text, "[") {
+		if !strings.HasSuffix(text, "]") {
+			p.addError("expected ] at end of dotenv list")
+			return []string{}
+		}
+		text = text[1 : len(text)-1]
+	}
+
+	files := []string{}
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), `"'`); item != "" {
+			files = append(files, item)
+		}
+	}
+	if len(files) == 0 {
+		p.addError("expected file names after dotenv:")
+	}
+	return files
+}
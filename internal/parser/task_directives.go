@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/ashavijit/fluxfile/internal/expr"
 	"github.com/ashavijit/fluxfile/internal/lexer"
 )
 
@@ -52,6 +54,27 @@ func (p *Parser) parseParallel() bool {
 	return false
 }
 
+func (p *Parser) parseRestart() bool {
+	p.nextToken()
+
+	if p.currentToken.Type != lexer.COLON {
+		p.addError("expected : after restart")
+		return false
+	}
+
+	p.nextToken()
+
+	if p.currentToken.Type == lexer.IDENT {
+		val := p.currentToken.Literal == "true"
+		p.nextToken()
+		return val
+	}
+
+	return false
+}
+
+// parseIf reads the condition verbatim from the source line and checks it
+// parses, so that malformed conditions fail before any task runs.
 func (p *Parser) parseIf() string {
 	p.nextToken()
 
@@ -60,25 +83,22 @@ func (p *Parser) parseIf() string {
 		return ""
 	}
 
-	p.nextToken()
-
-	var condition strings.Builder
-	for p.currentToken.Type != lexer.NEWLINE && p.currentToken.Type != lexer.EOF {
-		if p.currentToken.Type == lexer.STRING {
-			condition.WriteString("\"")
-			condition.WriteString(p.currentToken.Literal)
-			condition.WriteString("\"")
-		} else {
-			condition.WriteString(p.currentToken.Literal)
-		}
+	line := p.currentToken.Line
+	raw := p.l.Line(line)
+	start := p.currentToken.Column
+	if start > len(raw) {
+		start = len(raw)
+	}
+	condition := strings.TrimSpace(raw[start:])
 
-		if p.peekToken.Type != lexer.NEWLINE && p.peekToken.Type != lexer.EOF {
-			condition.WriteString(" ")
-		}
-		p.nextToken()
+	if condition == "" {
+		p.addError("expected condition after if:")
+	} else if _, err := expr.Parse(condition); err != nil {
+		p.addError(fmt.Sprintf("invalid if condition: %v", err))
 	}
 
-	return strings.TrimSpace(condition.String())
+	p.skipToLine(line + 1)
+	return condition
 }
 
 func (p *Parser) parseCache() bool {
@@ -108,43 +128,7 @@ func (p *Parser) parseInputs() []string {
 		return []string{}
 	}
 
-	p.nextToken()
-	p.skipNewlines()
-
-	if p.currentToken.Type == lexer.INDENT {
-		p.nextToken()
-	}
-
-	var inputs []string
-
-	for p.currentToken.Type != lexer.DEDENT && p.currentToken.Type != lexer.EOF {
-		p.skipNewlines()
-
-		if p.currentToken.Type == lexer.DEDENT || p.currentToken.Type == lexer.EOF {
-			break
-		}
-
-		if p.currentToken.Type == lexer.DEPS || p.currentToken.Type == lexer.RUN ||
-			p.currentToken.Type == lexer.ENV || p.currentToken.Type == lexer.WATCH ||
-			p.currentToken.Type == lexer.MATRIX || p.currentToken.Type == lexer.DOCKER ||
-			p.currentToken.Type == lexer.REMOTE || p.currentToken.Type == lexer.OUTPUTS ||
-			p.currentToken.Type == lexer.CACHE {
-			break
-		}
-
-		if p.currentToken.Type == lexer.STRING || p.currentToken.Type == lexer.IDENT {
-			inputs = append(inputs, p.currentToken.Literal)
-			p.nextToken()
-		} else {
-			p.nextToken()
-		}
-	}
-
-	if p.currentToken.Type == lexer.DEDENT {
-		p.nextToken()
-	}
-
-	return inputs
+	return p.parsePatterns()
 }
 
 func (p *Parser) parseOutputs() []string {
@@ -155,43 +139,7 @@ func (p *Parser) parseOutputs() []string {
 		return []string{}
 	}
 
-	p.nextToken()
-	p.skipNewlines()
-
-	if p.currentToken.Type == lexer.INDENT {
-		p.nextToken()
-	}
-
-	var outputs []string
-
-	for p.currentToken.Type != lexer.DEDENT && p.currentToken.Type != lexer.EOF {
-		p.skipNewlines()
-
-		if p.currentToken.Type == lexer.DEDENT || p.currentToken.Type == lexer.EOF {
-			break
-		}
-
-		if p.currentToken.Type == lexer.DEPS || p.currentToken.Type == lexer.RUN ||
-			p.currentToken.Type == lexer.ENV || p.currentToken.Type == lexer.WATCH ||
-			p.currentToken.Type == lexer.MATRIX || p.currentToken.Type == lexer.DOCKER ||
-			p.currentToken.Type == lexer.REMOTE || p.currentToken.Type == lexer.INPUTS ||
-			p.currentToken.Type == lexer.CACHE {
-			break
-		}
-
-		if p.currentToken.Type == lexer.STRING || p.currentToken.Type == lexer.IDENT {
-			outputs = append(outputs, p.currentToken.Literal)
-			p.nextToken()
-		} else {
-			p.nextToken()
-		}
-	}
-
-	if p.currentToken.Type == lexer.DEDENT {
-		p.nextToken()
-	}
-
-	return outputs
+	return p.parsePatterns()
 }
 
 func (p *Parser) parseWatchIgnore() []string {
@@ -202,40 +150,46 @@ func (p *Parser) parseWatchIgnore() []string {
 		return []string{}
 	}
 
-	p.nextToken()
-	p.skipNewlines()
+	return p.parsePatterns()
+}
 
-	if p.currentToken.Type == lexer.INDENT {
-		p.nextToken()
+// parsePatterns reads glob patterns, either comma-separated after the colon
+// or one per line in an indented block. Quotes and trailing comments are
+// removed.
+func (p *Parser) parsePatterns() []string {
+	text, lines := p.rawDirective()
+	if text != "" {
+		lines = splitPatterns(text)
 	}
 
-	var patterns []string
-
-	for p.currentToken.Type != lexer.DEDENT && p.currentToken.Type != lexer.EOF {
-		p.skipNewlines()
-
-		if p.currentToken.Type == lexer.DEDENT || p.currentToken.Type == lexer.EOF {
-			break
+	patterns := []string{}
+	for _, line := range lines {
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
 		}
-
-		if p.currentToken.Type == lexer.DEPS || p.currentToken.Type == lexer.RUN ||
-			p.currentToken.Type == lexer.ENV || p.currentToken.Type == lexer.WATCH ||
-			p.currentToken.Type == lexer.MATRIX || p.currentToken.Type == lexer.DOCKER ||
-			p.currentToken.Type == lexer.REMOTE {
-			break
-		}
-
-		if p.currentToken.Type == lexer.STRING || p.currentToken.Type == lexer.IDENT {
-			patterns = append(patterns, p.currentToken.Literal)
-			p.nextToken()
-		} else {
-			p.nextToken()
+		if line = strings.Trim(strings.TrimSpace(line), `"'`); line != "" {
+			patterns = append(patterns, line)
 		}
 	}
+	return patterns
+}
 
-	if p.currentToken.Type == lexer.DEDENT {
-		p.nextToken()
+// splitPatterns splits at commas outside {a,b} alternatives.
+func splitPatterns(text string) []string {
+	var patterns []string
+	depth, start := 0, 0
+	for i, ch := range text {
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				patterns = append(patterns, text[start:i])
+				start = i + 1
+			}
+		}
 	}
-
-	return patterns
+	return append(patterns, text[start:])
 }
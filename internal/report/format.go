@@ -2,6 +2,7 @@ package report
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"time"
 )
@@ -82,6 +83,11 @@ func (r *Report) Print() {
 	}
 	fmt.Printf("\n  Total time: %s%s%s\n", colorCyan, FormatDuration(r.TotalTime), colorReset)
 	fmt.Println()
+
+	if r.Analysis != nil {
+		r.Analysis.Print(os.Stdout)
+		fmt.Println()
+	}
 }
 
 func truncate(s string, max int) string {
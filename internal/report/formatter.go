@@ -0,0 +1,230 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Formatter writes a report in one file format.
+type Formatter interface {
+	Format(w io.Writer, r *Report) error
+}
+
+// WriteFile writes the report to path in the format of f.
+func (r *Report) WriteFile(path string, f Formatter) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Format(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// JSONFormatter writes the report as indented JSON.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(w io.Writer, r *Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// JUnitFormatter writes the report as JUnit XML, with one test case per
+// task. Cached tasks pass; skipped tasks are reported as skipped.
+type JUnitFormatter struct{}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func (JUnitFormatter) Format(w io.Writer, r *Report) error {
+	suite := junitSuite{
+		Name:      "flux",
+		Tests:     r.TotalTasks,
+		Failures:  r.Failed,
+		Skipped:   r.Skipped,
+		Time:      seconds(r.TotalTime.Seconds()),
+		Timestamp: r.StartTime.Format("2006-01-02T15:04:05"),
+	}
+	for _, task := range r.Tasks {
+		c := junitCase{
+			Name:      task.Name,
+			ClassName: "flux",
+			Time:      seconds(task.Duration.Seconds()),
+			SystemOut: task.Stdout,
+			SystemErr: task.Stderr,
+		}
+		switch task.Status {
+		case "failed":
+			c.Failure = &junitFailure{Message: firstLine(task.Error), Text: task.Error}
+		case "skipped":
+			c.Skipped = &junitSkipped{Message: "condition not met"}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	suites := junitSuites{
+		Name:     "flux",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// MarkdownFormatter writes the report as Markdown for pull request
+// comments: a summary, a table of tasks, and the error and output of each
+// failed task.
+type MarkdownFormatter struct{}
+
+var markdownStatus = map[string]string{
+	"passed":  "✅ passed",
+	"failed":  "❌ failed",
+	"cached":  "⚡ cached",
+	"skipped": "⏭️ skipped",
+}
+
+func (MarkdownFormatter) Format(w io.Writer, r *Report) error {
+	var b strings.Builder
+
+	b.WriteString("## Flux report\n\n")
+	fmt.Fprintf(&b, "**%d passed**, **%d failed**, %d cached, %d skipped in %s\n\n",
+		r.Passed, r.Failed, r.Cached, r.Skipped, FormatDuration(r.TotalTime))
+
+	b.WriteString("| Task | Status | Duration |\n")
+	b.WriteString("|------|--------|----------|\n")
+	for _, task := range r.Tasks {
+		duration := FormatDuration(task.Duration)
+		if task.Status == "cached" || task.Status == "skipped" {
+			duration = "-"
+		}
+		status := markdownStatus[task.Status]
+		if status == "" {
+			status = task.Status
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n", strings.ReplaceAll(task.Name, "|", "\\|"), status, duration)
+	}
+
+	if a := r.Analysis; a != nil {
+		fmt.Fprintf(&b, "\n### Critical path\n\n`%s` takes %s when its dependencies run in parallel and %s one after another.\n\n",
+			strings.Join(a.CriticalPath, "` → `"), FormatDuration(a.CriticalTime), FormatDuration(a.SequentialTime))
+		b.WriteString("| Task | Self time | Slack | Cache hits |\n")
+		b.WriteString("|------|-----------|-------|------------|\n")
+		for _, t := range a.Tasks {
+			name, slack := "`"+strings.ReplaceAll(t.Name, "|", "\\|")+"`", FormatDuration(t.Slack)
+			if t.Critical {
+				name, slack = "**"+name+"**", "-"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %d%% of %d |\n", name, FormatDuration(t.SelfTime), slack, int(t.CacheHitRate*100), t.Runs)
+		}
+		if len(a.Suggestions) > 0 {
+			b.WriteString("\n")
+			for _, s := range a.Suggestions {
+				fmt.Fprintf(&b, "- %s\n", s)
+			}
+		}
+	}
+
+	for _, task := range r.Tasks {
+		if task.Status != "failed" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### ❌ `%s`\n\n", task.Name)
+		if task.Error != "" {
+			writeCodeBlock(&b, task.Error)
+		}
+		for _, output := range []struct{ name, text string }{{"stdout", task.Stdout}, {"stderr", task.Stderr}} {
+			if output.text == "" {
+				continue
+			}
+			fmt.Fprintf(&b, "\n<details><summary>%s</summary>\n\n", output.name)
+			writeCodeBlock(&b, output.text)
+			b.WriteString("\n</details>\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeCodeBlock fences text with more backticks than it contains in a row.
+func writeCodeBlock(b *strings.Builder, text string) {
+	longest, run := 0, 0
+	for _, c := range text {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", max(3, longest+1))
+	fmt.Fprintf(b, "%s\n%s\n%s\n", fence, strings.TrimRight(text, "\n"), fence)
+}
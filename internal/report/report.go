@@ -1,10 +1,12 @@
 package report
 
 import (
-	"encoding/json"
-	"os"
+	"regexp"
 	"sync"
 	"time"
+
+	"github.com/ashavijit/fluxfile/internal/analyze"
+	"github.com/ashavijit/fluxfile/internal/secrets"
 )
 
 type TaskResult struct {
@@ -15,6 +17,16 @@ type TaskResult struct {
 	Error     string        `json:"error,omitempty"`
 	StartTime time.Time     `json:"start_time"`
 	EndTime   time.Time     `json:"end_time"`
+	Stdout    string        `json:"stdout,omitempty"`
+	Stderr    string        `json:"stderr,omitempty"`
+	// Worker is the lane the task ran on, numbered from 1; tasks that ran
+	// at the same time have different workers.
+	Worker int `json:"worker,omitempty"`
+	// WaitTime is how long the task was blocked on its dependencies.
+	WaitTime time.Duration `json:"wait_ns,omitempty"`
+	// InputHash is the hash of the task's inputs: files, or of its watch:
+	// files when it has no inputs.
+	InputHash string `json:"input_hash,omitempty"`
 }
 
 type Report struct {
@@ -27,12 +39,16 @@ type Report struct {
 	Failed     int           `json:"failed"`
 	Cached     int           `json:"cached"`
 	Skipped    int           `json:"skipped"`
+	// Analysis is the critical path of the target, from the timings of
+	// past runs.
+	Analysis *analyze.Analysis `json:"analysis,omitempty"`
 }
 
 type Collector struct {
 	mu        sync.Mutex
 	results   []TaskResult
 	startTime time.Time
+	masker    *secrets.Masker
 }
 
 func NewCollector() *Collector {
@@ -42,6 +58,13 @@ func NewCollector() *Collector {
 	}
 }
 
+// SetMasker redacts the secret values known to m from task errors.
+func (c *Collector) SetMasker(m *secrets.Masker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.masker = m
+}
+
 func (c *Collector) Add(name string, duration time.Duration, success bool, cached bool, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -61,13 +84,29 @@ func (c *Collector) Add(name string, duration time.Duration, success bool, cache
 	} else {
 		result.Status = "failed"
 		if err != nil {
-			result.Error = err.Error()
+			result.Error = c.masker.Mask(err.Error())
 		}
 	}
 
 	c.results = append(c.results, result)
 }
 
+// ansiEscape matches terminal color and cursor sequences, which have no
+// place in report files.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// AddResult records a result as given, redacting secrets from its error
+// and output.
+func (c *Collector) AddResult(result TaskResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result.Error = c.masker.Mask(result.Error)
+	result.Stdout = ansiEscape.ReplaceAllString(c.masker.Mask(result.Stdout), "")
+	result.Stderr = ansiEscape.ReplaceAllString(c.masker.Mask(result.Stderr), "")
+	c.results = append(c.results, result)
+}
+
 func (c *Collector) AddSkipped(name string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -110,9 +149,5 @@ func (c *Collector) Generate() *Report {
 }
 
 func (r *Report) WriteJSON(path string) error {
-	data, err := json.MarshalIndent(r, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, data, 0644)
+	return r.WriteFile(path, JSONFormatter{})
 }
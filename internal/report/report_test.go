@@ -1,10 +1,16 @@
 package report
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/ashavijit/fluxfile/internal/analyze"
 )
 
 func TestCollector(t *testing.T) {
@@ -95,3 +101,114 @@ func TestFormatSummary(t *testing.T) {
 		t.Error("Summary is empty")
 	}
 }
+
+func TestFormatters(t *testing.T) {
+	c := NewCollector()
+	c.AddResult(TaskResult{Name: "build", Status: "passed", Duration: 100 * time.Millisecond, Stdout: "\x1b[32mok\x1b[0m"})
+	c.AddResult(TaskResult{Name: "test", Status: "failed", Duration: 200 * time.Millisecond,
+		Error: "command failed: exit status 1", Stdout: "--- FAIL: TestX\n```", Stderr: "panic"})
+	c.AddSkipped("deploy")
+	report := c.Generate()
+
+	var junit bytes.Buffer
+	if err := (JUnitFormatter{}).Format(&junit, report); err != nil {
+		t.Fatalf("JUnit Format() error = %v", err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(junit.Bytes(), &suites); err != nil {
+		t.Fatalf("Invalid JUnit XML: %v\n%s", err, junit.String())
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 || len(suite.Cases) != 3 {
+		t.Errorf("Unexpected suite %+v", suite)
+	}
+	if suite.Cases[0].SystemOut != "ok" {
+		t.Errorf("Expected colors to be stripped from output, got %q", suite.Cases[0].SystemOut)
+	}
+	if f := suite.Cases[1].Failure; f == nil || f.Message != "command failed: exit status 1" || suite.Cases[1].SystemErr != "panic" {
+		t.Errorf("Unexpected failed case %+v", suite.Cases[1])
+	}
+	if suite.Cases[2].Skipped == nil {
+		t.Error("Expected the skipped task to be marked skipped")
+	}
+
+	var md bytes.Buffer
+	if err := (MarkdownFormatter{}).Format(&md, report); err != nil {
+		t.Fatalf("Markdown Format() error = %v", err)
+	}
+	for _, want := range []string{"| `build` | ✅ passed |", "### ❌ `test`", "````\n--- FAIL: TestX\n```\n````", "<summary>stderr</summary>"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("Expected Markdown to contain %q, got\n%s", want, md.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "report.xml")
+	if err := report.WriteFile(path, JUnitFormatter{}); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, junit.Bytes()) {
+		t.Error("Expected WriteFile to write the formatted report")
+	}
+
+	report.Analysis = &analyze.Analysis{
+		Target:       "test",
+		CriticalPath: []string{"build", "test"},
+		Tasks:        []analyze.Task{{Name: "build", Critical: true, Runs: 2}},
+		Suggestions:  []string{"Cache build"},
+	}
+	md.Reset()
+	if err := (MarkdownFormatter{}).Format(&md, report); err != nil {
+		t.Fatalf("Markdown Format() error = %v", err)
+	}
+	for _, want := range []string{"### Critical path", "`build` → `test`", "| **`build`** |", "- Cache build"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("Expected Markdown to contain %q, got\n%s", want, md.String())
+		}
+	}
+}
+
+func TestChromeTrace(t *testing.T) {
+	start := time.Now()
+	report := &Report{
+		StartTime: start,
+		Tasks: []TaskResult{
+			{Name: "lint", Status: "cached", CacheHit: true, Worker: 2, StartTime: start, EndTime: start.Add(time.Millisecond)},
+			{Name: "test", Status: "passed", Worker: 1, StartTime: start, EndTime: start.Add(2 * time.Second)},
+			{Name: "build", Status: "failed", Worker: 1, WaitTime: 2 * time.Second, Error: "exit status 1",
+				StartTime: start.Add(2 * time.Second), EndTime: start.Add(3 * time.Second)},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := (ChromeTraceFormatter{}).Format(&buf, report); err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	var trace traceFile
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("Invalid trace JSON: %v", err)
+	}
+
+	slices := make(map[string]traceEvent)
+	threads := 0
+	for _, event := range trace.TraceEvents {
+		switch {
+		case event.Phase == "X":
+			slices[event.Name] = event
+		case event.Name == "thread_name":
+			threads++
+		}
+	}
+	if threads != 2 {
+		t.Errorf("Expected a thread per worker, got %d", threads)
+	}
+	build := slices["build"]
+	if build.TS != 2000000 || build.Dur != 1000000 || build.TID != 1 {
+		t.Errorf("Unexpected build slice %+v", build)
+	}
+	if build.Args["wait_ms"] != float64(2000) || build.Args["error"] != "exit status 1" {
+		t.Errorf("Unexpected build args %v", build.Args)
+	}
+	if lint := slices["lint"]; lint.TID != 2 || lint.Args["cache_hit"] != true {
+		t.Errorf("Unexpected lint slice %+v", lint)
+	}
+}
@@ -0,0 +1,82 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ChromeTraceFormatter writes the report in the Chrome Trace Event format,
+// which Perfetto and chrome://tracing display as a timeline. Each task is a
+// slice on the row of the worker it ran on.
+type ChromeTraceFormatter struct{}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+type traceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	TS    int64                  `json:"ts"`
+	Dur   int64                  `json:"dur,omitempty"`
+	PID   int                    `json:"pid"`
+	TID   int                    `json:"tid"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+func (ChromeTraceFormatter) Format(w io.Writer, r *Report) error {
+	// Timestamps are microseconds since the start of the run.
+	micros := func(t time.Time) int64 {
+		return t.Sub(r.StartTime).Microseconds()
+	}
+
+	events := []traceEvent{{
+		Name: "process_name", Phase: "M", PID: 1,
+		Args: map[string]interface{}{"name": "flux"},
+	}}
+	workers := make(map[int]bool)
+	for _, task := range r.Tasks {
+		workers[task.Worker] = true
+		events = append(events, traceEvent{
+			Name:  task.Name,
+			Cat:   task.Status,
+			Phase: "X",
+			TS:    micros(task.StartTime),
+			Dur:   task.EndTime.Sub(task.StartTime).Microseconds(),
+			PID:   1,
+			TID:   task.Worker,
+			Args: map[string]interface{}{
+				"status":    task.Status,
+				"cache_hit": task.CacheHit,
+				"wait_ms":   task.WaitTime.Milliseconds(),
+			},
+		})
+		if task.Error != "" {
+			events[len(events)-1].Args["error"] = task.Error
+		}
+	}
+
+	ids := make([]int, 0, len(workers))
+	for id := range workers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		events = append(events, traceEvent{
+			Name: "thread_name", Phase: "M", PID: 1, TID: id,
+			Args: map[string]interface{}{"name": fmt.Sprintf("worker %d", id)},
+		})
+	}
+
+	data, err := json.MarshalIndent(traceFile{TraceEvents: events, DisplayTimeUnit: "ms"}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
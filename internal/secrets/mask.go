@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Masker replaces known secret values with ***. A nil Masker masks nothing.
+type Masker struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+func NewMasker() *Masker {
+	return &Masker{values: make(map[string]bool)}
+}
+
+// Add registers a secret value. Each line of a multi-line value is masked
+// on its own as well, since output is logged line by line.
+func (m *Masker) Add(value string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := false
+	for _, v := range append([]string{value}, strings.Split(value, "\n")...) {
+		if v = strings.TrimRight(v, "\r"); strings.TrimSpace(v) != "" && !m.values[v] {
+			m.values[v] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// Longer values go first so that a secret containing another is masked
+	// as a whole.
+	values := make([]string, 0, len(m.values))
+	for v := range m.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, "***")
+	}
+	m.replacer = strings.NewReplacer(pairs...)
+}
+
+// Mask returns s with every registered secret value replaced by ***.
+func (m *Masker) Mask(s string) string {
+	if m == nil {
+		return s
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
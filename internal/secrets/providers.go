@@ -0,0 +1,203 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ashavijit/fluxfile/internal/vars"
+)
+
+// envProvider reads the environment variable ref, or key when ref is empty.
+type envProvider struct{}
+
+func (envProvider) Lookup(ref, key string) (string, bool, error) {
+	if ref == "" {
+		ref = key
+	}
+	value, found := os.LookupEnv(ref)
+	return value, found, nil
+}
+
+type fileResult struct {
+	once sync.Once
+	vars map[string]string
+	err  error
+}
+
+// fileProvider reads key from the dotenv or JSON file at ref, loading each
+// file once. A directory holds one file per secret, named after the key.
+type fileProvider struct {
+	mu    sync.Mutex
+	load  func(path string) ([]byte, string, error)
+	files map[string]*fileResult
+}
+
+func newFileProvider(load func(path string) ([]byte, string, error)) *fileProvider {
+	return &fileProvider{load: load, files: make(map[string]*fileResult)}
+}
+
+func (p *fileProvider) Lookup(ref, key string) (string, bool, error) {
+	if ref == "" {
+		return "", false, fmt.Errorf("expected a file path")
+	}
+
+	if info, err := os.Stat(ref); err == nil && info.IsDir() {
+		data, err := os.ReadFile(filepath.Join(ref, key))
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+
+	p.mu.Lock()
+	result, ok := p.files[ref]
+	if !ok {
+		result = &fileResult{}
+		p.files[ref] = result
+	}
+	p.mu.Unlock()
+
+	result.once.Do(func() {
+		data, name, err := p.load(ref)
+		if os.IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			result.err = err
+			return
+		}
+		result.vars, result.err = vars.ParseFile(name, data)
+	})
+	if result.err != nil {
+		return "", false, result.err
+	}
+
+	value, found := result.vars[key]
+	return vars.Expand(value, result.vars), found, nil
+}
+
+func readFile(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	return data, path, err
+}
+
+// sopsDecrypt decrypts a sops file, which keeps the format of the original.
+func sopsDecrypt(path string) ([]byte, string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, "", err
+	}
+	data, err := run("sops", "--decrypt", path)
+	return data, path, err
+}
+
+// ageDecrypt decrypts an age file with the identity file named by
+// AGE_IDENTITY. The format is taken from the name without .age, as in
+// secrets.env.age.
+func ageDecrypt(path string) ([]byte, string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, "", err
+	}
+	identity := os.Getenv("AGE_IDENTITY")
+	if identity == "" {
+		return nil, "", fmt.Errorf("set AGE_IDENTITY to the age identity file to decrypt %s", path)
+	}
+	data, err := run("age", "--decrypt", "-i", identity, path)
+	return data, strings.TrimSuffix(path, ".age"), err
+}
+
+type commandResult struct {
+	once  sync.Once
+	value string
+	err   error
+}
+
+// commandProvider runs a command for each secret and uses its output as the
+// value. Each command runs once.
+type commandProvider struct {
+	mu      sync.Mutex
+	args    func(ref, key string) ([]string, bool, error)
+	results map[string]*commandResult
+}
+
+func (p *commandProvider) Lookup(ref, key string) (string, bool, error) {
+	args, firstLine, err := p.args(ref, key)
+	if err != nil {
+		return "", false, err
+	}
+
+	id := strings.Join(args, "\x00")
+	p.mu.Lock()
+	if p.results == nil {
+		p.results = make(map[string]*commandResult)
+	}
+	result, ok := p.results[id]
+	if !ok {
+		result = &commandResult{}
+		p.results[id] = result
+	}
+	p.mu.Unlock()
+
+	result.once.Do(func() {
+		output, err := run(args[0], args[1:]...)
+		if err != nil {
+			result.err = err
+			return
+		}
+		value := strings.TrimRight(string(output), "\r\n")
+		if firstLine {
+			value, _, _ = strings.Cut(value, "\n")
+			value = strings.TrimRight(value, "\r")
+		}
+		result.value = value
+	})
+	return result.value, result.err == nil, result.err
+}
+
+// passArgs reads the password, the first line of the entry, from pass.
+func passArgs(ref, key string) ([]string, bool, error) {
+	if ref == "" {
+		ref = key
+	}
+	return []string{"pass", "show", ref}, true, nil
+}
+
+func gopassArgs(ref, key string) ([]string, bool, error) {
+	if ref == "" {
+		ref = key
+	}
+	return []string{"gopass", "show", "-o", ref}, true, nil
+}
+
+func execArgs(ref, key string) ([]string, bool, error) {
+	command := strings.Trim(ref, `"`)
+	if command == "" {
+		return nil, false, fmt.Errorf("expected a command after exec:")
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"powershell.exe", "-Command", command}, false, nil
+	}
+	return []string{"sh", "-c", command}, false, nil
+}
+
+func run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s failed: %v: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s failed: %v", name, err)
+	}
+	return output, nil
+}
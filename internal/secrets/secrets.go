@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SecretProvider looks up secret values. ref is the part of a source after
+// the provider name, as in pass:ci/token, and may be empty.
+type SecretProvider interface {
+	Lookup(ref, key string) (value string, found bool, err error)
+}
+
+// Resolver resolves secrets through named providers. Providers that read
+// files or run commands cache their results for the life of the resolver.
+type Resolver struct {
+	mu        sync.Mutex
+	providers map[string]SecretProvider
+}
+
+// NewResolver returns a resolver with the built-in providers: env, file,
+// pass, gopass, sops, age and exec.
+func NewResolver() *Resolver {
+	return &Resolver{
+		providers: map[string]SecretProvider{
+			"env":    envProvider{},
+			"file":   newFileProvider(readFile),
+			"pass":   &commandProvider{args: passArgs},
+			"gopass": &commandProvider{args: gopassArgs},
+			"sops":   newFileProvider(sopsDecrypt),
+			"age":    newFileProvider(ageDecrypt),
+			"exec":   &commandProvider{args: execArgs},
+		},
+	}
+}
+
+// Register adds a provider or replaces a built-in one.
+func (r *Resolver) Register(name string, provider SecretProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = provider
+}
+
+// Resolve returns the value of the secret key from source, written as
+// provider or provider:ref. An empty source looks in the environment and
+// then in .env.
+func (r *Resolver) Resolve(key, source string) (string, error) {
+	if source == "" {
+		for _, fallback := range []string{"env", "file:.env"} {
+			value, found, err := r.lookup(key, fallback)
+			if err != nil {
+				return "", err
+			}
+			if found && value != "" {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("secret %s not found in environment or .env file", key)
+	}
+
+	value, found, err := r.lookup(key, source)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", key, err)
+	}
+	if !found {
+		return "", fmt.Errorf("secret %s not found in %s", key, source)
+	}
+	return value, nil
+}
+
+func (r *Resolver) lookup(key, source string) (string, bool, error) {
+	name, ref, _ := strings.Cut(source, ":")
+
+	r.mu.Lock()
+	provider, ok := r.providers[name]
+	r.mu.Unlock()
+	if !ok {
+		return "", false, fmt.Errorf("unknown secret provider %s", name)
+	}
+
+	return provider.Lookup(strings.TrimSpace(ref), key)
+}
@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "prod.env")
+	os.WriteFile(envFile, []byte("API_KEY=\"abc ${REGION}\"\nREGION=eu\n"), 0644)
+	jsonFile := filepath.Join(dir, "prod.json")
+	os.WriteFile(jsonFile, []byte(`{"DB_PASS": "hunter2"}`), 0644)
+	secretDir := filepath.Join(dir, "run")
+	os.Mkdir(secretDir, 0755)
+	os.WriteFile(filepath.Join(secretDir, "TOKEN"), []byte("t0ken\n"), 0644)
+
+	t.Setenv("FLUX_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		key    string
+		source string
+		want   string
+	}{
+		{"FLUX_TEST_SECRET", "", "from-env"},
+		{"FLUX_TEST_SECRET", "env", "from-env"},
+		{"OTHER", "env:FLUX_TEST_SECRET", "from-env"},
+		{"API_KEY", "file:" + envFile, "abc eu"},
+		{"DB_PASS", "file:" + jsonFile, "hunter2"},
+		{"TOKEN", "file:" + secretDir, "t0ken"},
+	}
+	if runtime.GOOS != "windows" {
+		tests = append(tests, struct {
+			key    string
+			source string
+			want   string
+		}{"CMD", `exec:"printf 'line1\nline2\n'"`, "line1\nline2"})
+	}
+
+	r := NewResolver()
+	for _, tt := range tests {
+		t.Run(tt.key+"/"+tt.source, func(t *testing.T) {
+			value, err := r.Resolve(tt.key, tt.source)
+			if err != nil {
+				t.Fatalf("Resolve failed: %v", err)
+			}
+			if value != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, value)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "prod.env")
+	os.WriteFile(envFile, []byte("A=1\n"), 0644)
+
+	tests := []struct {
+		key    string
+		source string
+		want   string
+	}{
+		{"FLUX_TEST_MISSING", "", "not found in environment or .env file"},
+		{"MISSING", "file:" + envFile, "secret MISSING not found in file:"},
+		{"A", "vault:x", "unknown secret provider vault"},
+		{"A", "file", "expected a file path"},
+		{"A", "exec:", "expected a command"},
+	}
+
+	r := NewResolver()
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := r.Resolve(tt.key, tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+type staticProvider map[string]string
+
+func (p staticProvider) Lookup(ref, key string) (string, bool, error) {
+	value, ok := p[ref+"/"+key]
+	return value, ok, nil
+}
+
+func TestRegister(t *testing.T) {
+	r := NewResolver()
+	r.Register("static", staticProvider{"ci/TOKEN": "s3cret"})
+
+	value, err := r.Resolve("TOKEN", "static:ci")
+	if err != nil || value != "s3cret" {
+		t.Errorf("Expected s3cret, got %q, %v", value, err)
+	}
+}
+
+func TestMasker(t *testing.T) {
+	var none *Masker
+	if none.Mask("token") != "token" {
+		t.Error("Expected a nil masker to mask nothing")
+	}
+
+	m := NewMasker()
+	m.Add("abc")
+	m.Add("abcdef")
+	m.Add("first\nsecond")
+	m.Add(" ")
+
+	tests := map[string]string{
+		"key=abcdef":         "key=***",
+		"key=abc and abcdef": "key=*** and ***",
+		"first\nsecond":      "***",
+		"second line":        "*** line",
+		"a b c":              "a b c",
+	}
+	for input, want := range tests {
+		if got := m.Mask(input); got != want {
+			t.Errorf("Mask(%q) = %q, expected %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exporter sends the spans of a trace to a tracing backend.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector with OTLP/HTTP,
+// in its JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the full URL spans are posted to, such as
+	// http://localhost:4318/v1/traces.
+	Endpoint string
+	Headers  map[string]string
+	Service  string
+	Client   *http.Client
+}
+
+// NewOTLPExporterFromEnv configures an OTLPExporter from the standard
+// OTEL_EXPORTER_OTLP_* and OTEL_SERVICE_NAME variables read by getenv. It
+// returns nil when no endpoint is set.
+func NewOTLPExporterFromEnv(getenv func(string) string) *OTLPExporter {
+	endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+
+	headers := getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")
+	if headers == "" {
+		headers = getenv("OTEL_EXPORTER_OTLP_HEADERS")
+	}
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Headers:  parseHeaders(headers),
+		Service:  ServiceName(getenv),
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ServiceName returns OTEL_SERVICE_NAME, or flux.
+func ServiceName(getenv func(string) string) string {
+	if name := getenv("OTEL_SERVICE_NAME"); name != "" {
+		return name
+	}
+	return "flux"
+}
+
+// parseHeaders parses headers in the form key1=value1,key2=value2, with
+// URL-encoded values.
+func parseHeaders(s string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if decoded, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	return headers
+}
+
+func (e *OTLPExporter) Export(spans []*Span) error {
+	body, err := Encode(e.Service, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans to %s: %w", e.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to export spans to %s: %s %s", e.Endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// FileExporter writes spans to a file in the OTLP JSON encoding, as an
+// export request that collectors and tools can read back.
+type FileExporter struct {
+	Path    string
+	Service string
+}
+
+func (e *FileExporter) Export(spans []*Span) error {
+	data, err := Encode(e.Service, spans)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(e.Path, append(data, '\n'), 0644)
+}
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest. IDs are hex and
+// 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+// Encode returns spans as an OTLP JSON export request from service.
+func Encode(service string, spans []*Span) ([]byte, error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "flux"}}
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+			Status:            otlpStatus{Code: statusOK},
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: statusError, Message: s.Error}
+		}
+		s.mu.Unlock()
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes(map[string]interface{}{"service.name": service})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+}
+
+// attributes converts attrs to OTLP attributes sorted by key. Values of
+// other types are written as strings.
+func attributes(attrs map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]otlpAttribute, 0, len(keys))
+	for _, key := range keys {
+		var v otlpValue
+		switch value := attrs[key].(type) {
+		case bool:
+			v.BoolValue = &value
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		result = append(result, otlpAttribute{Key: key, Value: v})
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Tracer records the spans of one invocation of flux, to be exported when
+// it ends. A nil Tracer records nothing, so callers need not check whether
+// tracing is on.
+type Tracer struct {
+	mu      sync.Mutex
+	traceID string
+	// parentID is the span of the process that started flux, if any.
+	parentID string
+	spans    []*Span
+}
+
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// NewTracer starts a trace. When traceparent is a W3C trace context, such
+// as the TRACEPARENT a parent process passed down, the trace continues it.
+func NewTracer(traceparent string) *Tracer {
+	t := &Tracer{traceID: randomID(16)}
+	if m := traceparentPattern.FindStringSubmatch(traceparent); m != nil && !zero(m[1]) && !zero(m[2]) {
+		t.traceID, t.parentID = m[1], m[2]
+	}
+	return t
+}
+
+// TraceID returns the ID of the trace as 32 hex digits.
+func (t *Tracer) TraceID() string {
+	if t == nil {
+		return ""
+	}
+	return t.traceID
+}
+
+// Start starts a span as a child of parent, or of the trace's parent when
+// parent is nil.
+func (t *Tracer) Start(name string, parent *Span) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{
+		tracer:     t,
+		TraceID:    t.traceID,
+		SpanID:     randomID(8),
+		ParentID:   t.parentID,
+		Name:       name,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+	if parent != nil {
+		s.ParentID = parent.SpanID
+	}
+	return s
+}
+
+// Spans returns the spans that have ended, in the order they ended.
+func (t *Tracer) Spans() []*Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Span(nil), t.spans...)
+}
+
+// Span is a timed operation within a trace, such as a task or a command.
+// Its methods do nothing on a nil Span.
+type Span struct {
+	mu         sync.Mutex
+	tracer     *Tracer
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]interface{}
+	// Error is the error the span ended with, if any.
+	Error string
+}
+
+// SetAttribute sets an attribute of the span. Values are strings, bools,
+// ints or float64s.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// End ends the span, failed when err is not nil. Spans end once; later
+// calls do nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.EndTime.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.EndTime = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// Traceparent returns the W3C trace context that makes the spans of a
+// child process children of this span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func zero(id string) bool {
+	for _, c := range id {
+		if c != '0' {
+			return false
+		}
+	}
+	return true
+}
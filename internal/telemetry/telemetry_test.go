@@ -0,0 +1,183 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewTracer(t *testing.T) {
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tracer := NewTracer(parent)
+	if tracer.TraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace of TRACEPARENT to continue, got %s", tracer.TraceID())
+	}
+	span := tracer.Start("flux build", nil)
+	if span.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("Expected the root span under the parent process, got %q", span.ParentID)
+	}
+	if got := span.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanID+"-01" {
+		t.Errorf("Unexpected traceparent %s", got)
+	}
+
+	for _, invalid := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		tracer := NewTracer(invalid)
+		if len(tracer.TraceID()) != 32 || tracer.Start("x", nil).ParentID != "" {
+			t.Errorf("Expected a new trace for traceparent %q", invalid)
+		}
+	}
+
+	var nilTracer *Tracer
+	nilSpan := nilTracer.Start("x", nil)
+	nilSpan.SetAttribute("key", "value")
+	nilSpan.End(nil)
+	if nilTracer.Spans() != nil || nilSpan.Traceparent() != "" {
+		t.Error("Expected a nil tracer to record nothing")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	tracer := NewTracer("")
+	root := tracer.Start("flux build", nil)
+	task := tracer.Start("build", root)
+	task.SetAttribute("flux.task.cache_hit", true)
+	if len(tracer.Spans()) != 0 {
+		t.Error("Expected spans to be recorded when they end")
+	}
+	task.End(errors.New("exit status 1"))
+	task.End(nil)
+	root.End(nil)
+
+	spans := tracer.Spans()
+	if len(spans) != 2 || spans[0] != task || spans[1] != root {
+		t.Fatalf("Expected the task and root spans once each, got %v", spans)
+	}
+	if task.ParentID != root.SpanID || task.TraceID != root.TraceID {
+		t.Errorf("Expected the task span under the root, got %+v", task)
+	}
+	if task.Error != "exit status 1" || task.EndTime.Before(task.StartTime) {
+		t.Errorf("Expected the first End to count, got %+v", task)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got otlpRequest
+	var path, contentType, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType, auth = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("Collector received invalid JSON: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	env := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": collector.URL + "/",
+		"OTEL_EXPORTER_OTLP_HEADERS":  "Authorization=Bearer%20token,X-Team=ci",
+		"OTEL_SERVICE_NAME":           "ci",
+	}
+	exporter := NewOTLPExporterFromEnv(func(key string) string { return env[key] })
+	if exporter == nil || exporter.Endpoint != collector.URL+"/v1/traces" {
+		t.Fatalf("Expected an exporter for the collector, got %+v", exporter)
+	}
+
+	tracer := NewTracer("")
+	root := tracer.Start("flux build", nil)
+	command := tracer.Start("go build", root)
+	command.SetAttribute("process.exit_code", 2)
+	command.SetAttribute("flux.task.cache_hit", false)
+	command.SetAttribute("flux.task.name", "build")
+	command.End(errors.New("exit status 2"))
+	root.End(nil)
+
+	if err := exporter.Export(tracer.Spans()); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if path != "/v1/traces" || contentType != "application/json" || auth != "Bearer token" {
+		t.Errorf("Unexpected request to %s with %s, %q", path, contentType, auth)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected request %+v", got)
+	}
+	if service := got.ResourceSpans[0].Resource.Attributes[0]; service.Key != "service.name" || *service.Value.StringValue != "ci" {
+		t.Errorf("Expected service ci, got %+v", service)
+	}
+
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	child, parent := spans[0], spans[1]
+	if child.ParentSpanID != parent.SpanID || child.TraceID != parent.TraceID || parent.ParentSpanID != "" {
+		t.Errorf("Expected go build under flux build, got %+v and %+v", child, parent)
+	}
+	if child.Status.Code != statusError || child.Status.Message != "exit status 2" || parent.Status.Code != statusOK {
+		t.Errorf("Unexpected statuses %+v and %+v", child.Status, parent.Status)
+	}
+	attrs := make(map[string]otlpValue)
+	for _, attr := range child.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	if v := attrs["process.exit_code"]; v.IntValue == nil || *v.IntValue != "2" {
+		t.Errorf("Expected exit code 2, got %+v", v)
+	}
+	if v := attrs["flux.task.cache_hit"]; v.BoolValue == nil || *v.BoolValue {
+		t.Errorf("Expected cache_hit false, got %+v", v)
+	}
+	if v := attrs["flux.task.name"]; v.StringValue == nil || *v.StringValue != "build" {
+		t.Errorf("Expected task name build, got %+v", v)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	exporter.Endpoint = failing.URL
+	if err := exporter.Export(tracer.Spans()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the collector's error, got %v", err)
+	}
+}
+
+func TestNewOTLPExporterFromEnv(t *testing.T) {
+	if exporter := NewOTLPExporterFromEnv(func(string) string { return "" }); exporter != nil {
+		t.Errorf("Expected no exporter without an endpoint, got %+v", exporter)
+	}
+
+	env := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://localhost:4318",
+		"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://traces:4318/custom",
+	}
+	exporter := NewOTLPExporterFromEnv(func(key string) string { return env[key] })
+	if exporter.Endpoint != "http://traces:4318/custom" || exporter.Service != "flux" {
+		t.Errorf("Expected the traces endpoint as is, got %+v", exporter)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	tracer := NewTracer("")
+	tracer.Start("flux test", nil).End(nil)
+
+	path := filepath.Join(t.TempDir(), "spans.json")
+	if err := (&FileExporter{Path: path, Service: "flux"}).Export(tracer.Spans()); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read spans: %v", err)
+	}
+	var got otlpRequest
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Expected OTLP JSON, got %v", err)
+	}
+	if spans := got.ResourceSpans[0].ScopeSpans[0].Spans; len(spans) != 1 || spans[0].Name != "flux test" || spans[0].TraceID != tracer.TraceID() {
+		t.Errorf("Unexpected spans %+v", spans)
+	}
+}
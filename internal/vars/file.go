@@ -0,0 +1,274 @@
+package vars
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Layer is a named set of vars. Later layers take precedence over earlier
+// ones.
+type Layer struct {
+	Source string
+	Vars   map[string]string
+}
+
+// Resolved is the effective value of a var and the layer it came from.
+type Resolved struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// ResolveLayers merges layers in order and expands every value against the
+// result. The returned vars are sorted by name. A $(shell ...) command that
+// fails is an error.
+func ResolveLayers(layers []Layer) ([]Resolved, error) {
+	merged := make(map[string]string)
+	sources := make(map[string]string)
+	for _, layer := range layers {
+		for k, v := range layer.Vars {
+			merged[k] = v
+			sources[k] = layer.Source
+		}
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resolved := make([]Resolved, 0, len(names))
+	for _, name := range names {
+		value, err := Interpolate(merged[name], merged, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+		}
+		resolved = append(resolved, Resolved{
+			Name:   name,
+			Value:  value,
+			Source: sources[name],
+		})
+	}
+	return resolved, nil
+}
+
+// References returns the names of all ${NAME} references in values and of
+// the list vars of each commands. Function calls are not included.
+func References(values ...string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, value := range values {
+		if match := eachPattern.FindStringSubmatch(value); match != nil {
+			if varNamePattern.FindString(match[2]) == match[2] && !seen[match[2]] {
+				seen[match[2]] = true
+				names = append(names, match[2])
+			}
+		}
+		for i := strings.Index(value, "${"); i >= 0; i = strings.Index(value, "${") {
+			value = value[i+2:]
+			name := varNamePattern.FindString(value)
+			if name != "" && !seen[name] && !strings.HasPrefix(value[len(name):], "(") {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// LoadFile reads vars from a JSON object or a dotenv file, chosen by the
+// file extension.
+func LoadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFile(path, data)
+}
+
+// ParseFile parses the contents of a JSON or dotenv file, chosen by the
+// extension of name.
+func ParseFile(name string, data []byte) (map[string]string, error) {
+	var vars map[string]string
+	var err error
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		vars, err = parseJSON(data)
+	} else {
+		vars, err = ParseDotenv(string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return vars, nil
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch value := v.(type) {
+		case string:
+			vars[k] = value
+		case float64:
+			vars[k] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			vars[k] = strconv.FormatBool(value)
+		case nil:
+			vars[k] = ""
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			vars[k] = strings.Join(items, " ")
+		default:
+			return nil, fmt.Errorf("value of %s must be a string, number, bool or list", k)
+		}
+	}
+	return vars, nil
+}
+
+// LoadDotenv reads dotenv files in order, later files taking precedence.
+// Paths may reference vars, as in .env.${MODE}; files that do not exist are
+// skipped.
+func LoadDotenv(paths []string, vars map[string]string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, path := range paths {
+		path, err := Interpolate(path, vars, false)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fileVars, err := ParseDotenv(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		result = MergeVars(result, fileVars)
+	}
+	return result, nil
+}
+
+// ParseDotenv parses KEY=value lines. Blank lines and # comments are
+// skipped and an "export " prefix is allowed. Double-quoted values support
+// \n, \t, \r, \", \\ and \$ escapes; single-quoted values are taken
+// literally. Quoted values may span several lines. ${...} references in
+// unquoted and double-quoted values are kept for interpolation.
+func ParseDotenv(content string) (map[string]string, error) {
+	vars := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+
+		key := strings.TrimSpace(line[:eq])
+		if varNamePattern.FindString(key) != key {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNo, key)
+		}
+
+		value := strings.TrimSpace(line[eq+1:])
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			if c := strings.Index(value, " #"); c >= 0 {
+				value = value[:c]
+			}
+			vars[key] = strings.TrimSpace(value)
+			continue
+		}
+
+		// A quoted value continues until its closing quote, which may be
+		// on a later line.
+		for !hasClosingQuote(value) {
+			if i+1 >= len(lines) {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", lineNo)
+			}
+			i++
+			value += "\n" + lines[i]
+		}
+
+		parsed, rest := parseQuoted(value)
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after quoted value", lineNo, rest)
+		}
+		vars[key] = parsed
+	}
+
+	return vars, nil
+}
+
+func hasClosingQuote(value string) bool {
+	quote := value[0]
+	for i := 1; i < len(value); i++ {
+		if quote == '"' && value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == quote {
+			return true
+		}
+	}
+	return false
+}
+
+// parseQuoted unquotes the value at the start of s and returns it with the
+// text after the closing quote.
+func parseQuoted(s string) (string, string) {
+	quote := s[0]
+	var sb strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == quote:
+			return sb.String(), s[i+1:]
+		case quote == '\'' && ch == '$' && strings.HasPrefix(s[i:], "${"):
+			sb.WriteString("$$")
+		case quote == '"' && ch == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '$':
+				sb.WriteByte('$')
+				if strings.HasPrefix(s[i+1:], "{") {
+					sb.WriteByte('$')
+				}
+			default:
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	return sb.String(), ""
+}
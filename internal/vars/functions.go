@@ -0,0 +1,184 @@
+package vars
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(args []string) (string, error)
+}
+
+var callPattern = regexp.MustCompile(`(?s)^([a-z_][a-z0-9_]*)\((.*)\)$`)
+
+var functions = map[string]function{
+	"upper": {1, 1, func(args []string) (string, error) {
+		return strings.ToUpper(args[0]), nil
+	}},
+	"lower": {1, 1, func(args []string) (string, error) {
+		return strings.ToLower(args[0]), nil
+	}},
+	"trim": {1, 2, func(args []string) (string, error) {
+		if len(args) == 2 {
+			return strings.Trim(args[0], args[1]), nil
+		}
+		return strings.TrimSpace(args[0]), nil
+	}},
+	"replace": {3, 3, func(args []string) (string, error) {
+		return strings.ReplaceAll(args[0], args[1], args[2]), nil
+	}},
+	"join": {2, 2, func(args []string) (string, error) {
+		return strings.Join(strings.Fields(args[0]), args[1]), nil
+	}},
+	"basename": {1, 1, func(args []string) (string, error) {
+		return filepath.Base(args[0]), nil
+	}},
+	"dirname": {1, 1, func(args []string) (string, error) {
+		return filepath.Dir(args[0]), nil
+	}},
+	"sha256": {1, 1, func(args []string) (string, error) {
+		sum := sha256.Sum256([]byte(args[0]))
+		return hex.EncodeToString(sum[:]), nil
+	}},
+	"now": {0, 1, func(args []string) (string, error) {
+		layout := time.RFC3339
+		if len(args) == 1 {
+			layout = args[0]
+		}
+		return time.Now().Format(layout), nil
+	}},
+	"git_sha": {0, 1, func(args []string) (string, error) {
+		out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+		if err != nil {
+			return "", fmt.Errorf("git rev-parse HEAD failed: %w", err)
+		}
+		sha := strings.TrimSpace(string(out))
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				return "", fmt.Errorf("invalid length %q", args[0])
+			}
+			if n < len(sha) {
+				sha = sha[:n]
+			}
+		}
+		return sha, nil
+	}},
+}
+
+func splitCall(ref string) (string, string, bool) {
+	match := callPattern.FindStringSubmatch(strings.TrimSpace(ref))
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// callFunction evaluates a ${name(args)} reference. Arguments are quoted
+// strings, numbers, var names, ${...} references or nested calls.
+func (in *interpolator) callFunction(name, rawArgs string, depth int) (string, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return "", fmt.Errorf("unknown function %s", name)
+	}
+
+	parts := splitArgs(rawArgs)
+	if len(parts) < fn.minArgs || len(parts) > fn.maxArgs {
+		if fn.minArgs == fn.maxArgs {
+			return "", fmt.Errorf("%s() takes %d argument(s), got %d", name, fn.minArgs, len(parts))
+		}
+		return "", fmt.Errorf("%s() takes %d to %d arguments, got %d", name, fn.minArgs, fn.maxArgs, len(parts))
+	}
+
+	args := make([]string, len(parts))
+	for i, part := range parts {
+		arg, err := in.evalArg(part, depth)
+		if err != nil {
+			return "", fmt.Errorf("%s(): %w", name, err)
+		}
+		args[i] = arg
+	}
+
+	result, err := fn.call(args)
+	if err != nil {
+		return "", fmt.Errorf("%s(): %w", name, err)
+	}
+	return result, nil
+}
+
+func (in *interpolator) evalArg(arg string, depth int) (string, error) {
+	switch {
+	case strings.HasPrefix(arg, `"`):
+		unquoted, err := strconv.Unquote(arg)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", arg)
+		}
+		return in.expand(unquoted, depth+1)
+	case strings.HasPrefix(arg, "'") && strings.HasSuffix(arg, "'") && len(arg) >= 2:
+		return arg[1 : len(arg)-1], nil
+	case strings.HasPrefix(arg, "${"):
+		return in.expand(arg, depth+1)
+	}
+
+	if fn, args, ok := splitCall(arg); ok {
+		return in.callFunction(fn, args, depth+1)
+	}
+	if _, err := strconv.ParseFloat(arg, 64); err == nil {
+		return arg, nil
+	}
+	if varNamePattern.FindString(arg) == arg {
+		value, defined, err := in.lookup(arg, depth)
+		if err != nil {
+			return "", err
+		}
+		if !defined && in.strict {
+			return "", fmt.Errorf("undefined variable %s", arg)
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("invalid argument %s", arg)
+}
+
+// splitArgs splits a comma-separated argument list, ignoring commas inside
+// quotes, parentheses and braces.
+func splitArgs(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	var args []string
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(' || ch == '{':
+			depth++
+		case ch == ')' || ch == '}':
+			depth--
+		case ch == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,144 @@
+package vars
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type shellResult struct {
+	once   sync.Once
+	output string
+	err    error
+}
+
+var shellCache = struct {
+	sync.Mutex
+	results map[string]*shellResult
+}{results: make(map[string]*shellResult)}
+
+// ResetShellCache forgets the output of $(shell ...) commands so that they
+// run again the next time they are expanded.
+func ResetShellCache() {
+	shellCache.Lock()
+	shellCache.results = make(map[string]*shellResult)
+	shellCache.Unlock()
+}
+
+// runShell runs command at most once until the cache is reset. Concurrent
+// callers wait for the same result.
+func runShell(command string) (string, error) {
+	shellCache.Lock()
+	result, ok := shellCache.results[command]
+	if !ok {
+		result = &shellResult{}
+		shellCache.results[command] = result
+	}
+	shellCache.Unlock()
+
+	result.once.Do(func() {
+		result.output, result.err = executeShellCommand(command)
+	})
+	return result.output, result.err
+}
+
+func executeShellCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("powershell.exe", "-Command", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("$(shell %q) failed: %v: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("$(shell %q) failed: %v", command, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func unquoteShell(quoted string) string {
+	if command, err := strconv.Unquote(quoted); err == nil {
+		return command
+	}
+	return strings.Trim(quoted, `"`)
+}
+
+// Resolve expands vars for a task that references the given names. Vars
+// that depend on $(shell ...), directly or through other vars, are only
+// evaluated when the task references them and are left out of the result
+// otherwise. Their commands run concurrently before expansion.
+func Resolve(vars map[string]string, names []string, strict bool) (map[string]string, error) {
+	used := dependencies(vars, names)
+
+	var wg sync.WaitGroup
+	for name := range used {
+		for _, match := range shellExprPattern.FindAllStringSubmatch(vars[name], -1) {
+			wg.Add(1)
+			go func(command string) {
+				defer wg.Done()
+				_, _ = runShell(command)
+			}(unquoteShell(match[1]))
+		}
+	}
+	wg.Wait()
+
+	strictIn := &interpolator{vars: vars, strict: strict, shell: true}
+	lenientIn := &interpolator{vars: vars, shell: true}
+
+	result := make(map[string]string, len(vars))
+	for name, value := range vars {
+		if !used[name] {
+			if usesShell(vars, name) {
+				continue
+			}
+			result[name], _ = lenientIn.expand(value, 0)
+			continue
+		}
+
+		expanded, err := strictIn.expand(value, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		result[name] = expanded
+	}
+	return result, nil
+}
+
+// dependencies returns names and every var they reference, transitively.
+func dependencies(vars map[string]string, names []string) map[string]bool {
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		value, ok := vars[name]
+		if !ok || seen[name] {
+			return
+		}
+		seen[name] = true
+		for _, ref := range References(value) {
+			visit(ref)
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return seen
+}
+
+func usesShell(vars map[string]string, name string) bool {
+	for dep := range dependencies(vars, []string{name}) {
+		if strings.Contains(vars[dep], "$(shell") {
+			return true
+		}
+	}
+	return false
+}
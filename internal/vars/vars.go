@@ -3,57 +3,204 @@ package vars
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"regexp"
-	"runtime"
 	"strings"
 )
 
-var shellExprPattern = regexp.MustCompile(`\$\(shell\s+"([^"]+)"\)`)
-var varPattern = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_-]*)\}`)
+var shellExprPattern = regexp.MustCompile(`\$\(shell\s+("(?:[^"\\]|\\.)*")\)(\?)?`)
+var varNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*`)
+var eachPattern = regexp.MustCompile(`(?s)^each\s+([a-zA-Z_][a-zA-Z0-9_]*)\s+in\s+(\S+?):\s+(.*)$`)
 
+const maxDepth = 32
+
+// Expand expands references in value, leaving anything it cannot resolve in
+// place for the shell.
 func Expand(value string, vars map[string]string) string {
-	result := value
+	result, _ := Interpolate(value, vars, false)
+	return result
+}
+
+// Interpolate expands ${NAME}, ${NAME:-default}, ${NAME:?message},
+// ${func(args)} and $(shell "command") references in value. Names missing
+// from vars are looked up in the process environment. In strict mode an
+// unresolved reference is an error; otherwise it is left as written.
+// $${...} produces a literal ${...} and $$(shell a literal $(shell.
+func Interpolate(value string, vars map[string]string, strict bool) (string, error) {
+	in := &interpolator{vars: vars, strict: strict, shell: true}
+	return in.expand(value, 0)
+}
+
+type interpolator struct {
+	vars   map[string]string
+	strict bool
+	// shell runs $(shell ...) commands; when false they are left as written.
+	shell bool
+}
+
+func (in *interpolator) expand(value string, depth int) (string, error) {
+	if depth > maxDepth {
+		return value, fmt.Errorf("variable expansion too deep, check for circular references")
+	}
+
+	var sb strings.Builder
+	var firstErr error
 
-	result = shellExprPattern.ReplaceAllStringFunc(result, func(match string) string {
-		matches := shellExprPattern.FindStringSubmatch(match)
-		if len(matches) < 2 {
-			return match
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$$(shell") {
+			sb.WriteString("$(shell")
+			i += len("$$(shell")
+			continue
+		}
+		if strings.HasPrefix(value[i:], "$(shell") {
+			if loc := shellExprPattern.FindStringSubmatchIndex(value[i:]); loc != nil && loc[0] == 0 {
+				match := value[i : i+loc[1]]
+				if in.shell {
+					optional := loc[4] >= 0
+					output, err := runShell(unquoteShell(value[i+loc[2] : i+loc[3]]))
+					if err != nil && !optional && firstErr == nil {
+						firstErr = err
+					}
+					sb.WriteString(output)
+				} else {
+					sb.WriteString(match)
+				}
+				i += len(match)
+				continue
+			}
 		}
-		command := matches[1]
-		return executeShellCommand(command)
-	})
 
-	result = varPattern.ReplaceAllStringFunc(result, func(match string) string {
-		matches := varPattern.FindStringSubmatch(match)
-		if len(matches) < 2 {
-			return match
+		escaped := strings.HasPrefix(value[i:], "$${")
+		if !escaped && !strings.HasPrefix(value[i:], "${") {
+			sb.WriteByte(value[i])
+			i++
+			continue
 		}
-		varName := matches[1]
-		if val, ok := vars[varName]; ok {
-			return Expand(val, vars)
+
+		start := i
+		if escaped {
+			start++
 		}
-		if val, ok := os.LookupEnv(varName); ok {
-			return val
+		end := matchBrace(value, start+2)
+		if end < 0 {
+			sb.WriteString(value[start:])
+			break
 		}
-		return match
-	})
 
-	return result
+		if escaped {
+			sb.WriteString(value[start : end+1])
+		} else {
+			result, err := in.resolveRef(value[start+2:end], depth)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			sb.WriteString(result)
+		}
+		i = end + 1
+	}
+
+	return sb.String(), firstErr
 }
 
-func executeShellCommand(command string) string {
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.Command("powershell.exe", "-Command", command)
-	} else {
-		cmd = exec.Command("sh", "-c", command)
+// matchBrace returns the index of the } closing the reference whose body
+// starts at start, skipping nested references and quoted strings.
+func matchBrace(s string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{':
+			depth++
+		case ch == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
 	}
-	output, err := cmd.Output()
+	return -1
+}
+
+func (in *interpolator) resolveRef(ref string, depth int) (string, error) {
+	literal := "${" + ref + "}"
+
+	if fn, args, ok := splitCall(ref); ok {
+		result, err := in.callFunction(fn, args, depth)
+		if err != nil {
+			return literal, err
+		}
+		return result, nil
+	}
+
+	name := varNamePattern.FindString(ref)
+	if name == "" {
+		return literal, nil
+	}
+	modifier := ref[len(name):]
+
+	value, defined, err := in.lookup(name, depth)
 	if err != nil {
-		return ""
+		return literal, err
 	}
-	return strings.TrimSpace(string(output))
+
+	switch {
+	case modifier == "":
+		if !defined {
+			if in.strict {
+				return literal, fmt.Errorf("undefined variable %s", name)
+			}
+			return literal, nil
+		}
+		return value, nil
+	case strings.HasPrefix(modifier, ":-"):
+		if defined && value != "" {
+			return value, nil
+		}
+		result, err := in.expand(modifier[2:], depth+1)
+		if err != nil {
+			return literal, err
+		}
+		return result, nil
+	case strings.HasPrefix(modifier, ":?"):
+		if defined && value != "" {
+			return value, nil
+		}
+		message := strings.TrimSpace(modifier[2:])
+		if message == "" {
+			message = "is required"
+		}
+		return literal, fmt.Errorf("%s: %s", name, message)
+	}
+
+	return literal, nil
+}
+
+// lookup returns the expanded value of a FluxFile var, or the raw value of
+// an environment variable.
+func (in *interpolator) lookup(name string, depth int) (string, bool, error) {
+	if value, ok := in.vars[name]; ok {
+		expanded, err := in.expand(value, depth+1)
+		return expanded, true, err
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// Escape returns value with its references escaped, so that expanding the
+// result yields value unchanged.
+func Escape(value string) string {
+	value = strings.ReplaceAll(value, "${", "$${")
+	return strings.ReplaceAll(value, "$(shell", "$$(shell")
 }
 
 func ExpandMap(m map[string]string, vars map[string]string) map[string]string {
@@ -64,6 +211,60 @@ func ExpandMap(m map[string]string, vars map[string]string) map[string]string {
 	return result
 }
 
+// ExpandCommands interpolates run commands. A command of the form
+//
+//	each NAME in LIST: command
+//
+// is repeated for every whitespace-separated item of the LIST var with NAME
+// set to the item.
+func ExpandCommands(commands []string, vars map[string]string, strict bool) ([]string, error) {
+	var result []string
+	for _, command := range commands {
+		match := eachPattern.FindStringSubmatch(command)
+		if match == nil {
+			expanded, err := Interpolate(command, vars, strict)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expanded)
+			continue
+		}
+
+		items, err := listItems(match[2], vars, strict)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			expanded, err := Interpolate(match[3], MergeVars(vars, map[string]string{match[1]: item}), strict)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expanded)
+		}
+	}
+	return result, nil
+}
+
+func listItems(list string, vars map[string]string, strict bool) ([]string, error) {
+	if varNamePattern.FindString(list) == list {
+		in := &interpolator{vars: vars, strict: strict, shell: true}
+		value, defined, err := in.lookup(list, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !defined {
+			return nil, fmt.Errorf("undefined list variable %s", list)
+		}
+		return strings.Fields(value), nil
+	}
+
+	value, err := Interpolate(list, vars, strict)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(value), nil
+}
+
 func ExpandSlice(s []string, vars map[string]string) []string {
 	result := make([]string, len(s))
 	for i, v := range s {
@@ -83,12 +284,15 @@ func MergeVars(base, overlay map[string]string) map[string]string {
 	return result
 }
 
+// ResolveVars expands references between vars in place. $(shell ...)
+// commands are left for Resolve so that they only run when a task uses them.
 func ResolveVars(vars map[string]string) error {
+	in := &interpolator{vars: vars}
 	maxIterations := 100
 	for i := 0; i < maxIterations; i++ {
 		changed := false
 		for k, v := range vars {
-			expanded := Expand(v, vars)
+			expanded, _ := in.expand(v, 0)
 			if expanded != v {
 				vars[k] = expanded
 				changed = true
@@ -1,7 +1,12 @@
 package vars
 
 import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestExpand(t *testing.T) {
@@ -50,3 +55,338 @@ func TestMergeVars(t *testing.T) {
 		t.Error("Expected C=4")
 	}
 }
+
+func TestInterpolateDefaultsAndRequired(t *testing.T) {
+	vars := map[string]string{
+		"MODE":  "prod",
+		"EMPTY": "",
+		"OUT":   "${DIR:-dist}/bin",
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  string
+	}{
+		{"${MODE:-dev}", "prod", ""},
+		{"${EMPTY:-dev}", "dev", ""},
+		{"${MISSING_FLUX_VAR:-${MODE}-x}", "prod-x", ""},
+		{"${OUT}", "dist/bin", ""},
+		{"${MODE:?set MODE}", "prod", ""},
+		{"${MISSING_FLUX_VAR:?set it first}", "", "MISSING_FLUX_VAR: set it first"},
+		{"${EMPTY:?}", "", "EMPTY: is required"},
+		{"$${MODE}", "${MODE}", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := Interpolate(tt.input, vars, false)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestInterpolateStrict(t *testing.T) {
+	vars := map[string]string{"A": "${A}"}
+
+	if result, err := Interpolate("echo ${MISSING_FLUX_VAR}", nil, false); err != nil || result != "echo ${MISSING_FLUX_VAR}" {
+		t.Errorf("Expected reference to be kept, got %q, %v", result, err)
+	}
+
+	_, err := Interpolate("echo ${MISSING_FLUX_VAR}", nil, true)
+	if err == nil || err.Error() != "undefined variable MISSING_FLUX_VAR" {
+		t.Errorf("Expected undefined variable error, got %v", err)
+	}
+
+	if result, err := Interpolate("echo $${i}", nil, true); err != nil || result != "echo ${i}" {
+		t.Errorf("Expected escaped reference, got %q, %v", result, err)
+	}
+
+	if _, err := Interpolate("${A}", vars, false); err == nil {
+		t.Error("Expected error for self-referencing variable")
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	vars := map[string]string{
+		"NAME":    "Flux",
+		"VERSION": "1.2.3",
+		"PATH_":   "/usr/local/bin/flux",
+		"TARGETS": "linux darwin",
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"${upper(NAME)}", "FLUX"},
+		{"${lower(NAME)}", "flux"},
+		{`${replace(VERSION, ".", "_")}`, "1_2_3"},
+		{`${trim("  x  ")}`, "x"},
+		{`${trim("--x--", "-")}`, "x"},
+		{`${join(TARGETS, ",")}`, "linux,darwin"},
+		{"${basename(PATH_)}", "flux"},
+		{"${dirname(PATH_)}", "/usr/local/bin"},
+		{`${sha256("flux")}`, "a2e10207c7be30e1d07b0b7e353ecc1a1364f39057e1acedd3f76c5d2ceed180"},
+		{`${upper(replace(NAME, "F", "fl"))}`, "FLLUX"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := Interpolate(tt.input, vars, true)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+
+	year, err := Interpolate(`${now("2006")}`, vars, true)
+	if err != nil || year != time.Now().Format("2006") {
+		t.Errorf("Expected current year, got %q, %v", year, err)
+	}
+
+	if _, err := Interpolate("${nope(NAME)}", vars, false); err == nil {
+		t.Error("Expected unknown function error")
+	}
+	if _, err := Interpolate("${upper(NAME, NAME)}", vars, false); err == nil {
+		t.Error("Expected arity error")
+	}
+}
+
+func TestExpandCommandsEach(t *testing.T) {
+	vars := map[string]string{"TARGETS": "linux darwin", "APP": "flux"}
+
+	commands, err := ExpandCommands([]string{
+		"echo start",
+		"each OS in TARGETS: GOOS=${OS} go build -o bin/${APP}-${OS}",
+	}, vars, true)
+	if err != nil {
+		t.Fatalf("ExpandCommands error: %v", err)
+	}
+
+	expected := []string{
+		"echo start",
+		"GOOS=linux go build -o bin/flux-linux",
+		"GOOS=darwin go build -o bin/flux-darwin",
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("Expected %d commands, got %q", len(expected), commands)
+	}
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], commands[i])
+		}
+	}
+
+	if _, err := ExpandCommands([]string{"each X in NOPE: echo ${X}"}, vars, false); err == nil {
+		t.Error("Expected error for undefined list")
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	content := `# comment
+export MODE=prod
+REGION = eu # inline comment
+MESSAGE="line1\nline2"
+RAW='${NOT_EXPANDED}'
+EMPTY=
+URL="https://${REGION}.example.com" # comment
+CERT="-----BEGIN-----
+abc \"quoted\"
+-----END-----"
+PRICE="\${literal}"
+`
+
+	vars, err := ParseDotenv(content)
+	if err != nil {
+		t.Fatalf("ParseDotenv error: %v", err)
+	}
+
+	expected := map[string]string{
+		"MODE":    "prod",
+		"REGION":  "eu",
+		"MESSAGE": "line1\nline2",
+		"RAW":     "${NOT_EXPANDED}",
+		"EMPTY":   "",
+		"URL":     "https://eu.example.com",
+		"CERT":    "-----BEGIN-----\nabc \"quoted\"\n-----END-----",
+		"PRICE":   "${literal}",
+	}
+	for k, v := range expected {
+		if got := Expand(vars[k], vars); got != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, got)
+		}
+	}
+
+	if _, err := ParseDotenv("NOT AN ASSIGNMENT"); err == nil {
+		t.Error("Expected error for invalid line")
+	}
+	if _, err := ParseDotenv("KEY=\"unterminated\nOTHER=1"); err == nil {
+		t.Error("Expected error for unterminated value")
+	}
+}
+
+func TestLoadFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vars.json")
+	os.WriteFile(path, []byte(`{"MODE": "prod", "REPLICAS": 3, "DEBUG": false, "TARGETS": ["linux", "darwin"]}`), 0644)
+
+	vars, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+
+	if vars["MODE"] != "prod" || vars["REPLICAS"] != "3" || vars["DEBUG"] != "false" || vars["TARGETS"] != "linux darwin" {
+		t.Errorf("Unexpected vars %v", vars)
+	}
+}
+
+func TestResolveLayers(t *testing.T) {
+	resolved, err := ResolveLayers([]Layer{
+		{Source: "FluxFile", Vars: map[string]string{"MODE": "dev", "URL": "https://${HOST}", "HOST": "localhost"}},
+		{Source: "profile prod", Vars: map[string]string{"MODE": "prod"}},
+		{Source: "command line", Vars: map[string]string{"HOST": "example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("ResolveLayers failed: %v", err)
+	}
+
+	expected := []Resolved{
+		{Name: "HOST", Value: "example.com", Source: "command line"},
+		{Name: "MODE", Value: "prod", Source: "profile prod"},
+		{Name: "URL", Value: "https://example.com", Source: "FluxFile"},
+	}
+	if len(resolved) != len(expected) {
+		t.Fatalf("Expected %d vars, got %v", len(expected), resolved)
+	}
+	for i := range expected {
+		if resolved[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], resolved[i])
+		}
+	}
+}
+
+func TestResolveLayersShellError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ResetShellCache()
+
+	_, err := ResolveLayers([]Layer{
+		{Source: "FluxFile", Vars: map[string]string{"SHA": `$(shell "echo no repository >&2; exit 1")`}},
+	})
+	if err == nil {
+		t.Fatal("Expected the failing command to be an error")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "SHA") || !strings.Contains(msg, "no repository") {
+		t.Errorf("Expected the var and the command's stderr in the error, got %q", msg)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	names := References("echo ${A} ${B:-x}", "${upper(C)} ${A}", "each T in TARGETS: go build")
+	if len(names) != 3 || names[0] != "A" || names[1] != "B" || names[2] != "TARGETS" {
+		t.Errorf("Expected [A B TARGETS], got %v", names)
+	}
+}
+
+func TestShellVars(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	ResetShellCache()
+	defer ResetShellCache()
+
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "count")
+	marker := filepath.Join(dir, "unused")
+	vars := map[string]string{
+		"COUNT":    `$(shell "echo x >> ` + counter + `; echo ok")`,
+		"LABEL":    "v-${COUNT}",
+		"UNUSED":   `$(shell "touch ` + marker + `")`,
+		"FAILING":  `$(shell "echo boom >&2; exit 3")`,
+		"OPTIONAL": `$(shell "exit 1")?`,
+		"PLAIN":    "plain",
+	}
+
+	if err := ResolveVars(vars); err != nil {
+		t.Fatalf("ResolveVars error: %v", err)
+	}
+	if _, err := os.Stat(counter); err == nil {
+		t.Fatal("ResolveVars should not run shell commands")
+	}
+
+	env, err := Resolve(vars, []string{"LABEL", "COUNT", "OPTIONAL"}, false)
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	if env["LABEL"] != "v-ok" || env["COUNT"] != "ok" || env["OPTIONAL"] != "" || env["PLAIN"] != "plain" {
+		t.Errorf("Unexpected env %v", env)
+	}
+	if _, ok := env["UNUSED"]; ok {
+		t.Error("Unreferenced shell var should be left out")
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("Unreferenced shell var should not run")
+	}
+
+	if _, err := Interpolate("${COUNT}", vars, false); err != nil {
+		t.Fatalf("Interpolate error: %v", err)
+	}
+	data, _ := os.ReadFile(counter)
+	if strings.Count(string(data), "x") != 1 {
+		t.Errorf("Expected the command to run once, ran %d times", strings.Count(string(data), "x"))
+	}
+
+	_, err = Resolve(vars, []string{"FAILING"}, false)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3: boom") {
+		t.Errorf("Expected error with stderr, got %v", err)
+	}
+}
+
+func TestLoadDotenv(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("MODE=dev\nPORT=3000\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".env.prod"), []byte("PORT=80\n"), 0644)
+
+	paths := []string{
+		filepath.Join(dir, ".env"),
+		filepath.Join(dir, ".env.${MODE}"),
+		filepath.Join(dir, ".env.missing"),
+	}
+	vars, err := LoadDotenv(paths, map[string]string{"MODE": "prod"})
+	if err != nil {
+		t.Fatalf("LoadDotenv error: %v", err)
+	}
+	if vars["MODE"] != "dev" || vars["PORT"] != "80" {
+		t.Errorf("Unexpected vars %v", vars)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	values := []string{
+		`p@ss${HOME}`,
+		`$(shell "rm -rf /")`,
+		`$${x} and $$(shell`,
+		`unclosed ${x`,
+	}
+	for _, value := range values {
+		result, err := Interpolate(Escape(value), map[string]string{"HOME": "/root"}, true)
+		if err != nil || result != value {
+			t.Errorf("Interpolate(Escape(%q)) = %q, %v", value, result, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// backend reports changes to the files in the directories added to it.
+type backend interface {
+	Add(dir string) error
+	Remove(dir string) error
+	Close() error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+}
+
+type notifyBackend struct {
+	watcher *fsnotify.Watcher
+}
+
+func (b *notifyBackend) Add(dir string) error          { return b.watcher.Add(dir) }
+func (b *notifyBackend) Remove(dir string) error       { return b.watcher.Remove(dir) }
+func (b *notifyBackend) Close() error                  { return b.watcher.Close() }
+func (b *notifyBackend) Events() <-chan fsnotify.Event { return b.watcher.Events }
+func (b *notifyBackend) Errors() <-chan error          { return b.watcher.Errors }
+
+// poller finds changes by listing the added directories at an interval. It
+// works where file system events do not, such as on network file systems
+// and bind mounts, at the cost of latency.
+type poller struct {
+	mu       sync.Mutex
+	interval time.Duration
+	dirs     map[string]map[string]fileState
+
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	dir     bool
+}
+
+func newPoller(interval time.Duration) *poller {
+	p := &poller{
+		interval: interval,
+		dirs:     make(map[string]map[string]fileState),
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *poller) Add(dir string) error {
+	files, err := scan(dir)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.dirs[dir] = files
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *poller) Remove(dir string) error {
+	p.mu.Lock()
+	delete(p.dirs, dir)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *poller) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+func (p *poller) Events() <-chan fsnotify.Event { return p.events }
+func (p *poller) Errors() <-chan error          { return p.errors }
+
+func (p *poller) setInterval(d time.Duration) {
+	p.mu.Lock()
+	p.interval = d
+	p.mu.Unlock()
+}
+
+func (p *poller) run() {
+	defer close(p.events)
+	for {
+		p.mu.Lock()
+		interval := p.interval
+		p.mu.Unlock()
+
+		select {
+		case <-p.done:
+			return
+		case <-time.After(interval):
+		}
+
+		for _, event := range p.poll() {
+			select {
+			case p.events <- event:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// poll lists every directory and returns the differences to the previous
+// listing as events.
+func (p *poller) poll() []fsnotify.Event {
+	p.mu.Lock()
+	dirs := make([]string, 0, len(p.dirs))
+	for dir := range p.dirs {
+		dirs = append(dirs, dir)
+	}
+	p.mu.Unlock()
+
+	var events []fsnotify.Event
+	for _, dir := range dirs {
+		files, err := scan(dir)
+		if err != nil {
+			// The directory is gone; its parent reports the removal.
+			p.Remove(dir)
+			continue
+		}
+
+		p.mu.Lock()
+		old, ok := p.dirs[dir]
+		if ok {
+			p.dirs[dir] = files
+		}
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		for name, state := range files {
+			path := filepath.Join(dir, name)
+			before, existed := old[name]
+			switch {
+			case !existed:
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+			case !state.dir && (state.size != before.size || !state.modTime.Equal(before.modTime)):
+				events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+		}
+		for name := range old {
+			if _, exists := files[name]; !exists {
+				events = append(events, fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+			}
+		}
+	}
+	return events
+}
+
+func scan(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files[entry.Name()] = fileState{modTime: info.ModTime(), size: info.Size(), dir: entry.IsDir()}
+	}
+	return files, nil
+}
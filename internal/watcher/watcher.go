@@ -1,73 +1,180 @@
 package watcher
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/ashavijit/fluxfile/internal/glob"
 	"github.com/ashavijit/fluxfile/internal/logger"
 	"github.com/fsnotify/fsnotify"
 )
 
+// defaultPollInterval is how often a polling watcher lists directories.
+const defaultPollInterval = 500 * time.Millisecond
+
 type Watcher struct {
-	watcher  *fsnotify.Watcher
+	interval time.Duration
 	patterns []string
-	callback func()
+	ignore   []string
+	callback func(changed []string)
 	logger   *logger.Logger
 	debounce time.Duration
+	cwd      string
+
+	// roots are the directories the patterns are relative to; recursive
+	// roots have their subdirectories watched as well.
+	roots map[string]bool
+
+	// mu guards the fields below. The backend changes when the watcher
+	// falls back to polling, while Stop may be closing it.
+	mu      sync.Mutex
+	backend backend
+	polling bool
+	stopped bool
+	watched map[string]bool
+	changed []string
+	timer   *time.Timer
 }
 
-func New(patterns []string, callback func()) (*Watcher, error) {
-	w, err := fsnotify.NewWatcher()
+// New returns a watcher for files matching patterns. The callback receives
+// the absolute paths of the files that changed. When file system events are
+// unavailable, the watcher polls instead.
+func New(patterns []string, callback func(changed []string)) (*Watcher, error) {
+	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Watcher{
-		watcher:  w,
+	w := &Watcher{
+		cwd:      cwd,
+		interval: defaultPollInterval,
 		patterns: patterns,
 		callback: callback,
 		logger:   logger.New(),
 		debounce: 100 * time.Millisecond,
-	}, nil
+		roots:    make(map[string]bool),
+		watched:  make(map[string]bool),
+	}
+
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		w.mu.Lock()
+		w.fallback(err)
+		w.mu.Unlock()
+	} else {
+		w.backend = &notifyBackend{watcher: notify}
+	}
+	return w, nil
+}
+
+// SetDebounce sets how long the watcher waits after the last change before
+// running the callback.
+func (w *Watcher) SetDebounce(d time.Duration) {
+	w.debounce = d
+}
+
+// SetPollInterval sets how often a polling watcher looks for changes.
+func (w *Watcher) SetPollInterval(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.interval = d
+	if p, ok := w.backend.(*poller); ok {
+		p.setInterval(d)
+	}
+}
+
+// UsePolling makes the watcher poll for changes instead of relying on file
+// system events, which some network file systems and bind mounts do not
+// deliver.
+func (w *Watcher) UsePolling() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.polling {
+		w.backend.Close()
+		w.backend = newPoller(w.interval)
+		w.polling = true
+	}
+}
+
+// fallback switches to polling after file system events failed with err.
+// w.mu must be held.
+func (w *Watcher) fallback(err error) {
+	if w.stopped {
+		return
+	}
+	reason := err.Error()
+	if errors.Is(err, syscall.ENOSPC) {
+		reason = "inotify watch limit reached, see fs.inotify.max_user_watches"
+	}
+	w.logger.Warn(fmt.Sprintf("File system events are unavailable (%s), polling for changes every %s", reason, w.interval))
+	if w.backend != nil {
+		w.backend.Close()
+	}
+	w.backend = newPoller(w.interval)
+	w.polling = true
+
+	for dir := range w.watched {
+		if err := w.backend.Add(dir); err != nil {
+			delete(w.watched, dir)
+		}
+	}
+}
+
+// SetIgnore sets patterns for files and directories whose changes are
+// ignored. A leading ! is allowed, as in !vendor/**.
+func (w *Watcher) SetIgnore(patterns []string) {
+	w.ignore = nil
+	for _, pattern := range patterns {
+		w.ignore = append(w.ignore, strings.TrimPrefix(pattern, "!"))
+	}
 }
 
+// Start watches the directories the patterns can match in and runs the
+// callback once changes settle. Directories created later are watched as
+// they appear, and removed or renamed files count as changes.
 func (w *Watcher) Start() error {
+	for _, pattern := range w.patterns {
+		root, err := filepath.Abs(glob.Base(pattern))
+		if err != nil {
+			return err
+		}
+		recursive := glob.Recursive(pattern)
+		w.roots[root] = w.roots[root] || recursive
+		w.addDir(root, recursive)
+	}
+
 	files, err := w.expandPatterns()
 	if err != nil {
 		return err
 	}
-
-	for _, file := range files {
-		if err := w.watcher.Add(file); err != nil {
-			w.logger.Warn(fmt.Sprintf("Failed to watch %s: %v", file, err))
-		}
+	w.mu.Lock()
+	polling, dirs := w.polling, len(w.watched)
+	w.mu.Unlock()
+	if polling {
+		w.logger.Info(fmt.Sprintf("Watching %d files in %d directories, polling every %s...", len(files), dirs, w.interval))
+	} else {
+		w.logger.Info(fmt.Sprintf("Watching %d files in %d directories...", len(files), dirs))
 	}
 
-	w.logger.Info(fmt.Sprintf("Watching %d files...", len(files)))
-
-	var timer *time.Timer
+	// The backend is read on every iteration since handle may switch to
+	// polling.
 	for {
+		b := w.current()
 		select {
-		case event, ok := <-w.watcher.Events:
+		case event, ok := <-b.Events():
 			if !ok {
 				return nil
 			}
+			w.handle(event)
 
-			if event.Op&fsnotify.Write == fsnotify.Write ||
-				event.Op&fsnotify.Create == fsnotify.Create {
-
-				if timer != nil {
-					timer.Stop()
-				}
-
-				timer = time.AfterFunc(w.debounce, func() {
-					w.logger.Info(fmt.Sprintf("File changed: %s", event.Name))
-					w.callback()
-				})
-			}
-
-		case err, ok := <-w.watcher.Errors:
+		case err, ok := <-b.Errors():
 			if !ok {
 				return nil
 			}
@@ -77,7 +184,192 @@ func (w *Watcher) Start() error {
 }
 
 func (w *Watcher) Stop() error {
-	return w.watcher.Close()
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.stopped = true
+	b := w.backend
+	w.mu.Unlock()
+	return b.Close()
+}
+
+func (w *Watcher) current() backend {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.backend
+}
+
+func (w *Watcher) handle(event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod || w.ignored(event.Name) {
+		return
+	}
+
+	if event.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if !w.inRecursiveRoot(event.Name) {
+				return
+			}
+			// Files may have been created before the watch was added.
+			w.addDir(event.Name, true)
+			_ = filepath.WalkDir(event.Name, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() && w.matches(path) {
+					w.schedule(path)
+				}
+				return nil
+			})
+			return
+		}
+	}
+
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		w.forgetDir(event.Name)
+	}
+
+	if w.matches(event.Name) {
+		w.schedule(event.Name)
+	}
+}
+
+// schedule records a changed file and restarts the debounce timer.
+func (w *Watcher) schedule(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, c := range w.changed {
+		if c == path {
+			path = ""
+			break
+		}
+	}
+	if path != "" {
+		w.changed = append(w.changed, path)
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.debounce, w.fire)
+}
+
+func (w *Watcher) fire() {
+	w.mu.Lock()
+	changed := w.changed
+	w.changed = nil
+	w.mu.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	if len(changed) == 1 {
+		w.logger.Info(fmt.Sprintf("File changed: %s", w.display(changed[0])))
+	} else {
+		w.logger.Info(fmt.Sprintf("%d files changed, including %s", len(changed), w.display(changed[len(changed)-1])))
+	}
+	w.callback(changed)
+}
+
+// addDir watches dir and, when recursive, the directories below it that
+// are not ignored.
+func (w *Watcher) addDir(dir string, recursive bool) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && (!recursive || w.ignored(path)) {
+			return filepath.SkipDir
+		}
+		if err := w.add(path); err != nil {
+			w.logger.Warn(fmt.Sprintf("Failed to watch %s: %v", path, err))
+		}
+		return nil
+	})
+}
+
+// add watches dir unless it is watched already, switching to polling when
+// file system events are unavailable.
+func (w *Watcher) add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watched[dir] {
+		return nil
+	}
+	err := w.backend.Add(dir)
+	if err != nil && !w.polling && unsupported(err) {
+		w.fallback(err)
+		err = w.backend.Add(dir)
+	}
+	if err == nil {
+		w.watched[dir] = true
+	}
+	return err
+}
+
+// forgetDir drops the watches of a removed or renamed directory and the
+// directories below it.
+func (w *Watcher) forgetDir(dir string) {
+	prefix := dir + string(filepath.Separator)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path := range w.watched {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.watched, path)
+			_ = w.backend.Remove(path)
+		}
+	}
+}
+
+// unsupported reports whether err means that file system events cannot be
+// used, as when the inotify watch limit is reached.
+func unsupported(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EMFILE, syscall.ENFILE, syscall.ENOSYS, syscall.EOPNOTSUPP} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) inRecursiveRoot(path string) bool {
+	for root, recursive := range w.roots {
+		if recursive && strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) matches(path string) bool {
+	for _, pattern := range w.patterns {
+		if w.match(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether path, or a directory it is in, matches an ignore
+// pattern.
+func (w *Watcher) ignored(path string) bool {
+	for _, pattern := range w.ignore {
+		for p := path; p != w.cwd && filepath.Dir(p) != p; p = filepath.Dir(p) {
+			if w.match(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *Watcher) match(pattern, path string) bool {
+	return glob.MatchFile(pattern, path, w.cwd)
+}
+
+// display returns path relative to the working directory when possible.
+func (w *Watcher) display(path string) string {
+	if rel, err := filepath.Rel(w.cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
 }
 
 func (w *Watcher) expandPatterns() ([]string, error) {
@@ -85,7 +377,7 @@ func (w *Watcher) expandPatterns() ([]string, error) {
 	seen := make(map[string]bool)
 
 	for _, pattern := range w.patterns {
-		matches, err := filepath.Glob(pattern)
+		matches, err := glob.Glob(pattern)
 		if err != nil {
 			continue
 		}
@@ -96,7 +388,7 @@ func (w *Watcher) expandPatterns() ([]string, error) {
 				continue
 			}
 
-			if !seen[absPath] {
+			if !seen[absPath] && !w.ignored(absPath) {
 				seen[absPath] = true
 				files = append(files, absPath)
 			}
@@ -1,15 +1,17 @@
 package watcher
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 )
 
 func TestNew(t *testing.T) {
 	called := false
-	callback := func() {
+	callback := func([]string) {
 		called = true
 	}
 
@@ -23,7 +25,7 @@ func TestNew(t *testing.T) {
 		t.Fatal("Expected watcher, got nil")
 	}
 
-	if w.watcher == nil {
+	if w.backend == nil {
 		t.Error("Expected fsnotify watcher to be initialized")
 	}
 
@@ -42,7 +44,7 @@ func TestNew(t *testing.T) {
 }
 
 func TestStop(t *testing.T) {
-	w, err := New([]string{"*.txt"}, func() {})
+	w, err := New([]string{"*.txt"}, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -64,7 +66,7 @@ func TestExpandPatterns(t *testing.T) {
 	os.WriteFile(goFile2, []byte("package util"), 0644)
 	os.WriteFile(txtFile, []byte("readme"), 0644)
 
-	w, err := New([]string{filepath.Join(dir, "*.go")}, func() {})
+	w, err := New([]string{filepath.Join(dir, "*.go")}, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -100,7 +102,7 @@ func TestExpandPatternsMultiple(t *testing.T) {
 		filepath.Join(dir, "*.yaml"),
 	}
 
-	w, err := New(patterns, func() {})
+	w, err := New(patterns, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -122,7 +124,7 @@ func TestExpandPatternsDeduplication(t *testing.T) {
 		filepath.Join(dir, "*.go"),
 	}
 
-	w, err := New(patterns, func() {})
+	w, err := New(patterns, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -135,7 +137,7 @@ func TestExpandPatternsDeduplication(t *testing.T) {
 }
 
 func TestExpandPatternsNonExistent(t *testing.T) {
-	w, err := New([]string{"/nonexistent/path/*.go"}, func() {})
+	w, err := New([]string{"/nonexistent/path/*.go"}, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -152,7 +154,7 @@ func TestExpandPatternsNonExistent(t *testing.T) {
 }
 
 func TestWatcherWithLogger(t *testing.T) {
-	w, err := New([]string{"*.go"}, func() {})
+	w, err := New([]string{"*.go"}, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -164,7 +166,7 @@ func TestWatcherWithLogger(t *testing.T) {
 }
 
 func TestWatcherDebounce(t *testing.T) {
-	w, err := New([]string{"*.go"}, func() {})
+	w, err := New([]string{"*.go"}, func([]string) {})
 	if err != nil {
 		t.Fatalf("Failed to create watcher: %v", err)
 	}
@@ -174,3 +176,150 @@ func TestWatcherDebounce(t *testing.T) {
 		t.Errorf("Expected 100ms debounce, got %v", w.debounce)
 	}
 }
+
+// startWatcher runs a watcher with a short debounce and reports the changed
+// files of each callback on the returned channel.
+func startWatcher(t *testing.T, patterns, ignore []string, poll bool) <-chan []string {
+	t.Helper()
+
+	calls := make(chan []string, 10)
+	w, err := New(patterns, func(changed []string) { calls <- changed })
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	w.SetDebounce(20 * time.Millisecond)
+	w.SetIgnore(ignore)
+	if poll {
+		w.SetPollInterval(20 * time.Millisecond)
+		w.UsePolling()
+	}
+	go w.Start()
+	t.Cleanup(func() { w.Stop() })
+
+	time.Sleep(100 * time.Millisecond)
+	return calls
+}
+
+func expectCall(t *testing.T, calls <-chan []string, want bool, what string) []string {
+	t.Helper()
+	select {
+	case changed := <-calls:
+		if !want {
+			t.Errorf("Unexpected callback after %s", what)
+		}
+		return changed
+	case <-time.After(500 * time.Millisecond):
+		if want {
+			t.Errorf("Expected callback after %s", what)
+		}
+	}
+	return nil
+}
+
+func TestWatchNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	calls := startWatcher(t, []string{filepath.Join(dir, "**", "*.go")}, []string{filepath.Join(dir, "vendor")}, false)
+
+	sub := filepath.Join(dir, "pkg", "deep")
+	os.MkdirAll(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "new.go"), []byte("package deep"), 0644)
+	changed := expectCall(t, calls, true, "creating a file in a new directory")
+	if len(changed) != 1 || changed[0] != filepath.Join(sub, "new.go") {
+		t.Errorf("Expected new.go to be reported, got %v", changed)
+	}
+
+	os.WriteFile(filepath.Join(sub, "new.go"), []byte("package deep // edited"), 0644)
+	expectCall(t, calls, true, "writing to a file in a new directory")
+
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	expectCall(t, calls, false, "writing a file that does not match")
+
+	os.MkdirAll(filepath.Join(dir, "vendor", "lib"), 0755)
+	os.WriteFile(filepath.Join(dir, "vendor", "lib", "lib.go"), []byte("package lib"), 0644)
+	expectCall(t, calls, false, "writing an ignored file")
+}
+
+func TestWatchRenameAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	os.WriteFile(file, []byte("package main"), 0644)
+	calls := startWatcher(t, []string{filepath.Join(dir, "*.go")}, nil, false)
+
+	// Editors often save by writing a temporary file and renaming it over
+	// the original.
+	tmp := filepath.Join(dir, ".main.go.tmp")
+	os.WriteFile(tmp, []byte("package main // saved"), 0644)
+	os.Rename(tmp, file)
+	expectCall(t, calls, true, "renaming over a watched file")
+
+	os.WriteFile(file, []byte("package main // saved again"), 0644)
+	expectCall(t, calls, true, "writing after a rename")
+
+	os.Remove(file)
+	expectCall(t, calls, true, "removing a watched file")
+}
+
+func TestWatchPolling(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	os.WriteFile(file, []byte("package main"), 0644)
+	calls := startWatcher(t, []string{filepath.Join(dir, "**", "*.go")}, nil, true)
+
+	os.WriteFile(file, []byte("package main // edited"), 0644)
+	changed := expectCall(t, calls, true, "writing a polled file")
+	if len(changed) != 1 || changed[0] != file {
+		t.Errorf("Expected main.go to be reported, got %v", changed)
+	}
+
+	sub := filepath.Join(dir, "pkg")
+	os.Mkdir(sub, 0755)
+	os.WriteFile(filepath.Join(sub, "new.go"), []byte("package pkg"), 0644)
+	expectCall(t, calls, true, "creating a file in a new polled directory")
+
+	os.WriteFile(filepath.Join(sub, "new.go"), []byte("package pkg // edited"), 0644)
+	expectCall(t, calls, true, "writing to a file in a new polled directory")
+
+	os.Remove(file)
+	expectCall(t, calls, true, "removing a polled file")
+
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	expectCall(t, calls, false, "writing a file that does not match")
+}
+
+func TestFallbackWhileStopping(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New([]string{filepath.Join(dir, "**", "*.go")}, func([]string) {})
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Start() }()
+	time.Sleep(50 * time.Millisecond)
+
+	// The fallback runs where Start adds directories, concurrently with Stop.
+	go func() {
+		w.mu.Lock()
+		w.fallback(syscall.ENOSPC)
+		w.mu.Unlock()
+	}()
+	if err := w.Stop(); err != nil {
+		t.Errorf("Stop failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		// A fallback after Stop must not leave a poller running.
+		w.Stop()
+		t.Fatal("Expected Start to return after Stop")
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if !unsupported(fmt.Errorf("add: %w", syscall.ENOSPC)) {
+		t.Error("Expected ENOSPC to trigger polling")
+	}
+	if unsupported(os.ErrNotExist) {
+		t.Error("Expected a missing directory not to trigger polling")
+	}
+}